@@ -0,0 +1,179 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// validateAfterUpload checks the value of -afterUpload
+func validateAfterUpload() error {
+	switch {
+	case *afterUpload == "keep", *afterUpload == "delete":
+		return nil
+	case strings.HasPrefix(*afterUpload, "move:") && len(*afterUpload) > len("move:"):
+		return nil
+	}
+	return fmt.Errorf("invalid value for -afterUpload '%s', must be 'keep', 'delete' or 'move:<dir>'", *afterUpload)
+}
+
+// profileFailures records the files that failed to upload to one of
+// -profiles, so that they are kept after the last profile
+var profileFailures = struct {
+	sync.Mutex
+	files map[string]bool
+}{files: make(map[string]bool)}
+
+// afterUploadAction carries out -afterUpload for a local file that was
+// uploaded, returning what was done for the summary and history, e.g.
+// "deleted" or "moved to /done/x.mp4". With -profiles it is only done after
+// the file has been uploaded to every profile. A failure is warned about, as
+// the upload itself succeeded.
+func afterUploadAction(ctx context.Context, file string, uploadErr error) string {
+	if *afterUpload == "keep" || file == "-" || isURL(file) {
+		return ""
+	}
+	if len(profileNames) > 0 {
+		profileFailures.Lock()
+		if uploadErr != nil {
+			profileFailures.files[file] = true
+		}
+		failed := profileFailures.files[file]
+		profileFailures.Unlock()
+		if *profile != profileNames[len(profileNames)-1] {
+			return ""
+		}
+		if failed && uploadErr == nil {
+			fmt.Fprintf(output, "Keeping '%s', as its upload to another profile failed\n", file)
+			return ""
+		}
+	}
+	// the file is still needed if the video isn't known to be fine. A
+	// playlist failure happens after processing was waited for, and the
+	// video can be added by hand.
+	if uploadErr != nil && errorCode(uploadErr) != errCodePlaylist {
+		return ""
+	}
+
+	if *afterUpload == "delete" {
+		if err := os.Remove(file); err != nil {
+			warningsOf(ctx).warnf("Error deleting '%s' after uploading it: %s", file, err)
+			return ""
+		}
+		fmt.Fprintf(output, "Deleted '%s'\n", file)
+		return "deleted"
+	}
+
+	dir := strings.TrimPrefix(*afterUpload, "move:")
+	dst := filepath.Join(dir, filepath.Base(file))
+	if err := moveFile(file, dst); err != nil {
+		warningsOf(ctx).warnf("Error moving '%s' to '%s' after uploading it: %s", file, dir, err)
+		return ""
+	}
+	fmt.Fprintf(output, "Moved '%s' to '%s'\n", file, dst)
+	return "moved to " + dst
+}
+
+// moveFile moves src to dst, which mustn't exist. A rename is tried first,
+// which is atomic on the same filesystem. Otherwise the file is copied, the
+// copy is checked against the original, and the original is removed.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("'%s' already exists", dst)
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	// e.g. a different filesystem
+	sum, err := copyFile(src, dst)
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	copied, err := fileSHA256(dst)
+	if err != nil || !bytes.Equal(sum, copied) {
+		os.Remove(dst)
+		if err == nil {
+			err = fmt.Errorf("the copy doesn't match the original")
+		}
+		return fmt.Errorf("error checking the copy: %s", err)
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to a new file dst with the same permissions and
+// modification time, returning the SHA-256 hash of what was read
+func copyFile(src, dst string) ([]byte, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(out, io.TeeReader(in, h))
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+	return h.Sum(nil), nil
+}
+
+// fileSHA256 returns the SHA-256 hash of the file's contents
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// afterUploadNote formats what -afterUpload did for the summary of uploads
+func afterUploadNote(action string) string {
+	if action == "" {
+		return ""
+	}
+	return ", " + action
+}
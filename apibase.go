@@ -0,0 +1,61 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// googleAPIHost is the host of the Youtube API, including its upload
+// endpoints
+const googleAPIHost = "youtube.googleapis.com"
+
+// apiBaseTransport sends requests for the Youtube API to base instead. Paths
+// are kept, so /youtube/v3/videos becomes <base>/youtube/v3/videos and
+// /upload/youtube/v3/videos becomes <base>/upload/youtube/v3/videos.
+type apiBaseTransport struct {
+	rt   http.RoundTripper
+	base *url.URL
+}
+
+func (t *apiBaseTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host != googleAPIHost {
+		return t.rt.RoundTrip(r)
+	}
+	r2 := r.Clone(r.Context())
+	r2.URL.Scheme = t.base.Scheme
+	r2.URL.Host = t.base.Host
+	r2.URL.Path = strings.TrimSuffix(t.base.Path, "/") + r.URL.Path
+	r2.URL.RawPath = ""
+	r2.Host = ""
+	return t.rt.RoundTrip(r2)
+}
+
+// apiBaseOverride wraps rt to send API requests to -apiBaseURL, if it is
+// given
+func apiBaseOverride(rt http.RoundTripper) (http.RoundTripper, error) {
+	if *apiBaseURL == "" {
+		return rt, nil
+	}
+	u, err := url.Parse(*apiBaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid -apiBaseURL '%s': must be an http or https URL", *apiBaseURL)
+	}
+	fmt.Fprintf(promptOutput, "Sending Youtube API requests to %s\n", u)
+	return &apiBaseTransport{rt: rt, base: u}, nil
+}
@@ -0,0 +1,115 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+// reasonHints explains the common reasons given by the API for rejecting a
+// request, with a suggestion of what to do about it
+var reasonHints = map[string]string{
+	"quotaExceeded":           "the API project's daily quota has been used up. Quota resets at midnight Pacific time",
+	"dailyLimitExceeded":      "the API project's daily quota has been used up. Quota resets at midnight Pacific time",
+	"uploadLimitExceeded":     "the channel's daily upload limit has been reached. Uploads reset at midnight Pacific time",
+	"rateLimitExceeded":       "too many requests have been made in a short time. Wait a few minutes and try again",
+	"userRateLimitExceeded":   "too many requests have been made in a short time. Wait a few minutes and try again",
+	"invalidCategoryId":       "the category ID doesn't exist. Check -categoryId, or use -category to give the name",
+	"invalidTitle":            "the title is empty, too long or contains '<' or '>'",
+	"invalidDescription":      "the description is too long or contains '<' or '>'",
+	"invalidTags":             "the tags are too long in total or contain '<' or '>'",
+	"invalidPublishAt":        "publishAt must be in the future and the video must be private",
+	"invalidRecordingDetails": "the recording date or location isn't valid",
+	"defaultLanguageNotSet":   "localizations need the video's -language to be set",
+	"forbidden":               "the account isn't allowed to do this. Check that the right account is authorised (-reauth) and that the channel is verified",
+	"insufficientPermissions": "the cached token wasn't granted the required scope. Run again with -reauth",
+	"authError":               "the authorisation token is invalid or has been revoked. Run again with -reauth",
+	"youtubeSignupRequired":   "the Google account has no Youtube channel. Create one, then authorise again with -reauth",
+	"videoNotFound":           "the video doesn't exist or doesn't belong to the authorised channel",
+	"playlistNotFound":        "the playlist doesn't exist or doesn't belong to the authorised channel",
+	"mediaBodyRequired":       "no video data was received. Check that the source file isn't empty",
+}
+
+// apiErrorReasons returns the reasons of the API error in err's chain
+func apiErrorReasons(err error) []string {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return nil
+	}
+	var reasons []string
+	for _, item := range apiErr.Errors {
+		if item.Reason != "" {
+			reasons = append(reasons, item.Reason)
+		}
+	}
+	return reasons
+}
+
+// apiErrorHint returns a one line explanation of the API error in err's
+// chain, or "" if it isn't one with a known reason. The error itself still
+// includes the full response from the API.
+func apiErrorHint(err error) string {
+	if errors.As(err, new(monetizationError)) {
+		return "only channels in the YouTube Partner Program can set monetization. Remove monetizationAllowed and monetizationExcludedRegions from the metadata"
+	}
+	if errors.As(err, new(premiereError)) {
+		return "scheduling a video needs a verified channel (https://www.youtube.com/verify) and a time in the future. The API can't create Premieres, only schedule the video to be published; set up the Premiere in Studio instead"
+	}
+	var hints []string
+	for _, reason := range apiErrorReasons(err) {
+		if hint, ok := reasonHints[reason]; ok {
+			if dailyLimitReasons[reason] {
+				hint += ", " + describeQuotaReset()
+			}
+			hints = append(hints, hint)
+		}
+	}
+	return strings.Join(hints, "; ")
+}
+
+// monetizationError is the API refusing to set the monetization details of a
+// video, as the account isn't a partner
+type monetizationError struct {
+	err error
+}
+
+func (e monetizationError) Error() string {
+	return fmt.Sprintf("this account cannot set monetization via the API: %v", e.err)
+}
+
+func (e monetizationError) Unwrap() error { return e.err }
+
+// checkMonetizationError returns a monetizationError for err if it is a 403
+// with reason insufficientPermissions from a request that set the monetization
+// details of video, which the API gives for an account that isn't a partner
+// rather than a missing scope
+func checkMonetizationError(video *youtube.Video, err error) error {
+	var apiErr *googleapi.Error
+	if video == nil || video.MonetizationDetails == nil || !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return err
+	}
+	for _, reason := range apiErrorReasons(err) {
+		if reason == "insufficientPermissions" {
+			return monetizationError{err}
+		}
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// loadAPIError returns the error the API client gives for the response in
+// testdata/apierrors
+func loadAPIError(t *testing.T, name string) error {
+	t.Helper()
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "apierrors", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var code struct {
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &code); err != nil {
+		t.Fatal(err)
+	}
+	res := &http.Response{
+		StatusCode: code.Error.Code,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+	err = googleapi.CheckResponse(res)
+	if err == nil {
+		t.Fatalf("%s: no error", name)
+	}
+	return err
+}
+
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		fixture string
+		reason  string
+		// hint is part of the explanation, "" if there is none
+		hint     string
+		exitCode int
+		daily    bool
+	}{
+		{"quotaExceeded", "quotaExceeded", "daily quota has been used up. Quota resets at midnight Pacific time", 4, true},
+		{"uploadLimitExceeded", "uploadLimitExceeded", "daily upload limit has been reached", 4, true},
+		{"invalidTitle", "invalidTitle", "the title is empty, too long", 5, false},
+		{"invalidCategoryId", "invalidCategoryId", "the category ID doesn't exist", 5, false},
+		{"unknownReason", "somethingNew", "", 5, false},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			err := withCode(errCodeUpload, loadAPIError(t, test.fixture))
+			if reasons := apiErrorReasons(err); len(reasons) != 1 || reasons[0] != test.reason {
+				t.Errorf("reasons %v, want [%s]", reasons, test.reason)
+			}
+			hint := apiErrorHint(err)
+			if (test.hint == "" && hint != "") || !strings.Contains(hint, test.hint) {
+				t.Errorf("hint %q, want %q", hint, test.hint)
+			}
+			if code := exitCode(err); code != test.exitCode {
+				t.Errorf("exit code %d, want %d", code, test.exitCode)
+			}
+			if isDailyLimitError(err) != test.daily {
+				t.Errorf("isDailyLimitError = %t, want %t", !test.daily, test.daily)
+			}
+		})
+	}
+}
+
+func TestAPIErrorUnknownReasonKeepsMessage(t *testing.T) {
+	// without a hint, the API's own message is all there is to go on
+	err := loadAPIError(t, "unknownReason")
+	if !strings.Contains(err.Error(), "Something the API has started saying.") {
+		t.Errorf("message missing from %q", err)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"golang.org/x/oauth2"
+)
+
+// jobResult is the outcome of one job of a batch
+type jobResult struct {
+	videoID string
+	err     error
+	done    bool
+	stats   *transferStats
+	// afterUpload is what -afterUpload did with the file
+	afterUpload string
+	// title and url are of the uploaded video, for -notifyDesktop
+	title, url string
+}
+
+// uploadAll uploads the jobs, -concurrency at a time, and prints a summary.
+// Each worker has its own Uploader so that its progress is tracked
+// separately, while -ratelimit is shared between them. A failure doesn't
+// stop the other uploads unless -failFast is given. If any job failed, the
+// error's code is that of the first failure.
+func uploadAll(ctx context.Context, ts oauth2.TokenSource, lr uploader.Window, limit *uploader.Limit, jobs []uploadJob) error {
+	workers := *concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	start := time.Now()
+	results := make([]jobResult, len(jobs))
+	var mu sync.Mutex
+	var next int
+	var stop bool
+
+	// claim returns the index of the next job to upload, or -1
+	claim := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		if stop || next >= len(jobs) || ctx.Err() != nil {
+			return -1
+		}
+		next++
+		return next - 1
+	}
+
+	var pool []*uploadWorker
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		worker := newUploadWorker(lr, limit)
+		pool = append(pool, worker)
+		if err := worker.connect(ts); err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := claim(); i >= 0; i = claim() {
+				job := jobs[i]
+				result, err := runJob(ctx, worker, job)
+
+				mu.Lock()
+				result.Sidecars = job.sidecars
+				results[i] = jobResult{videoID: result.VideoID, err: err, done: true, stats: result.Stats, afterUpload: result.AfterUpload, title: result.Title, url: result.URL}
+				if err != nil {
+					reportError(job.filename, result.VideoID, err)
+					if *failFast {
+						stop = true
+					}
+					// the rest would fail the same way, after a wasted transfer
+					if isDailyLimitError(err) && !stop && next < len(jobs) {
+						stop = true
+						warnf("Daily limit reached, not uploading the remaining %d file(s). Use -retryAfterQuota to wait for it to reset", len(jobs)-next)
+					}
+				} else {
+					writeResult(result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	stopStatus := startStatusServer(pool)
+	stopPause := watchPauseSignals(pool)
+	var quitChan chanChan
+	if workers > 1 && progressOut != nil {
+		quitChan = make(chanChan)
+		go ProgressAll(quitChan, pool)
+	}
+	wg.Wait()
+	stopPause()
+	stopStatus()
+	if quitChan != nil {
+		quit := make(chan struct{})
+		quitChan <- quit
+		<-quit
+	}
+
+	var failed int
+	var failure error
+	var stats transferStats
+	for i, r := range results {
+		stats.add(r.stats)
+		if !r.done || r.err != nil {
+			failed++
+		}
+		if r.err != nil && failure == nil {
+			failure = reportedError{errorCode(r.err)}
+		}
+		if len(jobs) == 1 {
+			continue
+		}
+		switch {
+		case !r.done:
+			fmt.Fprintf(output, "'%s': skipped\n", jobs[i].filename)
+		case r.err != nil && r.videoID != "":
+			fmt.Fprintln(output, colorize(output, styleError, fmt.Sprintf("'%s': video ID %s, error: %s%s", jobs[i].filename, r.videoID, r.err, afterUploadNote(r.afterUpload))))
+		case r.err != nil:
+			fmt.Fprintln(output, colorize(output, styleError, fmt.Sprintf("'%s': error: %s", jobs[i].filename, r.err)))
+		default:
+			fmt.Fprintln(output, colorize(output, styleSuccess, fmt.Sprintf("'%s': video ID %s%s", jobs[i].filename, r.videoID, afterUploadNote(r.afterUpload))))
+		}
+	}
+	if len(jobs) > 1 {
+		fmt.Fprintln(output, colorize(output, styleSummary, fmt.Sprintf("%d succeeded, %d failed", len(jobs)-failed, failed)))
+	}
+	printStats(output, &stats, time.Since(start))
+	printQuotaUsage(output)
+	printTiming(output)
+	notifyDesktop(jobs, results)
+	return failure
+}
+
+// runJob uploads the file of job and does everything that follows: the
+// hooks, notification, -afterUpload, history and manifest journal
+func runJob(ctx context.Context, w *uploadWorker, job uploadJob) (*uploadResult, error) {
+	// the job's warnings are kept apart from those of jobs uploading at the
+	// same time, for its result
+	ctx, warnings := withWarnings(ctx)
+	w.setWarnings(warnings)
+	defer w.setWarnings(nil)
+
+	if len(job.sidecars) > 0 {
+		fmt.Fprintf(output, "Using sidecar files for '%s': %s\n", job.filename, strings.Join(job.sidecars, ", "))
+	}
+	result, skipped, err := uploadUnlessDuplicate(ctx, w, job)
+	for *quotaRetry && isDailyLimitError(err) && result.VideoID == "" {
+		if waitForQuotaReset(ctx, job.filename) != nil {
+			break
+		}
+		result, skipped, err = uploadUnlessDuplicate(ctx, w, job)
+	}
+	if len(profileNames) > 0 {
+		result.Profile = *profile
+	}
+	if !skipped {
+		if hookErr := runHook(ctx, job.filename, result, err); hookErr != nil {
+			err = hookErr
+		}
+		notifyUpload(ctx, job.filename, result, err)
+		emailUpload(ctx, job.filename, result, err)
+		result.AfterUpload = afterUploadAction(ctx, job.filename, err)
+		recordHistory(ctx, job.filename, result, err)
+	}
+	emitResult(job.filename, result, err)
+
+	if job.manifestLine > 0 && result.VideoID != "" && !result.Deleted {
+		markManifestDone(ctx, job, result.VideoID)
+	}
+	result.Warnings = warnings.take()
+	return result, err
+}
@@ -0,0 +1,117 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// listCategories returns the video categories available in region
+func listCategories(service *youtube.Service, region string) ([]*youtube.VideoCategory, error) {
+	response, err := service.VideoCategories.List([]string{"snippet"}).RegionCode(region).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving video categories: %w", err)
+	}
+	return response.Items, nil
+}
+
+// lookupCategory returns the ID of the category in region with the given
+// name, ignoring case. If there isn't exactly one match, the error lists the
+// valid categories.
+func lookupCategory(service *youtube.Service, name, region string) (string, error) {
+	categories, err := listCategories(service, region)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []*youtube.VideoCategory
+	for _, c := range categories {
+		if strings.EqualFold(c.Snippet.Title, name) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0].Id, nil
+	}
+
+	var buf bytes.Buffer
+	if len(matches) == 0 {
+		fmt.Fprintf(&buf, "category '%s' not found in region %s.", name, region)
+	} else {
+		fmt.Fprintf(&buf, "category '%s' is ambiguous in region %s.", name, region)
+	}
+	fmt.Fprintf(&buf, " Valid categories are:\n")
+	for _, c := range categories {
+		fmt.Fprintf(&buf, "  %3s  %s\n", c.Id, c.Snippet.Title)
+	}
+	return "", fmt.Errorf("%s", strings.TrimSuffix(buf.String(), "\n"))
+}
+
+// categoryInfo is a category in the -listCategories JSON output
+type categoryInfo struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	Assignable bool   `json:"assignable"`
+}
+
+// printCategories prints the video categories of region for
+// -listCategories, as a table or in JSON output mode a JSON array
+func printCategories(region string) error {
+	service, err := newService(readOnlyScopes)
+	if err != nil {
+		return err
+	}
+	categories, err := listCategories(service, strings.ToUpper(region))
+	if err != nil {
+		return withCode(errCodeUsage, err)
+	}
+
+	infos := []categoryInfo{}
+	for _, c := range categories {
+		if c.Snippet != nil {
+			infos = append(infos, categoryInfo{ID: c.Id, Title: c.Snippet.Title, Assignable: c.Snippet.Assignable})
+		}
+	}
+	if jsonOutput() {
+		return json.NewEncoder(os.Stdout).Encode(infos)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tASSIGNABLE")
+	var unassignable bool
+	for _, c := range infos {
+		assignable := "yes"
+		if !c.Assignable {
+			// uploads with these categories are rejected
+			assignable = "no *"
+			unassignable = true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.ID, c.Title, assignable)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if unassignable {
+		fmt.Println("* can't be used for uploads")
+	}
+	return nil
+}
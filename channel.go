@@ -0,0 +1,41 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// channelTitle is the title of the authorised account's channel, shown when
+// each upload starts
+var channelTitle string
+
+// checkChannel fetches the channel of the authorised account, so that
+// authorising the wrong account is found before a whole file is sent rather
+// than after
+func checkChannel(service *youtube.Service) error {
+	response, err := service.Channels.List([]string{"snippet"}).Mine(true).Do()
+	if err != nil {
+		return fmt.Errorf("error retrieving the channel of the authorised account: %w", err)
+	}
+	if len(response.Items) == 0 || response.Items[0].Snippet == nil {
+		return errors.New("no channel on this account. Create one on Youtube, or authorise another account with -reauth")
+	}
+	channelTitle = response.Items[0].Snippet.Title
+	return nil
+}
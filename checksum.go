@@ -0,0 +1,121 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// validateChecksum checks the values of -checksum and -checksumPre
+func validateChecksum() error {
+	switch *checksumAlg {
+	case "sha256", "md5", "none":
+	default:
+		return fmt.Errorf("invalid value for -checksum '%s', must be 'sha256', 'md5' or 'none'", *checksumAlg)
+	}
+	if *checksumPre && *checksumAlg == "none" {
+		return fmt.Errorf("-checksumPre requires -checksum")
+	}
+	return nil
+}
+
+// newChecksum returns a hash for -checksum, or nil if it is 'none'
+func newChecksum() hash.Hash {
+	switch *checksumAlg {
+	case "sha256":
+		return sha256.New()
+	case "md5":
+		return md5.New()
+	}
+	return nil
+}
+
+// formatChecksum formats the sum of h as the algorithm and hex digest, e.g.
+// sha256:9f86d0...
+func formatChecksum(h hash.Hash) string {
+	return *checksumAlg + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// fileChecksum returns the -checksum of the file's contents
+func fileChecksum(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newChecksum()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("error computing checksum of '%s': %s", filename, err)
+	}
+	return formatChecksum(h), nil
+}
+
+// sourceChangedError is returned when the checksum of the bytes sent differs
+// from the one computed before the upload by -checksumPre
+type sourceChangedError struct {
+	filename      string
+	before, after string
+}
+
+func (e sourceChangedError) Error() string {
+	return fmt.Sprintf("'%s' changed during the upload: its checksum was %s before and %s as sent", e.filename, e.before, e.after)
+}
+
+// checksumReader hashes the video as it is read for upload. If the checksum
+// from before the upload is known and the bytes read don't match it, the end
+// of the file is an error rather than io.EOF, so that the final chunk isn't
+// sent and Youtube doesn't create a video from a mix of old and new data.
+type checksumReader struct {
+	io.Reader
+	filename string
+	h        hash.Hash
+	expected string
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.h.Write(p[:n])
+	if err == io.EOF && c.expected != "" {
+		if sum := c.sum(); sum != c.expected {
+			return n, sourceChangedError{c.filename, c.expected, sum}
+		}
+	}
+	return n, err
+}
+
+// sum returns the checksum of the bytes read so far
+func (c *checksumReader) sum() string {
+	return formatChecksum(c.h)
+}
+
+// Seek rewinds the file to send it again from the start in a new upload
+// session, starting the checksum again too
+func (c *checksumReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := c.Reader.(io.Seeker)
+	if !ok || offset != 0 || whence != io.SeekStart {
+		return 0, fmt.Errorf("'%s' can't be sent again from the start as only local files can be read twice", c.filename)
+	}
+	n, err := seeker.Seek(offset, whence)
+	if err == nil {
+		c.h.Reset()
+	}
+	return n, err
+}
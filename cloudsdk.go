@@ -0,0 +1,231 @@
+//go:build !nocloud
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// newCloudObject returns the object named by an s3:// or gs:// URL. The
+// clients, and with them the credentials, are only set up once such a URL
+// is used.
+func newCloudObject(ctx context.Context, source string) (cloudObject, error) {
+	scheme, bucket, key, err := parseCloudURL(source)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "gs" {
+		client, err := gcsClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("no credentials for Google Cloud Storage: %s", err)
+		}
+		return &gcsObject{handle: client.Bucket(bucket).Object(key)}, nil
+	}
+	client, err := s3Client(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the AWS configuration: %s", err)
+	}
+	return &s3Object{client: client, bucket: bucket, key: key, region: bucketRegion(ctx, client, bucket)}, nil
+}
+
+// cloudErrorStatus returns the HTTP status of an error from the S3 or Cloud
+// Storage SDK, or 0 if it has none
+func cloudErrorStatus(err error) int {
+	var s3Err interface{ HTTPStatusCode() int }
+	var gcsErr *googleapi.Error
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist):
+		return http.StatusNotFound
+	case errors.As(err, &s3Err):
+		return s3Err.HTTPStatusCode()
+	case errors.As(err, &gcsErr):
+		return gcsErr.Code
+	}
+	return 0
+}
+
+// gcs is the client for gs:// URLs, using the Application Default
+// Credentials
+var gcs struct {
+	once   sync.Once
+	client *storage.Client
+	err    error
+}
+
+func gcsClient(ctx context.Context) (*storage.Client, error) {
+	gcs.once.Do(func() {
+		gcs.client, gcs.err = storage.NewClient(ctx, option.WithScopes(storage.ScopeReadOnly))
+	})
+	return gcs.client, gcs.err
+}
+
+// gcsObject is an object in Google Cloud Storage
+type gcsObject struct {
+	handle *storage.ObjectHandle
+	// generation is the generation of the object first read
+	generation int64
+}
+
+func (o *gcsObject) read(ctx context.Context, offset int64) (io.ReadCloser, int64, string, error) {
+	handle := o.handle
+	if o.generation != 0 {
+		handle = handle.If(storage.Conditions{GenerationMatch: o.generation})
+	}
+	r, err := handle.NewRangeReader(ctx, offset, -1)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	o.generation = r.Attrs.Generation
+	return r, r.Attrs.Size, r.Attrs.ContentType, nil
+}
+
+func (o *gcsObject) check(ctx context.Context) error {
+	_, err := o.handle.Attrs(ctx)
+	return err
+}
+
+// awsS3 is the client for s3:// URLs. The SDK's default configuration finds
+// the credentials in the environment, the shared config and credentials
+// files (including SSO, credential_process and assumed roles) or the
+// instance or container role, like the AWS CLI.
+var awsS3 struct {
+	once   sync.Once
+	client *s3.Client
+	err    error
+
+	mu sync.Mutex
+	// regions are the regions of the buckets that have been looked up
+	regions map[string]string
+}
+
+// s3Endpoint is the S3-compatible endpoint to use instead of AWS, from
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL, which the SDK reads itself
+func s3Endpoint() string {
+	if e := os.Getenv("AWS_ENDPOINT_URL_S3"); e != "" {
+		return e
+	}
+	return os.Getenv("AWS_ENDPOINT_URL")
+}
+
+func s3Client(ctx context.Context) (*s3.Client, error) {
+	awsS3.once.Do(func() {
+		// the SDK's client is kept, as it is needed for AWS_CA_BUNDLE, but
+		// it connects like baseTransport, through -proxy and from -sourceIP
+		// or -interface
+		httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			if baseTransport != nil {
+				tr.Proxy = baseTransport.Proxy
+				tr.DialContext = baseTransport.DialContext
+			}
+		})
+		var cfg aws.Config
+		cfg, awsS3.err = config.LoadDefaultConfig(ctx, config.WithHTTPClient(httpClient))
+		if awsS3.err != nil {
+			return
+		}
+		if cfg.Region == "" {
+			cfg.Region = "us-east-1"
+		}
+		awsS3.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			// S3-compatible services generally don't have a host name for
+			// each bucket
+			o.UsePathStyle = s3Endpoint() != ""
+			// objects without checksums are common, and not worth a message
+			o.DisableLogOutputChecksumValidationSkipped = true
+		})
+	})
+	return awsS3.client, awsS3.err
+}
+
+// bucketRegion returns the region of an S3 bucket, so that it needn't be
+// configured for each bucket. The client's region is used for other
+// S3-compatible services, or if the bucket can't be found.
+func bucketRegion(ctx context.Context, client *s3.Client, bucket string) string {
+	if s3Endpoint() != "" {
+		return client.Options().Region
+	}
+	awsS3.mu.Lock()
+	defer awsS3.mu.Unlock()
+	if region, ok := awsS3.regions[bucket]; ok {
+		return region
+	}
+	region, err := manager.GetBucketRegion(ctx, client, bucket)
+	if err != nil {
+		region = client.Options().Region
+	}
+	if awsS3.regions == nil {
+		awsS3.regions = make(map[string]string)
+	}
+	awsS3.regions[bucket] = region
+	return region
+}
+
+// s3Object is an object in Amazon S3 or an S3-compatible service
+type s3Object struct {
+	client      *s3.Client
+	bucket, key string
+	region      string
+	// etag is the ETag of the object first read
+	etag string
+}
+
+// options makes requests for the object to its bucket's region. A bucket
+// name with dots doesn't match the certificate of *.s3.amazonaws.com, so
+// those are in the path instead.
+func (o *s3Object) options(opts *s3.Options) {
+	opts.Region = o.region
+	if strings.Contains(o.bucket, ".") {
+		opts.UsePathStyle = true
+	}
+}
+
+func (o *s3Object) read(ctx context.Context, offset int64) (io.ReadCloser, int64, string, error) {
+	input := &s3.GetObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(o.key)}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	if o.etag != "" {
+		input.IfMatch = aws.String(o.etag)
+	}
+	out, err := o.client.GetObject(ctx, input, o.options)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	o.etag = aws.ToString(out.ETag)
+	return out.Body, offset + aws.ToInt64(out.ContentLength), aws.ToString(out.ContentType), nil
+}
+
+func (o *s3Object) check(ctx context.Context) error {
+	_, err := o.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(o.key)}, o.options)
+	return err
+}
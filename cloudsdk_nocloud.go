@@ -0,0 +1,32 @@
+//go:build nocloud
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// newCloudObject fails in builds with the nocloud tag, which leave out the
+// AWS and Google Cloud SDKs
+func newCloudObject(ctx context.Context, source string) (cloudObject, error) {
+	return nil, errors.New("s3:// and gs:// URLs aren't supported, as this build has the nocloud tag")
+}
+
+func cloudErrorStatus(err error) int {
+	return 0
+}
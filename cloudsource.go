@@ -0,0 +1,160 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// isCloudURL reports whether filename is an s3:// or gs:// URL of an object
+// in cloud storage
+func isCloudURL(filename string) bool {
+	u, err := url.Parse(filename)
+	return err == nil && (u.Scheme == "s3" || u.Scheme == "gs") && u.Host != "" && strings.TrimPrefix(u.Path, "/") != ""
+}
+
+// cloudObject is an object in cloud storage, read through the cloud's SDK
+// with its default credentials
+type cloudObject interface {
+	// read returns the object from byte offset on, with its size and
+	// Content-Type. Once the object has been read, later reads fail if it
+	// has changed.
+	read(ctx context.Context, offset int64) (body io.ReadCloser, size int64, contentType string, err error)
+	// check fetches the object's metadata, to find out if it can be read
+	check(ctx context.Context) error
+}
+
+// parseCloudURL returns the bucket and object named by an s3:// or gs:// URL
+func parseCloudURL(source string) (scheme, bucket, key string, err error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", "", "", err
+	}
+	return u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// openCloud opens an object named by an s3:// or gs:// URL
+func openCloud(source string) (io.ReadCloser, int64, error) {
+	ctx := context.Background()
+	obj, err := newCloudObject(ctx, source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening %s: %s", source, err)
+	}
+	body, size, contentType, err := obj.read(ctx, 0)
+	if err != nil {
+		return nil, 0, cloudError(source, err)
+	}
+	return &cloudReader{source: source, obj: obj, body: body, size: size, contentType: contentType}, size, nil
+}
+
+// checkCloudAccess fetches the metadata of an object named by an s3:// or
+// gs:// URL, so that missing credentials or permissions are reported before
+// anything is sent to Youtube. Other problems are reported when the object
+// is opened.
+func checkCloudAccess(source string) error {
+	ctx := context.Background()
+	obj, err := newCloudObject(ctx, source)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", source, err)
+	}
+	if err := obj.check(ctx); err != nil {
+		if status := cloudErrorStatus(err); status == http.StatusUnauthorized || status == http.StatusForbidden {
+			return cloudError(source, err)
+		}
+	}
+	return nil
+}
+
+// cloudError describes an error reading an object in cloud storage
+func cloudError(source string, err error) error {
+	switch cloudErrorStatus(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if strings.HasPrefix(source, "gs:") {
+			return fmt.Errorf("source fetch unauthorized: %s: %s. Check that the Application Default Credentials can read the object", source, err)
+		}
+		return fmt.Errorf("source fetch unauthorized: %s: %s. Check that the AWS credentials can read the object", source, err)
+	case http.StatusNotFound:
+		return fmt.Errorf("error opening %s: no such object", source)
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("error reading %s: the object changed while it was being read", source)
+	}
+	return fmt.Errorf("error opening %s: %s", source, err)
+}
+
+// cloudReader reads an object in cloud storage, reading again from where it
+// left off if the connection fails
+type cloudReader struct {
+	source string
+	obj    cloudObject
+	body   io.ReadCloser
+	offset int64
+	size   int64
+	// contentType is the Content-Type of the object
+	contentType string
+	// reconnects is the number of times the download was continued
+	reconnects int
+	// warnings are those of the upload the source is read for
+	warnings *warningList
+}
+
+func (r *cloudReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	if rerr := r.reconnect(err); rerr != nil {
+		return n, rerr
+	}
+	return n, nil
+}
+
+// reconnect continues the download at the current offset after err
+func (r *cloudReader) reconnect(err error) error {
+	r.body.Close()
+	for attempt := 1; ; attempt++ {
+		if attempt > *sourceRetries {
+			return fmt.Errorf("error reading %s at byte %d, giving up after %d reconnects: %s", r.source, r.offset, *sourceRetries, err)
+		}
+		r.warnings.warnf("Error reading %s at byte %d, reconnecting: %s", r.source, r.offset, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+
+		var body io.ReadCloser
+		body, _, _, err = r.obj.read(context.Background(), r.offset)
+		if err == nil {
+			r.body = body
+			r.reconnects++
+			return nil
+		}
+		// a missing or changed object won't come back
+		switch cloudErrorStatus(err) {
+		case http.StatusNotFound, http.StatusPreconditionFailed:
+			return cloudError(r.source, err)
+		}
+	}
+}
+
+func (r *cloudReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,105 @@
+//go:build !nocloud
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestS3Source(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/videos/clip.mp4" {
+			http.NotFound(w, r)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+			t.Errorf("request signed with %q", auth)
+		}
+		requests = append(requests, r.Method+" "+r.Header.Get("Range")+" "+r.Header.Get("If-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == "HEAD" {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			return
+		}
+		var offset int
+		if rng := r.Header.Get("Range"); rng != "" {
+			fmt.Sscanf(rng, "bytes=%d-", &offset)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(data)-1, len(data)))
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)-offset))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[offset:])
+			return
+		}
+		// the first response breaks off part of the way through
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Write(data[:len(data)/3])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	if err := checkSourceAccess("s3://bucket/videos/clip.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	r, size, err := Open("s3://bucket/videos/clip.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if size != int64(len(data)) {
+		t.Errorf("got size %d, want %d", size, len(data))
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes that differ from the object", len(got))
+	}
+	if reconnects := r.(*cloudReader).reconnects; reconnects != 1 {
+		t.Errorf("got %d reconnects, want 1", reconnects)
+	}
+	// the download continues where it broke off, as long as the object is
+	// unchanged
+	want := []string{"HEAD  ", "GET  ", fmt.Sprintf(`GET bytes=%d- "v1"`, len(data)/3)}
+	if strings.Join(requests, "|") != strings.Join(want, "|") {
+		t.Errorf("got requests %q, want %q", requests, want)
+	}
+
+	if _, _, err := Open("s3://bucket/missing.mp4"); err == nil || !strings.Contains(err.Error(), "no such object") {
+		t.Errorf("missing object: got %v", err)
+	}
+}
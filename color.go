@@ -0,0 +1,64 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// ANSI styles of the output
+const (
+	styleSuccess = "32" // green
+	styleWarning = "33" // yellow
+	styleError   = "31" // red
+	styleSummary = "1"  // bold
+)
+
+// the values of -color
+var colorValues = []string{"auto", "always", "never"}
+
+// whether to colour what is written to stdout and to stderr
+var colorStdout, colorStderr bool
+
+// setColor decides from -color and NO_COLOR whether to colour the output.
+// By default it is coloured when it goes to a terminal and NO_COLOR isn't
+// set, see https://no-color.org/.
+func setColor() error {
+	*colorMode = normalizeEnum(*colorMode, colorValues)
+	if err := validateEnum("-color", *colorMode, colorValues); err != nil {
+		return err
+	}
+	switch *colorMode {
+	case "always":
+		colorStdout, colorStderr = true, true
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return nil
+		}
+		colorStdout = isTerminal(os.Stdout) && enableColor(os.Stdout)
+		colorStderr = isTerminal(os.Stderr) && enableColor(os.Stderr)
+	}
+	return nil
+}
+
+// colorize returns s in style if the output written to w is coloured. The
+// progress line is never coloured.
+func colorize(w io.Writer, style, s string) string {
+	if !(w == os.Stdout && colorStdout || w == os.Stderr && colorStderr) || s == "" {
+		return s
+	}
+	return "\x1b[" + style + "m" + s + "\x1b[0m"
+}
@@ -0,0 +1,170 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// envPrefix is the prefix of environment variables that set flags e.g.
+// YOUTUBEUPLOADER_RATELIMIT for -ratelimit
+const envPrefix = "YOUTUBEUPLOADER_"
+
+// configOnlyFlags can't be set by the config file or environment
+var configOnlyFlags = map[string]bool{"config": true, "printConfig": true, "v": true, "showHistory": true}
+
+// secretFlags are redacted by -printConfig. Webhook URLs usually carry their
+// token in the path, so -notifyURL is one of them.
+var secretFlags = map[string]bool{"sourceBasicAuth": true, "sourceHeader": true, "serveToken": true, "notifyURL": true}
+
+// urlFlags are URLs whose password, if any, is redacted by -printConfig
+var urlFlags = map[string]bool{"proxy": true}
+
+// cmdlineFlags are the names of the flags given on the command line, before
+// the config file and environment were applied
+var cmdlineFlags map[string]bool
+
+// applyConfig sets the flags that weren't given on the command line from
+// the YOUTUBEUPLOADER_* environment variables, then from the config file.
+func applyConfig() error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	cmdlineFlags = explicit
+
+	values, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	var err2 error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err2 != nil || explicit[f.Name] || configOnlyFlags[f.Name] {
+			return
+		}
+		if env, ok := os.LookupEnv(envPrefix + strings.ToUpper(f.Name)); ok {
+			if err := flag.Set(f.Name, env); err != nil {
+				err2 = fmt.Errorf("invalid value for %s%s: %s", envPrefix, strings.ToUpper(f.Name), err)
+			}
+			return
+		}
+		if raw, ok := values[f.Name]; ok {
+			if err := setFromConfig(f.Name, raw); err != nil {
+				err2 = fmt.Errorf("invalid value for '%s' in config file: %s", f.Name, err)
+			}
+		}
+	})
+	return err2
+}
+
+// readConfigFile reads the -config file, or config.json in the config
+// directory if it exists. Every key must be the name of a flag.
+func readConfigFile() (map[string]json.RawMessage, error) {
+	filename := *configFile
+	if filename == "" {
+		dir, err := profileDir()
+		if err != nil {
+			return nil, nil
+		}
+		filename = filepath.Join(dir, "config.json")
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("error parsing config file '%s': %s", filename, err)
+	}
+	var unknown []string
+	for name := range values {
+		if flag.Lookup(name) == nil || configOnlyFlags[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown option(s) in config file '%s': %s", filename, strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+// setFromConfig sets the named flag from a config file value, which may be
+// a string, number or boolean, or an array of them for flags that may be
+// repeated
+func setFromConfig(name string, raw json.RawMessage) error {
+	var list []interface{}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		list = []interface{}{v}
+	}
+	for _, v := range list {
+		switch v.(type) {
+		case string, float64, bool:
+		default:
+			return fmt.Errorf("must be a string, number or boolean")
+		}
+		value, ok := v.(string)
+		if !ok {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			value = string(b)
+		}
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printConfig prints the value of every flag after the command line,
+// environment and config file are applied, with secrets redacted
+func printConfig() error {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if configOnlyFlags[f.Name] {
+			return
+		}
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "REDACTED"
+		}
+		if u, err := url.Parse(value); urlFlags[f.Name] && err == nil {
+			value = u.Redacted()
+		}
+		values[f.Name] = value
+	})
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(values)
+}
@@ -0,0 +1,107 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// previewDescriptionLines is the number of lines of the description shown
+// by -confirm
+const previewDescriptionLines = 3
+
+// confirmJobs prints a preview of each upload for -confirm or -yes and, for
+// -confirm without -yes, asks whether to go ahead
+func confirmJobs(jobs []uploadJob) error {
+	if !*confirm && !*assumeYes {
+		return nil
+	}
+	interactive := *confirm && !*assumeYes
+	if interactive && !isTerminal(os.Stdin) {
+		return withCode(errCodeUsage, errors.New("-confirm needs a terminal to answer on. Use -yes to upload without asking"))
+	}
+
+	fmt.Fprintf(promptOutput, "Uploading to channel '%s':\n", channelTitle)
+	for _, job := range jobs {
+		if interactive && job.filename == "-" {
+			return withCode(errCodeUsage, errors.New("-confirm can't ask for an answer while the video is read from stdin. Use -yes to upload without asking"))
+		}
+		if err := previewJob(promptOutput, job); err != nil {
+			return withCode(errCodeUsage, err)
+		}
+	}
+	if !interactive {
+		return nil
+	}
+
+	fmt.Fprintf(promptOutput, "Upload %d file(s)? [y/N] ", len(jobs))
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("error reading answer: %s", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("upload cancelled")
+}
+
+// previewJob prints the metadata the file of job would be uploaded with
+func previewJob(w io.Writer, job uploadJob) error {
+	filesize := *filesizeHint
+	if job.filename != "-" {
+		reader, size, err := Open(job.filename)
+		if err != nil {
+			return err
+		}
+		reader.Close()
+		filesize = size
+	}
+	video, err := job.video(context.Background(), filesize)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n'%s'", job.filename)
+	if filesize > 0 {
+		fmt.Fprintf(w, " (%d bytes)", filesize)
+	}
+	fmt.Fprintln(w)
+	printVideoSummary(w, video)
+	if d := video.Snippet.Description; d != "" {
+		lines := strings.Split(d, "\n")
+		if len(lines) > previewDescriptionLines {
+			lines = append(lines[:previewDescriptionLines], "...")
+		}
+		fmt.Fprintf(w, "  description: %s\n", strings.Join(lines, "\n               "))
+	}
+	if thumb := job.thumbnailFile(); thumb != "" {
+		fmt.Fprintf(w, "  thumbnail: %s\n", thumb)
+	}
+	lists := append(job.playlistIDs(), job.playlistTitles()...)
+	if len(lists) > 0 {
+		fmt.Fprintf(w, "  playlists: %s\n", strings.Join(lists, ", "))
+	}
+	if note := fileMetaNote(job.filename); note != "" {
+		fmt.Fprintf(w, "  (%s)\n", note)
+	}
+	return nil
+}
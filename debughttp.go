@@ -0,0 +1,229 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+// maxDebugBody is the largest body that -debugHTTP logs. Larger bodies, and
+// those that aren't text, are summarised by their size.
+const maxDebugBody = 64 << 10
+
+// redactedHeaders are never logged by -debugHTTP. The -sourceHeader headers
+// are redacted as well, as they may be secret.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// urlHeaders hold URLs, which are logged with secret query parameters
+// redacted like the URLs of requests. The Location of the response starting a
+// resumable upload is its session URI, which anyone can send data to.
+var urlHeaders = map[string]bool{
+	"Location":                  true,
+	"Content-Location":          true,
+	"Referer":                   true,
+	"X-Goog-Upload-Url":         true,
+	"X-Goog-Upload-Control-Url": true,
+}
+
+// apiHosts are the hosts of the Youtube API and Google's OAuth endpoints
+var apiHosts = map[string]bool{
+	googleAPIHost:           true,
+	"www.googleapis.com":    true,
+	"oauth2.googleapis.com": true,
+	"accounts.google.com":   true,
+}
+
+// redactedFields are the form and JSON fields of OAuth requests and
+// responses that hold secrets
+var redactedFields = []string{"access_token", "refresh_token", "id_token", "client_secret", "code", "device_code", "assertion", "upload_id"}
+
+var redactedJSON = regexp.MustCompile(`("(?:` + strings.Join(redactedFields, "|") + `)"\s*:\s*)"[^"]*"`)
+
+// debugTransport logs the requests made with rt for -debugHTTP
+type debugTransport struct {
+	rt  http.RoundTripper
+	log *log.Logger
+	// next numbers the requests, so that responses can be matched up
+	next int64
+}
+
+// debugOverride wraps rt to log requests if -debugHTTP is given, to stderr
+// or the -debugLog file
+func debugOverride(rt http.RoundTripper) (http.RoundTripper, error) {
+	if !*debugHTTP {
+		return rt, nil
+	}
+	var w io.Writer = os.Stderr
+	if *debugLog != "" {
+		f, err := os.OpenFile(*debugLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("error opening -debugLog: %s", err)
+		}
+		w = f
+	}
+	return &debugTransport{rt: rt, log: log.New(w, "", log.LstdFlags|log.Lmicroseconds)}, nil
+}
+
+func (t *debugTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	id := atomic.AddInt64(&t.next, 1)
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d --> %s %s\n", id, r.Method, redactURL(r.URL))
+	writeHeaders(&b, r.Header)
+	if r.Body != nil && r.ContentLength != 0 {
+		if uploader.IsMediaUpload(r) || !isText(r.Header.Get("Content-Type")) || r.ContentLength < 0 || r.ContentLength > maxDebugBody {
+			fmt.Fprintf(&b, "  <%d bytes>\n", r.ContentLength)
+		} else {
+			body, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			fmt.Fprintf(&b, "  %s\n", redactBody(r.Header.Get("Content-Type"), body))
+		}
+	}
+	t.log.Print(b.String())
+
+	start := time.Now()
+	res, err := t.rt.RoundTrip(r)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		t.log.Printf("#%d <-- error after %s: %v\n", id, elapsed, err)
+		return res, err
+	}
+
+	b.Reset()
+	fmt.Fprintf(&b, "#%d <-- %s (%s)\n", id, res.Status, elapsed)
+	writeHeaders(&b, res.Header)
+	if isText(res.Header.Get("Content-Type")) && res.ContentLength <= maxDebugBody {
+		body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxDebugBody+1))
+		if err == nil && len(body) <= maxDebugBody {
+			res.Body.Close()
+			res.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if len(body) > 0 {
+				fmt.Fprintf(&b, "  %s\n", redactBody(res.Header.Get("Content-Type"), body))
+			}
+		} else {
+			// too long to log after all, so put back what was read
+			res.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+			fmt.Fprintf(&b, "  <more than %d bytes>\n", maxDebugBody)
+		}
+	} else if res.ContentLength != 0 {
+		fmt.Fprintf(&b, "  <%d bytes>\n", res.ContentLength)
+	}
+	t.log.Print(b.String())
+	return res, nil
+}
+
+// writeHeaders writes h in order, with secret values redacted
+func writeHeaders(b *strings.Builder, h http.Header) {
+	var names []string
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range h[name] {
+			if redactedHeaders[http.CanonicalHeaderKey(name)] || sourceHeaders.Get(name) != "" {
+				v = "<redacted>"
+			} else if urlHeaders[http.CanonicalHeaderKey(name)] {
+				if u, err := url.Parse(v); err == nil {
+					v = redactURL(u)
+				} else {
+					v = "<redacted>"
+				}
+			}
+			fmt.Fprintf(b, "  %s: %s\n", name, v)
+		}
+	}
+}
+
+// redactURL returns u without the values of secret query parameters or a
+// password. Only the host of URLs that aren't Google's is shown, as the
+// -notifyURL webhook and URL sources may have a token in the path or a
+// presigned signature in the query.
+func redactURL(u *url.URL) string {
+	r := *u
+	if r.User != nil {
+		r.User = url.User("<redacted>")
+	}
+	if !isGoogleHost(r.Hostname()) {
+		if r.Path == "" && r.RawQuery == "" {
+			return r.String()
+		}
+		return (&url.URL{Scheme: r.Scheme, User: r.User, Host: r.Host}).String() + "/<redacted>"
+	}
+	query := r.Query()
+	for _, f := range redactedFields {
+		if query.Get(f) != "" {
+			query.Set(f, "redacted")
+		}
+	}
+	r.RawQuery = query.Encode()
+	return r.String()
+}
+
+// isGoogleHost reports whether host is one the Youtube API and OAuth
+// requests are sent to. Other Google hosts, such as storage.googleapis.com,
+// aren't included, as their URLs may be presigned.
+func isGoogleHost(host string) bool {
+	return apiHosts[strings.ToLower(host)]
+}
+
+// redactBody returns a form or JSON body without the values of secret fields
+func redactBody(contentType string, body []byte) string {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "<unparseable form>"
+		}
+		for _, f := range redactedFields {
+			if form.Get(f) != "" {
+				form.Set(f, "redacted")
+			}
+		}
+		return form.Encode()
+	}
+	return redactedJSON.ReplaceAllString(string(body), `$1"<redacted>"`)
+}
+
+// isText reports whether a body of contentType can be logged
+func isText(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") ||
+		strings.HasPrefix(contentType, "application/x-www-form-urlencoded") ||
+		strings.HasPrefix(contentType, "text/")
+}
@@ -0,0 +1,99 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// desktopTimeout is how long the command showing a desktop notification is
+// given
+const desktopTimeout = 10 * time.Second
+
+// errNoDesktopNotifier is returned when there is no way to show a
+// notification on this system
+var errNoDesktopNotifier = errors.New("no desktop notification mechanism was found")
+
+// desktopNotifier shows notifications on the desktop. Each platform has its
+// own, returned by newDesktopNotifier.
+type desktopNotifier interface {
+	notify(ctx context.Context, title, message string) error
+}
+
+// notifyDesktop shows the outcome of the uploads of jobs in a desktop
+// notification for -notifyDesktop. Where no notification can be shown, the
+// terminal bell is rung instead.
+func notifyDesktop(jobs []uploadJob, results []jobResult) {
+	if !*notifyDesk || len(jobs) == 0 {
+		return
+	}
+	title, message := desktopSummary(jobs, results)
+
+	ctx, cancel := context.WithTimeout(context.Background(), desktopTimeout)
+	defer cancel()
+	err := newDesktopNotifier().notify(ctx, title, message)
+	if err == nil {
+		return
+	}
+	if isTerminal(os.Stderr) {
+		fmt.Fprint(os.Stderr, "\a")
+	}
+	fmt.Fprintf(output, "Cannot show a desktop notification (%s): %s\n", err, title)
+}
+
+// desktopSummary returns the title and message of the desktop notification:
+// the video's title and URL for a single upload, or how many succeeded and
+// the first error for a batch
+func desktopSummary(jobs []uploadJob, results []jobResult) (string, string) {
+	var failed int
+	var firstErr string
+	for i, r := range results {
+		if !r.done || r.err != nil {
+			failed++
+		}
+		if r.err != nil && firstErr == "" {
+			firstErr = fmt.Sprintf("%s: %s", displayName(jobs[i].filename), r.err)
+		}
+	}
+	if len(jobs) == 1 {
+		r := results[0]
+		switch {
+		case r.err != nil:
+			return "Upload failed", firstErr
+		case !r.done:
+			return "Upload cancelled", displayName(jobs[0].filename)
+		}
+		return "Uploaded '" + r.title + "'", r.url
+	}
+	if failed == 0 {
+		return fmt.Sprintf("Uploaded %d videos", len(jobs)), ""
+	}
+	title := fmt.Sprintf("%d of %d uploads failed", failed, len(jobs))
+	return title, firstErr
+}
+
+// displayName returns the last element of a path or URL, to keep
+// notifications short
+func displayName(filename string) string {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 && i < len(filename)-1 {
+		return filename[i+1:]
+	}
+	return filename
+}
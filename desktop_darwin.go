@@ -0,0 +1,40 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// macNotifier uses osascript. The title and message are passed as
+// arguments, so that they needn't be quoted for AppleScript.
+type macNotifier struct{}
+
+func newDesktopNotifier() desktopNotifier {
+	return macNotifier{}
+}
+
+func (macNotifier) notify(ctx context.Context, title, message string) error {
+	path, err := exec.LookPath("osascript")
+	if err != nil {
+		return errNoDesktopNotifier
+	}
+	return exec.CommandContext(ctx, path,
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, message).Run()
+}
@@ -0,0 +1,30 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "context"
+
+// noNotifier is used where there is no known way to show a notification
+type noNotifier struct{}
+
+func newDesktopNotifier() desktopNotifier {
+	return noNotifier{}
+}
+
+func (noNotifier) notify(ctx context.Context, title, message string) error {
+	return errNoDesktopNotifier
+}
@@ -0,0 +1,44 @@
+//go:build dragonfly || freebsd || linux || netbsd || openbsd
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// freedesktopNotifier uses notify-send, or gdbus to call the notification
+// service of the session bus directly where it isn't installed
+type freedesktopNotifier struct{}
+
+func newDesktopNotifier() desktopNotifier {
+	return freedesktopNotifier{}
+}
+
+func (freedesktopNotifier) notify(ctx context.Context, title, message string) error {
+	if path, err := exec.LookPath("notify-send"); err == nil {
+		return exec.CommandContext(ctx, path, "--app-name=youtubeuploader", title, message).Run()
+	}
+	if path, err := exec.LookPath("gdbus"); err == nil {
+		return exec.CommandContext(ctx, path, "call", "--session",
+			"--dest=org.freedesktop.Notifications",
+			"--object-path=/org/freedesktop/Notifications",
+			"--method=org.freedesktop.Notifications.Notify",
+			"youtubeuploader", "0", "", title, message, "[]", "{}", "-1").Run()
+	}
+	return errNoDesktopNotifier
+}
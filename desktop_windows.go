@@ -0,0 +1,51 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast notification with the title and message from
+// the environment, so that they needn't be quoted for PowerShell. Toasts
+// must come from a registered application, so PowerShell's ID is used.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:YOUTUBEUPLOADER_TITLE)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode($env:YOUTUBEUPLOADER_MESSAGE)) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe').Show($toast)
+`
+
+// toastNotifier uses PowerShell to show a toast
+type toastNotifier struct{}
+
+func newDesktopNotifier() desktopNotifier {
+	return toastNotifier{}
+}
+
+func (toastNotifier) notify(ctx context.Context, title, message string) error {
+	path, err := exec.LookPath("powershell")
+	if err != nil {
+		return errNoDesktopNotifier
+	}
+	cmd := exec.CommandContext(ctx, path, "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "YOUTUBEUPLOADER_TITLE="+title, "YOUTUBEUPLOADER_MESSAGE="+message)
+	return cmd.Run()
+}
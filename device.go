@@ -0,0 +1,159 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// endpoints of Google's OAuth 2.0 device authorization grant
+const (
+	deviceCodeURL  = "https://oauth2.googleapis.com/device/code"
+	deviceTokenURL = "https://oauth2.googleapis.com/token"
+	deviceGrant    = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// deviceCode is the response to a device code request
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	Error           string `json:"error"`
+}
+
+// deviceTokenResponse is the response to a token request. Error is set
+// while the user hasn't finished authorising.
+type deviceTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// deviceToken does the OAuth device flow for -authDevice: the user enters a
+// code at a URL on any device, while the token endpoint is polled until they
+// have done so.
+func deviceToken(ctx context.Context, config *oauth2.Config, scopes []string) (*oauth2.Token, error) {
+	var code deviceCode
+	err := postOAuthForm(ctx, deviceCodeURL, url.Values{
+		"client_id": {config.ClientID},
+		"scope":     {strings.Join(scopes, " ")},
+	}, &code)
+	if err == nil && code.Error == "invalid_scope" {
+		err = errors.New("invalid_scope, the device flow only allows some scopes")
+	} else if err == nil && code.Error != "" {
+		err = errors.New(code.Error)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error requesting device code: %w", err)
+	}
+
+	expiry := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	fmt.Fprintf(promptOutput, "Visit %s on any device and enter the code %s. The code expires in %s\n",
+		code.VerificationURL, code.UserCode, time.Until(expiry).Round(time.Second))
+
+	f, ok := promptOutput.(*os.File)
+	countdown := ok && isTerminal(f)
+	if countdown {
+		// end the countdown line
+		defer fmt.Fprintln(promptOutput)
+	}
+	for {
+		remaining := time.Until(expiry).Round(time.Second)
+		if countdown {
+			fmt.Fprintf(promptOutput, "\rWaiting for authorisation, the code expires in %s ", remaining)
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		var res deviceTokenResponse
+		err := postOAuthForm(ctx, deviceTokenURL, url.Values{
+			"client_id":     {config.ClientID},
+			"client_secret": {config.ClientSecret},
+			"device_code":   {code.DeviceCode},
+			"grant_type":    {deviceGrant},
+		}, &res)
+		if err != nil {
+			return nil, fmt.Errorf("error requesting device token: %w", err)
+		}
+		switch res.Error {
+		case "":
+			return &oauth2.Token{
+				AccessToken:  res.AccessToken,
+				RefreshToken: res.RefreshToken,
+				TokenType:    res.TokenType,
+				Expiry:       time.Now().Add(time.Duration(res.ExpiresIn) * time.Second),
+			}, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "expired_token":
+			return nil, errors.New("the device code expired before authorisation was given, run again to get a new code")
+		case "access_denied":
+			return nil, errors.New("authorisation was denied")
+		default:
+			return nil, fmt.Errorf("device authorisation failed: %s %s", res.Error, res.ErrorDescription)
+		}
+	}
+}
+
+// postOAuthForm posts form to endpoint and decodes the JSON response into v.
+// Error responses are JSON with an error field, so they are decoded too.
+func postOAuthForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	client := http.DefaultClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && c != nil {
+		client = c
+	}
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		if resp.StatusCode >= 300 {
+			return errors.New(resp.Status)
+		}
+		return fmt.Errorf("invalid response: %s", err)
+	}
+	return nil
+}
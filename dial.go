@@ -0,0 +1,105 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// dialOverride replaces the dialer of rt, which must be an *http.Transport,
+// to bind outgoing connections to -sourceIP and/or -interface, and to
+// restrict them to IPv4 or IPv6 with -force4 or -force6. rt is returned
+// unchanged if none of them are given.
+func dialOverride(rt http.RoundTripper) (http.RoundTripper, error) {
+	if *sourceIP == "" && *bindIface == "" && !*force4 && !*force6 {
+		return rt, nil
+	}
+	if *force4 && *force6 {
+		return nil, fmt.Errorf("-force4 and -force6 can't both be given")
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	network := ""
+	switch {
+	case *force4:
+		network = "tcp4"
+	case *force6:
+		network = "tcp6"
+	}
+
+	if *sourceIP != "" {
+		ip, err := localIP(*sourceIP)
+		if err != nil {
+			return nil, err
+		}
+		// connections from an address can only go to the same family
+		family := "tcp6"
+		if ip.To4() != nil {
+			family = "tcp4"
+		}
+		if (*force4 && family != "tcp4") || (*force6 && family != "tcp6") {
+			return nil, fmt.Errorf("-sourceIP %s is of the wrong address family for -force4 or -force6", ip)
+		}
+		network = family
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	if *bindIface != "" {
+		if _, err := net.InterfaceByName(*bindIface); err != nil {
+			return nil, fmt.Errorf("invalid -interface '%s': %s", *bindIface, err)
+		}
+		control, err := bindToDevice(*bindIface)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Control = control
+	}
+
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("can't set the source address of connections with this transport")
+	}
+	t = t.Clone()
+	t.DialContext = func(ctx context.Context, n, addr string) (net.Conn, error) {
+		if network != "" && n == "tcp" {
+			n = network
+		}
+		return dialer.DialContext(ctx, n, addr)
+	}
+	return t, nil
+}
+
+// localIP parses addr and checks that it is assigned to this machine, so
+// that a mistake is found before anything is sent
+func localIP(addr string) (net.IP, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid -sourceIP '%s': not an IP address", addr)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("can't list the addresses of this machine for -sourceIP: %s", err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid -sourceIP '%s': the address isn't assigned to this machine", addr)
+}
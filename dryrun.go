@@ -0,0 +1,150 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// checkJobs performs the local checks of a dry run: that each file and its
+// thumbnail and captions can be opened, and that the metadata is valid once
+// templates are expanded. Every problem found is reported.
+func checkJobs(jobs []uploadJob) error {
+	var problems validationError
+
+	// jobs share metadata unless it came from a sidecar file
+	checked := make(map[*youtube.Video]bool)
+	for _, job := range jobs {
+		// publishAt in the past is normally a warning, as the video is
+		// published immediately instead
+		if publishAt := job.videoMeta.PublishAt; !checked[job.upload] && !publishAt.IsZero() && publishAt.Before(time.Now()) {
+			problems = append(problems, fmt.Sprintf("'%s': publishAt %s is in the past", job.filename, publishAt.Local()))
+		}
+		checked[job.upload] = true
+
+		reader, filesize, err := Open(job.filename)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		// stdin is checked as it is uploaded, as it can only be read once
+		if job.filename != "-" {
+			if _, err := checkVideoSource(job.filename, reader); err != nil {
+				problems = append(problems, err.Error())
+			} else if err := checkShorts(context.Background(), job.filename, reader); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		reader.Close()
+
+		if _, err := job.video(context.Background(), filesize); err != nil {
+			if v, ok := err.(validationError); ok {
+				for _, p := range v {
+					problems = append(problems, fmt.Sprintf("'%s': %s", job.filename, p))
+				}
+			} else {
+				problems = append(problems, fmt.Sprintf("'%s': %s", job.filename, err))
+			}
+		}
+
+		if thumbFile := job.thumbnailFile(); thumbFile != "" {
+			if _, err := LoadThumbnail(thumbFile); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+
+		for _, c := range job.captions() {
+			reader, _, err := Open(c.File)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			reader.Close()
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// printDryRun verifies the category with the API and prints the metadata
+// that would be sent for each file
+func printDryRun(service *youtube.Service, jobs []uploadJob) error {
+	fmt.Fprintf(output, "Would upload to channel '%s'\n", channelTitle)
+	if len(jobs) > 0 && jobs[0].upload.Snippet.CategoryId != "" {
+		categories, err := listCategories(service, *categoryRegion)
+		if err != nil {
+			return err
+		}
+		id := jobs[0].upload.Snippet.CategoryId
+		found := false
+		for _, c := range categories {
+			if c.Id == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("category ID '%s' doesn't exist in region %s", id, *categoryRegion)
+		}
+	}
+
+	videos := make([]*youtube.Video, len(jobs))
+	for i, job := range jobs {
+		reader, filesize, err := Open(job.filename)
+		if err != nil {
+			return err
+		}
+		reader.Close()
+		videos[i], err = job.video(context.Background(), filesize)
+		if err != nil {
+			return err
+		}
+	}
+	if len(jobs) > 1 {
+		// the titles together make mistakes in numbering easy to spot
+		fmt.Fprintf(output, "Titles:\n")
+		for i, job := range jobs {
+			fmt.Fprintf(output, "  %d/%d '%s': %s\n", job.index, job.total, job.filename, videos[i].Snippet.Title)
+		}
+	}
+
+	for i, job := range jobs {
+		video := videos[i]
+		fmt.Fprintf(output, "File '%s' would be uploaded with:\n", job.filename)
+		if len(job.sidecars) > 0 {
+			fmt.Fprintf(output, "Sidecar files: %s\n", strings.Join(job.sidecars, ", "))
+		}
+		if note := fileMetaNote(job.filename); note != "" {
+			fmt.Fprintf(output, "Taken %s\n", note)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(video); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(output, colorize(output, styleSummary, "Dry run complete, nothing was uploaded"))
+	return nil
+}
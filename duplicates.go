@@ -0,0 +1,232 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// ledgerFile is the name of the record of uploaded files in the config
+// directory, used by -skipDuplicates
+const ledgerFile = "uploaded.json"
+
+// ledgerEntry records the upload of a file
+type ledgerEntry struct {
+	VideoID  string    `json:"videoId"`
+	File     string    `json:"file"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+// ledger maps the keys of uploaded files to their videos. Keys start with
+// the profile, as a file uploaded to one account isn't a duplicate on
+// another.
+type ledger struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]ledgerEntry
+}
+
+var (
+	uploadLedger     *ledger
+	uploadLedgerErr  error
+	uploadLedgerOnce sync.Once
+)
+
+// openLedger loads the ledger the first time it is called
+func openLedger() (*ledger, error) {
+	uploadLedgerOnce.Do(func() {
+		dir, err := profileDir()
+		if err != nil {
+			uploadLedgerErr = err
+			return
+		}
+		l := &ledger{path: filepath.Join(dir, ledgerFile), entries: make(map[string]ledgerEntry)}
+		data, err := ioutil.ReadFile(l.path)
+		if err == nil {
+			err = json.Unmarshal(data, &l.entries)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			uploadLedgerErr = fmt.Errorf("error reading '%s': %s", l.path, err)
+			return
+		}
+		uploadLedger = l
+	})
+	return uploadLedger, uploadLedgerErr
+}
+
+func (l *ledger) lookup(key string) (ledgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	return e, ok
+}
+
+// record adds an entry to the ledger and saves it
+func (l *ledger) record(key string, entry ledgerEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[key] = entry
+	data, err := json.MarshalIndent(l.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// duplicateKey returns the ledger key of a local file: a SHA-256 hash of its
+// contents, or with -duplicateCheck quick, its name, size and modification
+// time. URLs and stdin have no key.
+func duplicateKey(filename string) (string, error) {
+	if filename == "-" || isURL(filename) {
+		return "", nil
+	}
+	prefix := *profile + "/"
+	switch *dupCheck {
+	case "quick":
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%squick:%s:%d:%d", prefix, filepath.Base(filename), fi.Size(), fi.ModTime().Unix()), nil
+	case "sha256":
+		f, err := os.Open(filename)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return prefix + "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return "", fmt.Errorf("invalid value for -duplicateCheck '%s'", *dupCheck)
+}
+
+// keyHash returns the SHA-256 hash in a ledger key, or "" for a quick key
+func keyHash(key string) string {
+	if i := strings.Index(key, "/sha256:"); i >= 0 {
+		return key[i+len("/sha256:"):]
+	}
+	return ""
+}
+
+// validateDuplicateCheck checks the value of -duplicateCheck
+func validateDuplicateCheck() error {
+	switch *dupCheck {
+	case "sha256", "quick":
+		return nil
+	}
+	return fmt.Errorf("invalid value for -duplicateCheck '%s', must be 'sha256' or 'quick'", *dupCheck)
+}
+
+// findDuplicate returns the ledger key of filename and, if it has been
+// uploaded before, the entry recording that upload
+func findDuplicate(ctx context.Context, filename string) (string, *ledgerEntry, error) {
+	l, err := openLedger()
+	if err != nil {
+		return "", nil, err
+	}
+	if *dupCheck == "sha256" && filename != "-" && !isURL(filename) {
+		fmt.Fprintf(output, "Checking whether '%s' has been uploaded before...\n", filename)
+	}
+	key, err := duplicateKey(filename)
+	if err != nil || key == "" {
+		return "", nil, err
+	}
+	if e, ok := l.lookup(key); ok {
+		return key, &e, nil
+	}
+	// uploads recorded only in the history, e.g. from before -skipDuplicates
+	// was used, are duplicates too
+	if e := findHistoryDuplicate(ctx, filename, key); e != nil {
+		return key, e, nil
+	}
+	return key, nil, nil
+}
+
+// recordUpload adds the upload of filename to the ledger. Failures are
+// warned about, as the video has been uploaded.
+func recordUpload(ctx context.Context, key, filename, videoID string) {
+	l, err := openLedger()
+	if err == nil {
+		err = l.record(key, ledgerEntry{VideoID: videoID, File: filename, Uploaded: time.Now()})
+	}
+	if err != nil {
+		warningsOf(ctx).warnf("Error recording upload of '%s' for -skipDuplicates: %v", filename, err)
+	}
+}
+
+// checkDuplicateStatus warns if Youtube has found the video to be a
+// duplicate of one already uploaded. The insert succeeds in that case, so the
+// status has to be fetched.
+func checkDuplicateStatus(ctx context.Context, service *youtube.Service, videoID string) {
+	response, err := service.Videos.List([]string{"status"}).Id(videoID).Do()
+	if err != nil || len(response.Items) == 0 || response.Items[0].Status == nil {
+		return
+	}
+	status := response.Items[0].Status
+	if status.UploadStatus == "duplicate" || (status.UploadStatus == "rejected" && status.RejectionReason == "duplicate") {
+		warningsOf(ctx).warnf("Youtube reports video %s is a duplicate of a video already uploaded", videoID)
+	}
+}
+
+// uploadUnlessDuplicate uploads the file of job, unless -skipDuplicates is
+// given and it has been uploaded before, in which case the earlier video is
+// returned and skipped is true
+func uploadUnlessDuplicate(ctx context.Context, w *uploadWorker, job uploadJob) (result *uploadResult, skipped bool, err error) {
+	if !*skipDupes {
+		result, err = uploadFile(ctx, w, job)
+		return result, false, err
+	}
+
+	key, entry, err := findDuplicate(ctx, job.filename)
+	if err != nil {
+		return &uploadResult{File: job.filename}, false, withCode(errCodeSource, fmt.Errorf("error checking for duplicates of '%s': %w", job.filename, err))
+	}
+	if entry != nil {
+		fmt.Fprintf(output, "'%s' was uploaded on %s as video ID %s, skipping\n", job.filename, entry.Uploaded.Format("2006-01-02"), entry.VideoID)
+		result = &uploadResult{File: job.filename, Skipped: true}
+		result.setVideoURLs(entry.VideoID)
+		return result, true, nil
+	}
+
+	result, err = uploadFile(ctx, w, job)
+	result.SHA256 = keyHash(key)
+	if key != "" && result.VideoID != "" {
+		recordUpload(ctx, key, job.filename, result.VideoID)
+	}
+	return result, false, err
+}
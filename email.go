@@ -0,0 +1,209 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// emailTimeout limits the whole conversation with -smtpServer
+const emailTimeout = 30 * time.Second
+
+// the values of -emailOn
+var emailOnValues = []string{"success", "failure", "always"}
+
+// defaultEmailTemplate is the body of the email without -emailTemplate
+const defaultEmailTemplate = `{{if .Error -}}
+The upload of {{.File}} failed.
+
+Error: {{.Error}}
+{{- if .VideoID}}
+Video: {{.URL}}
+{{- end}}
+{{- else -}}
+{{.File}} was uploaded.
+
+Title: {{.Title}}
+URL: {{.URL}}
+{{- end}}
+Size: {{bytes .FileSize}}
+Time taken: {{duration .Duration}}
+`
+
+var (
+	// emailBody is the template of the email body
+	emailBody *template.Template
+	// smtpPassword is read from -smtpPassFile
+	smtpPassword string
+)
+
+// parseEmailFlags checks the email flags and reads the -emailTemplate and
+// -smtpPassFile, so that problems are reported before any upload starts
+func parseEmailFlags() error {
+	if *emailTo == "" {
+		return nil
+	}
+	if *emailFrom == "" {
+		return fmt.Errorf("-emailTo requires -emailFrom")
+	}
+	if *smtpServer == "" {
+		return fmt.Errorf("-emailTo requires -smtpServer")
+	}
+	if _, _, err := net.SplitHostPort(*smtpServer); err != nil {
+		return fmt.Errorf("invalid -smtpServer '%s', must be host:port e.g. smtp.example.com:587", *smtpServer)
+	}
+	*emailOn = normalizeEnum(*emailOn, emailOnValues)
+	if err := validateEnum("-emailOn", *emailOn, emailOnValues); err != nil {
+		return err
+	}
+	if *smtpUser != "" {
+		if *smtpPassFile == "" {
+			return fmt.Errorf("-smtpUser requires -smtpPassFile")
+		}
+		pass, err := ioutil.ReadFile(*smtpPassFile)
+		if err != nil {
+			return fmt.Errorf("error reading -smtpPassFile: %s", err)
+		}
+		smtpPassword = strings.TrimRight(string(pass), "\r\n")
+	}
+
+	text := defaultEmailTemplate
+	if *emailTmpl != "" {
+		b, err := ioutil.ReadFile(*emailTmpl)
+		if err != nil {
+			return fmt.Errorf("error reading -emailTemplate: %s", err)
+		}
+		text = string(b)
+	}
+	funcs := template.FuncMap{
+		"bytes": formatBytes,
+		"duration": func(seconds float64) time.Duration {
+			return secondsDuration(seconds).Round(time.Second)
+		},
+	}
+	t, err := template.New("emailTemplate").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid template in -emailTemplate: %s", err)
+	}
+	emailBody = t
+	return nil
+}
+
+// emailUpload emails the outcome of the upload of file to -emailTo, if it is
+// set and -emailOn asks for it. Failures are only warned about, as they don't
+// affect the upload.
+func emailUpload(ctx context.Context, file string, result *uploadResult, uploadErr error) {
+	if *emailTo == "" {
+		return
+	}
+	switch {
+	case *emailOn == "success" && uploadErr != nil:
+		return
+	case *emailOn == "failure" && uploadErr == nil:
+		return
+	}
+
+	payload := newNotifyPayload(file, result, uploadErr)
+	subject := "Uploaded: " + payload.Title
+	if uploadErr != nil {
+		subject = "Upload failed: " + file
+	}
+	var body bytes.Buffer
+	if err := emailBody.Execute(&body, payload); err != nil {
+		warningsOf(ctx).warnf("Error building the email for '%s': %v", file, err)
+		return
+	}
+	if err := sendEmail(subject, body.Bytes()); err != nil {
+		warningsOf(ctx).warnf("Error emailing -emailTo about '%s': %v", file, err)
+	}
+}
+
+// sendEmail sends a plain text email to -emailTo through -smtpServer, using
+// STARTTLS if the server offers it
+func sendEmail(subject string, body []byte) error {
+	var to []string
+	for _, addr := range strings.Split(*emailTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", *emailFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write(body)
+	qp.Close()
+
+	host, _, _ := net.SplitHostPort(*smtpServer)
+	conn, err := net.DialTimeout("tcp", *smtpServer, emailTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(emailTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if *smtpUser != "" {
+		// PlainAuth refuses to send the password unencrypted, except to
+		// localhost
+		if err := c.Auth(smtp.PlainAuth("", *smtpUser, smtpPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(*emailFrom); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,398 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// maxMetaValue is the longest embedded metadata value that is read
+const maxMetaValue = 64 << 10
+
+// embeddedMeta is the metadata found in a video's container for
+// -metaFromFile
+type embeddedMeta struct {
+	Title       string
+	Description string
+	Created     time.Time
+}
+
+// Matroska element IDs of the metadata
+const (
+	ebmlTitle          = 0x7ba9
+	ebmlDateUTC        = 0x4461
+	ebmlTags           = 0x1254c367
+	ebmlTag            = 0x7373
+	ebmlTargets        = 0x63c0
+	ebmlTagTrackUID    = 0x63c5
+	ebmlSimpleTag      = 0x67c8
+	ebmlTagName        = 0x45a3
+	ebmlTagString      = 0x4487
+	matroskaEpochDelta = 978307200 // 2001-01-01 in Unix time
+)
+
+// mp4Epoch is the start of MP4 and QuickTime times
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// probeMetadata reads the title, description and creation time embedded in
+// the container of the video in r, of size bytes
+func probeMetadata(r io.ReaderAt, size int64) (embeddedMeta, error) {
+	header := make([]byte, sniffLen)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return embeddedMeta{}, err
+	}
+	switch videoContainer(header[:n]) {
+	case "MP4", "MOV":
+		return mp4Metadata(r, size)
+	case "Matroska", "WebM":
+		return matroskaMetadata(r, size)
+	}
+	return embeddedMeta{}, errUnknownContainer
+}
+
+// mp4Metadata reads the metadata of moov/udta/meta and moov/meta. The
+// creation time of the movie header is used if no date is given.
+func mp4Metadata(r io.ReaderAt, size int64) (embeddedMeta, error) {
+	var meta embeddedMeta
+	top, err := mp4Boxes(r, 0, size)
+	if err != nil {
+		return meta, err
+	}
+	items := make(map[string]string)
+	var created time.Time
+	for _, moov := range top {
+		if moov.typ != "moov" {
+			continue
+		}
+		boxes, err := mp4Boxes(r, moov.offset, moov.offset+moov.size)
+		if err != nil {
+			return meta, err
+		}
+		for _, box := range boxes {
+			switch box.typ {
+			case "mvhd":
+				data, err := readBox(r, box, 12)
+				if err != nil {
+					return meta, err
+				}
+				var secs uint64
+				if len(data) >= 12 && data[0] == 1 {
+					secs = binary.BigEndian.Uint64(data[4:12])
+				} else if len(data) >= 8 {
+					secs = uint64(binary.BigEndian.Uint32(data[4:8]))
+				}
+				if secs > 0 {
+					created = mp4Epoch.Add(time.Duration(secs) * time.Second)
+				}
+			case "meta":
+				mp4MetaItems(r, box, items)
+			case "udta":
+				udta, err := mp4Boxes(r, box.offset, box.offset+box.size)
+				if err != nil {
+					return meta, err
+				}
+				for _, b := range udta {
+					if b.typ == "meta" {
+						mp4MetaItems(r, b, items)
+					}
+				}
+			}
+		}
+	}
+
+	meta.Title = firstValue(items, "\xa9nam", "com.apple.quicktime.title")
+	meta.Description = firstValue(items, "ldes", "desc", "com.apple.quicktime.description", "\xa9cmt", "com.apple.quicktime.comment")
+	meta.Created = parseEmbeddedDate(firstValue(items, "com.apple.quicktime.creationdate", "\xa9day"))
+	if meta.Created.IsZero() && created.After(time.Unix(0, 0)) {
+		meta.Created = created
+	}
+	return meta, nil
+}
+
+// mp4MetaItems adds the text values of the ilst items of a meta box to
+// items, by their type, or their name in the keys box of QuickTime metadata
+func mp4MetaItems(r io.ReaderAt, meta mp4Box, items map[string]string) {
+	// the ISO meta box has a version and flags before its children, the
+	// QuickTime one doesn't
+	head, err := readBox(r, meta, 8)
+	if err != nil || len(head) < 8 {
+		return
+	}
+	start := meta.offset
+	if string(head[4:8]) != "hdlr" {
+		start += 4
+	}
+	children, err := mp4Boxes(r, start, meta.offset+meta.size)
+	if err != nil {
+		return
+	}
+	var keys []string
+	for _, c := range children {
+		if c.typ != "keys" {
+			continue
+		}
+		data, err := readBox(r, c, maxMetaValue)
+		if err != nil || len(data) < 8 {
+			continue
+		}
+		count := int(binary.BigEndian.Uint32(data[4:8]))
+		for pos := 8; len(keys) < count && pos+8 <= len(data); {
+			size := int(binary.BigEndian.Uint32(data[pos:]))
+			if size < 8 || pos+size > len(data) {
+				break
+			}
+			keys = append(keys, string(data[pos+8:pos+size]))
+			pos += size
+		}
+	}
+	for _, c := range children {
+		if c.typ != "ilst" {
+			continue
+		}
+		entries, err := mp4Boxes(r, c.offset, c.offset+c.size)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.typ
+			if i := int(binary.BigEndian.Uint32([]byte(entry.typ))); i >= 1 && i <= len(keys) {
+				name = keys[i-1]
+			}
+			values, err := mp4Boxes(r, entry.offset, entry.offset+entry.size)
+			if err != nil {
+				continue
+			}
+			for _, v := range values {
+				if v.typ != "data" {
+					continue
+				}
+				data, err := readBox(r, v, maxMetaValue)
+				// type 1 is UTF-8 text, after the type and locale
+				if err == nil && len(data) > 8 && binary.BigEndian.Uint32(data) == 1 {
+					if _, ok := items[name]; !ok {
+						items[name] = strings.TrimSpace(string(data[8:]))
+					}
+				}
+			}
+		}
+	}
+}
+
+// matroskaMetadata reads the segment's title and date from its Info, and the
+// TITLE, DESCRIPTION or COMMENT and DATE_RECORDED SimpleTags that apply to
+// the whole file rather than a track
+func matroskaMetadata(r io.ReaderAt, size int64) (embeddedMeta, error) {
+	var meta embeddedMeta
+	top, err := ebmlElements(r, 0, size)
+	if err != nil && len(top) < 2 {
+		return meta, err
+	}
+	tags := make(map[string]string)
+	var title string
+	var created time.Time
+	for _, segment := range top {
+		if segment.id != ebmlSegment {
+			continue
+		}
+		children, _ := ebmlElements(r, segment.offset, segment.offset+segment.size)
+		for _, child := range children {
+			switch child.id {
+			case ebmlInfo:
+				fields, _ := ebmlElements(r, child.offset, child.offset+child.size)
+				for _, f := range fields {
+					switch f.id {
+					case ebmlTitle:
+						title = ebmlString(r, f)
+					case ebmlDateUTC:
+						if ns := int64(ebmlUint(r, f)); ns != 0 {
+							created = time.Unix(matroskaEpochDelta, ns).UTC()
+						}
+					}
+				}
+			case ebmlTags:
+				matroskaTags(r, child, tags)
+			}
+		}
+		break
+	}
+
+	meta.Title = firstValue(tags, "TITLE")
+	if meta.Title == "" {
+		meta.Title = title
+	}
+	meta.Description = firstValue(tags, "DESCRIPTION", "SUMMARY", "COMMENT")
+	meta.Created = parseEmbeddedDate(firstValue(tags, "DATE_RECORDED"))
+	if meta.Created.IsZero() {
+		meta.Created = created
+	}
+	return meta, nil
+}
+
+// matroskaTags adds the SimpleTags of the Tags element that aren't specific
+// to a track to tags
+func matroskaTags(r io.ReaderAt, element ebmlElement, tags map[string]string) {
+	list, _ := ebmlElements(r, element.offset, element.offset+element.size)
+	for _, tag := range list {
+		if tag.id != ebmlTag {
+			continue
+		}
+		fields, _ := ebmlElements(r, tag.offset, tag.offset+tag.size)
+		global := true
+		for _, f := range fields {
+			if f.id != ebmlTargets {
+				continue
+			}
+			targets, _ := ebmlElements(r, f.offset, f.offset+f.size)
+			for _, t := range targets {
+				if t.id == ebmlTagTrackUID && ebmlUint(r, t) != 0 {
+					global = false
+				}
+			}
+		}
+		if !global {
+			continue
+		}
+		for _, f := range fields {
+			if f.id != ebmlSimpleTag {
+				continue
+			}
+			var name, value string
+			simple, _ := ebmlElements(r, f.offset, f.offset+f.size)
+			for _, s := range simple {
+				switch s.id {
+				case ebmlTagName:
+					name = strings.ToUpper(ebmlString(r, s))
+				case ebmlTagString:
+					value = ebmlString(r, s)
+				}
+			}
+			if _, ok := tags[name]; !ok && name != "" {
+				tags[name] = value
+			}
+		}
+	}
+}
+
+// ebmlString reads the contents of a string element, which may be padded
+// with zeros
+func ebmlString(r io.ReaderAt, e ebmlElement) string {
+	if e.size > maxMetaValue {
+		return ""
+	}
+	buf := make([]byte, e.size)
+	if _, err := r.ReadAt(buf, e.offset); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(buf), "\x00"))
+}
+
+// firstValue returns the first non-empty value of names in values
+func firstValue(values map[string]string, names ...string) string {
+	for _, name := range names {
+		if v := values[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseEmbeddedDate parses the dates written by encoders and cameras, which
+// may be just a year or a date without a time
+func parseEmbeddedDate(s string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// fileMetaResults caches the metadata read from each file, as a job's video
+// is made more than once
+var fileMetaResults sync.Map
+
+// fileMetaFields records which fields of each file's video came from its
+// metadata, for -dryRun and -confirm
+var fileMetaFields sync.Map
+
+// readFileMeta returns the metadata embedded in filename, if it is a local
+// video file with any
+func readFileMeta(filename string) embeddedMeta {
+	if cached, ok := fileMetaResults.Load(filename); ok {
+		return cached.(embeddedMeta)
+	}
+	var meta embeddedMeta
+	if path, err := localPath(filename); err == nil && filename != "-" && !isURL(filename) {
+		if f, err := os.Open(path); err == nil {
+			if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+				meta, err = probeMetadata(f, fi.Size())
+				if err != nil && err != errUnknownContainer {
+					warnf("Error reading the metadata of '%s': %s", filename, err)
+				}
+			}
+			f.Close()
+		}
+	}
+	fileMetaResults.Store(filename, meta)
+	return meta
+}
+
+// applyFileMeta fills in the title, description and recording date of video
+// from the metadata embedded in the job's file for -metaFromFile, where the
+// flags and meta JSON don't give them. It returns whether any were.
+func applyFileMeta(job uploadJob, video *youtube.Video) bool {
+	if !*metaFromFile {
+		return false
+	}
+	meta := readFileMeta(job.filename)
+	var fields []string
+	if meta.Title != "" && video.Snippet.Title == "" && !*titleFromFile {
+		video.Snippet.Title = meta.Title
+		fields = append(fields, "title")
+	}
+	if meta.Description != "" && !isFlagSet("description") && *descFile == "" && job.videoMeta.Description == "" {
+		video.Snippet.Description = meta.Description
+		fields = append(fields, "description")
+	}
+	if !meta.Created.IsZero() && (video.RecordingDetails == nil || video.RecordingDetails.RecordingDate == "") {
+		details := youtube.VideoRecordingDetails{}
+		if video.RecordingDetails != nil {
+			details = *video.RecordingDetails
+		}
+		details.RecordingDate = meta.Created.UTC().Format(ytDateLayout)
+		video.RecordingDetails = &details
+		fields = append(fields, "recordingDate")
+	}
+	fileMetaFields.Store(job.filename, fields)
+	return len(fields) > 0
+}
+
+// fileMetaNote describes which fields of the video of filename came from
+// its metadata, or is empty if none did
+func fileMetaNote(filename string) string {
+	fields, ok := fileMetaFields.Load(filename)
+	if !ok || len(fields.([]string)) == 0 {
+		return ""
+	}
+	return "from the file's metadata: " + strings.Join(fields.([]string), ", ")
+}
@@ -15,137 +15,413 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"google.golang.org/api/youtube/v3"
+	"gopkg.in/yaml.v2"
 )
 
 const ytDateLayout = "2006-01-02T15:04:05.000Z" // ISO 8601 (YYYY-MM-DDThh:mm:ss.sssZ)
 const inputDateLayout = "2006-01-02"
 const inputDatetimeLayout = "2006-01-02T15:04:05-07:00"
 
+// maxDescriptionBytes is the longest description Youtube will accept
+const maxDescriptionBytes = 5000
+
+// maxThumbnailSize is the largest thumbnail image Youtube will accept
+const maxThumbnailSize = 2 * 1024 * 1024
+
 type Date struct {
 	time.Time
 }
 
-func LoadVideoMeta(filename string, video *youtube.Video) (videoMeta VideoMeta) {
-	// attempt to load from meta JSON, otherwise use values specified from command line flags
+// LoadVideoMeta loads the meta JSON file, if one is given, into video. Values
+// not set by the file are later filled in from the command line flags.
+func LoadVideoMeta(filename string, video *youtube.Video) (videoMeta VideoMeta, err error) {
 	if filename != "" {
 		file, e := ioutil.ReadFile(filename)
 		if e != nil {
-			fmt.Printf("Error reading file '%s': %s\n", filename, e)
-			fmt.Println("Will use command line flags instead")
-			goto errJump
-		}
-
-		e = json.Unmarshal(file, &videoMeta)
-		if e != nil {
-			fmt.Printf("Error parsing file '%s': %s\n", filename, e)
-			fmt.Println("Will use command line flags instead")
-			goto errJump
+			return videoMeta, fmt.Errorf("Error reading file '%s': %s", filename, e)
 		}
 
-		video.Status = &youtube.VideoStatus{}
-		video.Snippet.Tags = videoMeta.Tags
-		video.Snippet.Title = videoMeta.Title
-		video.Snippet.Description = videoMeta.Description
-		video.Snippet.CategoryId = videoMeta.CategoryId
-		if videoMeta.Location != nil {
-			video.RecordingDetails.Location = videoMeta.Location
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".yaml", ".yml":
+			videoMeta, e = decodeVideoMetaYAML(file)
+		default:
+			videoMeta, e = decodeVideoMeta(file)
+			if e != nil && looksLikeYAML(file) {
+				e = fmt.Errorf("%s. The file appears to be YAML, give it a .yaml extension", e)
+			}
 		}
-		if videoMeta.LocationDescription != "" {
-			video.RecordingDetails.LocationDescription = videoMeta.LocationDescription
+		if e == nil {
+			e = checkAdSettings(videoMeta)
 		}
-		if !videoMeta.RecordingDate.IsZero() {
-			video.RecordingDetails.RecordingDate = videoMeta.RecordingDate.UTC().Format(ytDateLayout)
+		if e != nil {
+			return videoMeta, fmt.Errorf("Error parsing file '%s': %s", filename, e)
 		}
+		applyVideoMeta(videoMeta, video)
+	}
 
-		// status
-		if videoMeta.PrivacyStatus != "" {
-			video.Status.PrivacyStatus = videoMeta.PrivacyStatus
+	return
+}
+
+// checkAdSettings rejects the per-format ad settings, which the Data API
+// can't set, rather than uploading without them
+func checkAdSettings(videoMeta VideoMeta) error {
+	var fields []string
+	if videoMeta.AdFormats != nil {
+		fields = append(fields, "adFormats")
+	}
+	if videoMeta.AdBreaks != nil {
+		fields = append(fields, "adBreaks")
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s can't be set: the Youtube Data API only supports monetizationAllowed and monetizationExcludedRegions, per-format ad settings can only be changed in YouTube Studio", strings.Join(fields, " and "))
+}
+
+// applyVideoMeta sets the fields of video given by videoMeta
+func applyVideoMeta(videoMeta VideoMeta, video *youtube.Video) {
+	video.Status = &youtube.VideoStatus{}
+	video.Snippet.Tags = videoMeta.Tags
+	video.Snippet.Title = videoMeta.Title
+	video.Snippet.Description = videoMeta.Description
+	video.Snippet.CategoryId = videoMeta.CategoryId
+	if len(videoMeta.Localizations) > 0 {
+		video.Localizations = make(map[string]youtube.VideoLocalization)
+		for lang, l := range videoMeta.Localizations {
+			video.Localizations[lang] = youtube.VideoLocalization{Title: l.Title, Description: l.Description}
 		}
-		if videoMeta.Embeddable {
-			video.Status.Embeddable = videoMeta.Embeddable
+	}
+	if videoMeta.Location != nil {
+		video.RecordingDetails.Location = videoMeta.Location
+	}
+	if videoMeta.LocationDescription != "" {
+		video.RecordingDetails.LocationDescription = videoMeta.LocationDescription
+	}
+	if !videoMeta.RecordingDate.IsZero() {
+		video.RecordingDetails.RecordingDate = videoMeta.RecordingDate.UTC().Format(ytDateLayout)
+	}
+
+	// status
+	if videoMeta.PrivacyStatus != "" {
+		video.Status.PrivacyStatus = normalizeEnum(videoMeta.PrivacyStatus, privacyStatuses)
+	}
+	setBool(&video.Status.Embeddable, &video.Status.ForceSendFields, "Embeddable", videoMeta.Embeddable)
+	if videoMeta.License != "" {
+		video.Status.License = normalizeEnum(videoMeta.License, licenses)
+	}
+	setBool(&video.Status.PublicStatsViewable, &video.Status.ForceSendFields, "PublicStatsViewable", videoMeta.PublicStatsViewable)
+	setBool(&video.Status.SelfDeclaredMadeForKids, &video.Status.ForceSendFields, "SelfDeclaredMadeForKids", videoMeta.SelfDeclaredMadeForKids)
+	if videoMeta.MonetizationAllowed != nil || len(videoMeta.MonetizationExcludedRegions) > 0 {
+		video.MonetizationDetails = &youtube.VideoMonetizationDetails{Access: &youtube.AccessPolicy{}}
+		setBool(&video.MonetizationDetails.Access.Allowed, &video.MonetizationDetails.Access.ForceSendFields, "Allowed", videoMeta.MonetizationAllowed)
+		// with ads allowed, the exceptions are where they aren't
+		video.MonetizationDetails.Access.Exception = videoMeta.MonetizationExcludedRegions
+	}
+	if !videoMeta.PublishAt.IsZero() {
+		if video.Status.PrivacyStatus != "private" {
+			warnf("publishAt can only be used when privacyStatus is 'private'. Ignoring publishAt...")
+		} else {
+			if videoMeta.PublishAt.Before(time.Now()) {
+				warnf("publishAt (%s) was in the past!? Publishing now instead...", videoMeta.PublishAt)
+				video.Status.PublishAt = time.Now().UTC().Format(ytDateLayout)
+			} else {
+				video.Status.PublishAt = videoMeta.PublishAt.UTC().Format(ytDateLayout)
+			}
 		}
-		if videoMeta.License != "" {
-			video.Status.License = videoMeta.License
+	}
+
+	if videoMeta.Language != "" {
+		video.Snippet.DefaultLanguage = videoMeta.Language
+		video.Snippet.DefaultAudioLanguage = videoMeta.Language
+	}
+	if videoMeta.AudioLanguage != "" {
+		video.Snippet.DefaultAudioLanguage = videoMeta.AudioLanguage
+	}
+}
+
+// decodeVideoMeta parses meta JSON. Unknown fields, which are usually
+// misspellings, are an error with -strictMeta and a warning otherwise.
+func decodeVideoMeta(data []byte) (VideoMeta, error) {
+	var videoMeta VideoMeta
+	if len(bytes.TrimSpace(data)) == 0 {
+		return videoMeta, errors.New("file is empty")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&videoMeta)
+	if err == nil || *strictMeta || !strings.HasPrefix(err.Error(), "json: unknown field") {
+		return videoMeta, err
+	}
+
+	warnf("Warning: %s. This will be an error in a future version, use -strictMeta to make it one now", err)
+	videoMeta = VideoMeta{}
+	err = json.Unmarshal(data, &videoMeta)
+	return videoMeta, err
+}
+
+// decodeVideoMetaYAML parses meta YAML. The YAML is converted to JSON so that
+// the same field names and parsing rules apply. Unknown fields are an error.
+func decodeVideoMetaYAML(data []byte) (VideoMeta, error) {
+	var videoMeta VideoMeta
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		if json.Valid(data) {
+			return videoMeta, fmt.Errorf("%s. The file appears to be JSON", err)
 		}
-		if videoMeta.PublicStatsViewable {
-			video.Status.PublicStatsViewable = videoMeta.PublicStatsViewable
+		return videoMeta, err
+	}
+	if v == nil {
+		return videoMeta, errors.New("file is empty")
+	}
+
+	v, err := yamlToJSON(v)
+	if err != nil {
+		return videoMeta, err
+	}
+	data, err = json.Marshal(v)
+	if err != nil {
+		return videoMeta, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&videoMeta)
+	return videoMeta, err
+}
+
+// yamlToJSON converts the maps produced by the YAML decoder, which have
+// interface{} keys, into maps which can be encoded as JSON
+func yamlToJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", key)
+			}
+			value, err := yamlToJSON(value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = value
 		}
-		if !videoMeta.PublishAt.IsZero() {
-			if video.Status.PrivacyStatus != "private" {
-				fmt.Printf("publishAt can only be used when privacyStatus is 'private'. Ignoring publishAt...\n")
-			} else {
-				if videoMeta.PublishAt.Before(time.Now()) {
-					fmt.Printf("publishAt (%s) was in the past!? Publishing now instead...\n", videoMeta.PublishAt)
-					video.Status.PublishAt = time.Now().UTC().Format(ytDateLayout)
-				} else {
-					video.Status.PublishAt = videoMeta.PublishAt.UTC().Format(ytDateLayout)
-				}
+		return m, nil
+	case []interface{}:
+		for i, value := range v {
+			value, err := yamlToJSON(value)
+			if err != nil {
+				return nil, err
 			}
+			v[i] = value
 		}
+	case time.Time:
+		// unquoted dates are decoded as times
+		return v.Format(inputDatetimeLayout), nil
+	}
+	return v, nil
+}
 
-		if videoMeta.Language != "" {
-			video.Snippet.DefaultLanguage = videoMeta.Language
-			video.Snippet.DefaultAudioLanguage = videoMeta.Language
-		}
+// looksLikeYAML reports whether data, which isn't valid JSON, is a YAML mapping
+func looksLikeYAML(data []byte) bool {
+	if json.Valid(data) {
+		return false
+	}
+	var m map[string]interface{}
+	return yaml.Unmarshal(data, &m) == nil && len(m) > 0
+}
+
+// mergeFlags fills in the video fields from the command line flags. Flags
+// given on the command line win over the meta JSON file, so that one field of
+// a standard metadata file can be changed for a single upload, while the
+// defaults of the other flags only fill in fields the file doesn't set. If
+// explicitOnly is set, only flags given on the command line are used.
+func mergeFlags(video *youtube.Video, explicitOnly bool) error {
+	use := func(name string) bool {
+		return !explicitOnly || isFlagSet(name)
 	}
-errJump:
 
-	if video.Status.PrivacyStatus == "" {
-		video.Status.PrivacyStatus = *privacy
+	if (video.Status.PrivacyStatus == "" || isFlagSet("privacy")) && use("privacy") {
+		video.Status.PrivacyStatus = normalizeEnum(*privacy, privacyStatuses)
 	}
-	if video.Snippet.Tags == nil && strings.Trim(*tags, "") != "" {
-		video.Snippet.Tags = strings.Split(*tags, ",")
+	if video.Snippet.Tags == nil || isFlagSet("tags") {
+		tagList, err := parseTags(*tags)
+		if err != nil {
+			return err
+		}
+		video.Snippet.Tags = tagList
+	} else {
+		video.Snippet.Tags = cleanTags(video.Snippet.Tags)
 	}
-	if video.Snippet.Title == "" {
+	if (video.Snippet.Title == "" || isFlagSet("title")) && use("title") {
 		video.Snippet.Title = *title
 	}
-	if video.Snippet.Description == "" {
+	if (video.Snippet.Description == "" || isFlagSet("description")) && use("description") {
 		video.Snippet.Description = *description
 	}
-	if video.Snippet.CategoryId == "" && *categoryId != "" {
+	if (video.Snippet.CategoryId == "" || isFlagSet("categoryId")) && *categoryId != "" {
 		video.Snippet.CategoryId = *categoryId
 	}
+	// the audio language follows -language unless it was set separately
+	metaLanguage := video.Snippet.DefaultLanguage
+	if (video.Snippet.DefaultLanguage == "" || isFlagSet("language")) && *language != "" && use("language") {
+		video.Snippet.DefaultLanguage = *language
+	}
+	if (!hasField(video.Status.ForceSendFields, "Embeddable") || isFlagSet("embeddable")) && use("embeddable") {
+		setBool(&video.Status.Embeddable, &video.Status.ForceSendFields, "Embeddable", embeddable)
+	}
+	if (!hasField(video.Status.ForceSendFields, "PublicStatsViewable") || isFlagSet("publicStatsViewable")) && use("publicStatsViewable") {
+		setBool(&video.Status.PublicStatsViewable, &video.Status.ForceSendFields, "PublicStatsViewable", publicStats)
+	}
+	if (video.Status.License == "" || isFlagSet("license")) && *license != "" {
+		video.Status.License = normalizeEnum(*license, licenses)
+	}
+	if (video.Snippet.DefaultAudioLanguage == "" || isFlagSet("audioLanguage")) && *audioLanguage != "" {
+		video.Snippet.DefaultAudioLanguage = *audioLanguage
+	}
+	if (video.Snippet.DefaultAudioLanguage == "" || video.Snippet.DefaultAudioLanguage == metaLanguage && isFlagSet("language")) &&
+		!isFlagSet("audioLanguage") && *language != "" && use("language") {
+		video.Snippet.DefaultAudioLanguage = *language
+	}
+	applyPremiere(video)
+	return nil
+}
 
-	return
+// forceSend adds field to a ForceSendFields list so that it is sent to the
+// API even if it has the zero value e.g. false
+func forceSend(fields *[]string, field string) {
+	if !hasField(*fields, field) {
+		*fields = append(*fields, field)
+	}
+}
+
+// setBool sets a boolean field of an API object to *value, unless value is
+// nil, and adds it to fields, the object's ForceSendFields, so that false is
+// sent rather than left out as an empty value. The meta JSON uses *bool for
+// booleans so that an unset field can be told apart from false.
+func setBool(field *bool, fields *[]string, name string, value *bool) {
+	if value == nil {
+		return
+	}
+	*field = *value
+	forceSend(fields, name)
+}
+
+// forcedBool returns a pointer to a boolean field of an API object if the
+// field is in its ForceSendFields, or nil if it isn't
+func forcedBool(value bool, fields []string, name string) *bool {
+	if !hasField(fields, name) {
+		return nil
+	}
+	return &value
+}
+
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// languagePattern loosely matches BCP-47 language tags e.g. 'en', 'de-CH'
+var languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// validateLanguage checks that lang looks like a BCP-47 language tag
+func validateLanguage(lang string) error {
+	if lang != "" && !languagePattern.MatchString(lang) {
+		return fmt.Errorf("language '%s' is not a valid language code e.g. 'en' or 'de-CH'", lang)
+	}
+	return nil
+}
+
+// validateCategoryId checks that the category ID is numeric, so that a bad
+// value is caught before the upload rather than rejected by the API after it
+func validateCategoryId(id string) error {
+	if id == "" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+		return fmt.Errorf("category ID '%s' is not numeric", id)
+	}
+	return nil
+}
+
+// isURL reports whether filename is an http or https URL, or an s3://, gs://
+// or sftp:// URL, to fetch the file from rather than a local path, which may
+// itself start with 'http'
+func isURL(filename string) bool {
+	u, err := url.Parse(filename)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" || isCloudURL(filename) || isSFTPURL(filename)
+}
+
+// localPath turns a file:// URL into the path of the file it names. Anything
+// else is returned unchanged.
+func localPath(filename string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(filename), "file:") {
+		return filename, nil
+	}
+	u, err := url.Parse(filename)
+	if err != nil {
+		return "", fmt.Errorf("invalid file URL '%s': %s", filename, err)
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("invalid file URL '%s': only local files can be uploaded", filename)
+	}
+	p := u.Path
+	if u.Opaque != "" {
+		// file:video.mp4 is relative to the current directory
+		if p, err = url.PathUnescape(u.Opaque); err != nil {
+			return "", fmt.Errorf("invalid file URL '%s': %s", filename, err)
+		}
+	}
+	// file:///C:/videos/x.mp4 is C:\videos\x.mp4 on Windows
+	if runtime.GOOS == "windows" && len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	if p == "" {
+		return "", fmt.Errorf("invalid file URL '%s': no path", filename)
+	}
+	return filepath.FromSlash(p), nil
 }
 
 func Open(filename string) (io.ReadCloser, int64, error) {
 	var reader io.ReadCloser
 	var filesize int64
 	var err error
-	if strings.HasPrefix(filename, "http") {
-		resp, err := http.Head(filename)
-		if err != nil {
-			return reader, filesize, fmt.Errorf("error opening %s: %s", filename, err)
-		}
-		lenStr := resp.Header.Get("content-length")
-		if lenStr != "" {
-			filesize, err = strconv.ParseInt(lenStr, 10, 64)
-			if err != nil {
-				return reader, filesize, err
-			}
-		}
-
-		resp, err = http.Get(filename)
-		if err != nil {
-			return reader, filesize, fmt.Errorf("error opening %s: %s", filename, err)
-		}
-		if resp.ContentLength != 0 {
-			filesize = resp.ContentLength
+	if filename == "-" {
+		// stdin has no known size
+		return ioutil.NopCloser(os.Stdin), 0, nil
+	}
+	if isURL(filename) {
+		if r, size, ok, err := sourceCache.open(filename); ok {
+			return r, size, err
 		}
-		reader = resp.Body
-		return reader, filesize, nil
+		return openRemote(filename)
+	}
+	filename, err = localPath(filename)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	file, err := os.Open(filename)
@@ -161,6 +437,62 @@ func Open(filename string) (io.ReadCloser, int64, error) {
 	return file, fileInfo.Size(), nil
 }
 
+// readDescriptionFile reads a video description from a UTF-8 text file
+func readDescriptionFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("error reading description file: %s", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("description file '%s' is not valid UTF-8", filename)
+	}
+	if len(data) > maxDescriptionBytes && !*truncate {
+		return "", fmt.Errorf("description file '%s' is %d bytes, %d bytes over the limit of %d", filename, len(data), len(data)-maxDescriptionBytes, maxDescriptionBytes)
+	}
+	if bytes.ContainsAny(data, "<>") {
+		return "", fmt.Errorf("description file '%s' contains '<' or '>', which are not allowed", filename)
+	}
+	return string(data), nil
+}
+
+// parseCaption parses a caption flag of the form lang=file. If no language
+// is given, defaultLanguage is used.
+func parseCaption(value, defaultLanguage string) Caption {
+	c := Caption{Language: defaultLanguage, File: value}
+	if i := strings.Index(value, "="); i > 0 && !strings.ContainsAny(value[:i], "/\\:?") {
+		c.Language = value[:i]
+		c.File = value[i+1:]
+	}
+	return c
+}
+
+// LoadThumbnail reads the thumbnail image into memory, verifying that it is
+// within Youtube's size limit and is a supported image type.
+func LoadThumbnail(filename string) ([]byte, error) {
+	reader, _, err := Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxThumbnailSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading thumbnail %s: %s", filename, err)
+	}
+	if len(data) > maxThumbnailSize {
+		return nil, fmt.Errorf("thumbnail %s is larger than the 2MB limit", filename)
+	}
+
+	contentType := http.DetectContentType(data)
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		return nil, fmt.Errorf("thumbnail %s has unsupported content type '%s', must be JPEG or PNG", filename, contentType)
+	}
+
+	return data, nil
+}
+
 func (d *Date) UnmarshalJSON(b []byte) (err error) {
 	s := string(b)
 	s = s[1 : len(s)-1]
@@ -0,0 +1,412 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// setFlags sets flags as if they were given on the command line, putting
+// them back when the test ends. Only flags that are replaced by Set, rather
+// than added to, can be given.
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	oldCmdline := cmdlineFlags
+	cmdlineFlags = make(map[string]bool)
+	t.Cleanup(func() { cmdlineFlags = oldCmdline })
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("no flag -%s", name)
+		}
+		old := f.Value.String()
+		if err := f.Value.Set(value); err != nil {
+			t.Fatalf("-%s %s: %s", name, value, err)
+		}
+		cmdlineFlags[name] = true
+		t.Cleanup(func() { f.Value.Set(old) })
+	}
+}
+
+// loadMeta loads the meta JSON data as LoadVideoMeta does from a file
+func loadMeta(t *testing.T, data string) (*youtube.Video, VideoMeta) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "meta.json")
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	video := newVideo()
+	videoMeta, err := LoadVideoMeta(filename, video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video, videoMeta
+}
+
+// videoFields reads the fields of a video that flags and the meta JSON set
+var videoFields = map[string]func(*youtube.Video) string{
+	"title":       func(v *youtube.Video) string { return v.Snippet.Title },
+	"description": func(v *youtube.Video) string { return v.Snippet.Description },
+	"tags":        func(v *youtube.Video) string { return strings.Join(v.Snippet.Tags, ",") },
+	"categoryId":  func(v *youtube.Video) string { return v.Snippet.CategoryId },
+	"privacy":     func(v *youtube.Video) string { return v.Status.PrivacyStatus },
+}
+
+func TestMergeFlags(t *testing.T) {
+	const meta = `{"title": "meta title", "description": "meta description", "tags": ["meta"], "categoryId": "10", "privacyStatus": "unlisted"}`
+	tests := []struct {
+		field string
+		flags map[string]string
+		want  string
+	}{
+		{"title", nil, "meta title"},
+		{"title", map[string]string{"title": "flag title"}, "flag title"},
+		{"description", nil, "meta description"},
+		{"description", map[string]string{"description": "flag description"}, "flag description"},
+		{"tags", nil, "meta"},
+		{"tags", map[string]string{"tags": "a,b"}, "a,b"},
+		{"categoryId", nil, "10"},
+		{"categoryId", map[string]string{"categoryId": "22"}, "22"},
+		{"privacy", nil, "unlisted"},
+		{"privacy", map[string]string{"privacy": "public"}, "public"},
+		// a flag for another field changes nothing else
+		{"title", map[string]string{"categoryId": "22"}, "meta title"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %v", test.field, test.flags), func(t *testing.T) {
+			setFlags(t, test.flags)
+			video, _ := loadMeta(t, meta)
+			if err := mergeFlags(video, false); err != nil {
+				t.Fatal(err)
+			}
+			if got := videoFields[test.field](video); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeFlagsCategoryId(t *testing.T) {
+	// -categoryId once set the title instead of the category
+	setFlags(t, map[string]string{"categoryId": "22"})
+	video := newVideo()
+	if err := mergeFlags(video, false); err != nil {
+		t.Fatal(err)
+	}
+	if video.Snippet.CategoryId != "22" || video.Snippet.Title != "" {
+		t.Errorf("got category %q and title %q, want category 22 and no title", video.Snippet.CategoryId, video.Snippet.Title)
+	}
+}
+
+func TestIsFlagSet(t *testing.T) {
+	setFlags(t, map[string]string{"title": "x", "privacy": "private"})
+	for name, want := range map[string]bool{"title": true, "privacy": true, "description": false, "tags": false} {
+		if got := isFlagSet(name); got != want {
+			t.Errorf("isFlagSet(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestValidateCategoryId(t *testing.T) {
+	for id, ok := range map[string]bool{"": true, "22": true, "music": false, "2 2": false, "-1": false} {
+		if err := validateCategoryId(id); (err == nil) != ok {
+			t.Errorf("validateCategoryId(%q) = %v", id, err)
+		}
+	}
+}
+
+func TestMergeMatrix(t *testing.T) {
+	fields := []struct {
+		field, flag string
+		// meta is the field in the meta JSON, and flagValue and def the value
+		// of the flag when it is given and its default
+		meta, metaValue, flagValue, def string
+	}{
+		{"title", "title", `"title": "meta"`, "meta", "flag", ""},
+		{"description", "description", `"description": "meta"`, "meta", "flag", "uploaded by youtubeuploader"},
+		{"tags", "tags", `"tags": ["meta"]`, "meta", "flag", ""},
+		{"categoryId", "categoryId", `"categoryId": "10"`, "10", "22", ""},
+		{"privacy", "privacy", `"privacyStatus": "unlisted"`, "unlisted", "public", "private"},
+	}
+	for _, f := range fields {
+		for _, inMeta := range []bool{false, true} {
+			for _, flagSet := range []bool{false, true} {
+				// explicitOnly is for -videoID, where unset fields are left
+				// as they are rather than given the flag defaults
+				for _, explicitOnly := range []bool{false, true} {
+					name := fmt.Sprintf("%s meta=%t flag=%t explicitOnly=%t", f.field, inMeta, flagSet, explicitOnly)
+					t.Run(name, func(t *testing.T) {
+						var want string
+						switch {
+						case flagSet:
+							want = f.flagValue
+						case inMeta:
+							want = f.metaValue
+						case !explicitOnly:
+							want = f.def
+						}
+
+						meta := "{}"
+						if inMeta {
+							meta = "{" + f.meta + "}"
+						}
+						flags := map[string]string{}
+						if flagSet {
+							flags[f.flag] = f.flagValue
+						}
+						setFlags(t, flags)
+						video, _ := loadMeta(t, meta)
+						if err := mergeFlags(video, explicitOnly); err != nil {
+							t.Fatal(err)
+						}
+						if got := videoFields[f.field](video); got != want {
+							t.Errorf("got %q, want %q", got, want)
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestFalseBooleansSent(t *testing.T) {
+	allFalse := []string{`"embeddable":false`, `"publicStatsViewable":false`, `"selfDeclaredMadeForKids":false`, `"access":{"allowed":false}`}
+	tests := []struct {
+		name  string
+		meta  string
+		flags map[string]string
+		// sent must be in the JSON sent to the API and unsent mustn't
+		sent, unsent []string
+	}{
+		{"meta", `{"embeddable": false, "publicStatsViewable": false, "selfDeclaredMadeForKids": false, "monetizationAllowed": false}`, nil, allFalse, nil},
+		{"flags", `{}`, map[string]string{"embeddable": "false", "publicStatsViewable": "false"}, allFalse[:2], allFalse[2:]},
+		{"true", `{"selfDeclaredMadeForKids": true, "monetizationAllowed": true}`, nil, []string{`"selfDeclaredMadeForKids":true`, `"access":{"allowed":true}`}, nil},
+		// unset booleans are left to Youtube's defaults
+		{"unset", `{}`, nil, nil, []string{"selfDeclaredMadeForKids", "monetizationDetails"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t, test.flags)
+			video, _ := loadMeta(t, test.meta)
+			if err := mergeFlags(video, false); err != nil {
+				t.Fatal(err)
+			}
+			body, err := json.Marshal(video)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// false is the zero value, which is left out unless it is in
+			// ForceSendFields
+			for _, field := range test.sent {
+				if !strings.Contains(string(body), field) {
+					t.Errorf("%s missing from %s", field, body)
+				}
+			}
+			for _, field := range test.unsent {
+				if strings.Contains(string(body), field) {
+					t.Errorf("%s sent in %s", field, body)
+				}
+			}
+
+			// -videoID updates keep the explicit values too
+			existing := &youtube.Video{Snippet: &youtube.VideoSnippet{}, Status: &youtube.VideoStatus{}, RecordingDetails: &youtube.VideoRecordingDetails{}}
+			mergeVideo(existing, video)
+			body, err = json.Marshal(existing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range test.sent {
+				if !strings.Contains(string(body), field) {
+					t.Errorf("update: %s missing from %s", field, body)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeVideoMeta(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		strict bool
+		// err is part of the error, "" if there should be none
+		err   string
+		title string
+	}{
+		{"valid", `{"title": "x", "privacyStatus": "public"}`, true, "", "x"},
+		{"unknown field strict", `{"title": "x", "privacy_status": "public"}`, true, `unknown field "privacy_status"`, ""},
+		// without -strictMeta an unknown field is only a warning
+		{"unknown field", `{"title": "x", "privacy_status": "public"}`, false, "", "x"},
+		{"malformed", `{"title": "x",}`, true, "invalid character", ""},
+		{"malformed not strict", `{"title": `, false, "unexpected EOF", ""},
+		{"empty", "", false, "file is empty", ""},
+		{"whitespace", " \n", true, "file is empty", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t, map[string]string{"strictMeta": fmt.Sprint(test.strict)})
+			meta, err := decodeVideoMeta([]byte(test.data))
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("got error %v, want one containing %q", err, test.err)
+			}
+			if err == nil && meta.Title != test.title {
+				t.Errorf("got title %q, want %q", meta.Title, test.title)
+			}
+		})
+	}
+}
+
+func TestLoadVideoMetaErrors(t *testing.T) {
+	// a -metaJSON that can't be read or parsed is an error, rather than
+	// the flags being used instead
+	if _, err := LoadVideoMeta(filepath.Join(t.TempDir(), "missing.json"), newVideo()); err == nil {
+		t.Error("no error for a missing file")
+	}
+	setFlags(t, map[string]string{"strictMeta": "true"})
+	filename := filepath.Join(t.TempDir(), "meta.json")
+	if err := ioutil.WriteFile(filename, []byte(`{"titel": "x"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVideoMeta(filename, newVideo()); err == nil || !strings.Contains(err.Error(), filename) {
+		t.Errorf("got %v, want an error naming the file", err)
+	}
+}
+
+func TestAdSettingsRejected(t *testing.T) {
+	// the per-format ad settings can't be sent, so they are an error rather
+	// than being dropped
+	filename := filepath.Join(t.TempDir(), "meta.yaml")
+	if err := ioutil.WriteFile(filename, []byte("title: x\nadFormats: [overlay]\nadBreaks: {preroll: true}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadVideoMeta(filename, newVideo())
+	if err == nil || !strings.Contains(err.Error(), "adFormats and adBreaks can't be set") {
+		t.Errorf("got %v, want an error naming the ad settings", err)
+	}
+}
+
+func TestEmptyMetaKeepsFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		load func(t *testing.T) *youtube.Video
+	}{
+		{"empty VideoMeta", func(t *testing.T) *youtube.Video {
+			video := newVideo()
+			applyVideoMeta(VideoMeta{}, video)
+			return video
+		}},
+		{"no monetization", func(t *testing.T) *youtube.Video {
+			video, _ := loadMeta(t, `{"tags": ["a"], "privacyStatus": "unlisted"}`)
+			return video
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t, map[string]string{"title": "flag title", "description": "flag description"})
+			video := test.load(t)
+			if err := mergeFlags(video, false); err != nil {
+				t.Fatal(err)
+			}
+			if video.Snippet.Title != "flag title" || video.Snippet.Description != "flag description" {
+				t.Errorf("got title %q and description %q", video.Snippet.Title, video.Snippet.Description)
+			}
+			if video.MonetizationDetails != nil {
+				t.Errorf("monetization details set: %+v", video.MonetizationDetails)
+			}
+			// the summary once dereferenced the missing monetization details
+			var b strings.Builder
+			printVideoSummary(&b, video)
+			if !strings.Contains(b.String(), "title: flag title") {
+				t.Errorf("summary doesn't have the title:\n%s", b.String())
+			}
+		})
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/x.mp4": true,
+		"http://example.com/x.mp4":  true,
+		"s3://bucket/x.mp4":         true,
+		"gs://bucket/x.mp4":         true,
+		"sftp://host/x.mp4":         true,
+		// local files whose names merely start with http
+		"httpdumps.mp4":      false,
+		"http-named.mp4":     false,
+		"https:x.mp4":        false,
+		"x.mp4":              false,
+		"videos/x.mp4":       false,
+		"../x.mp4":           false,
+		`C:\videos\x.mp4`:    false,
+		"C:/videos/x.mp4":    false,
+		"file:///tmp/x.mp4":  false,
+		"-":                  false,
+		"ftp://host/x.mp4":   false,
+		"https:///no-host":   false,
+		"/abs/path/http.mp4": false,
+	}
+	for name, want := range tests {
+		if got := isURL(name); got != want {
+			t.Errorf("isURL(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	drive := filepath.FromSlash("/C:/videos/x.mp4")
+	if runtime.GOOS == "windows" {
+		drive = `C:\videos\x.mp4`
+	}
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		// anything but a file URL is a path, left as it is
+		{"x.mp4", "x.mp4", false},
+		{"videos/x.mp4", "videos/x.mp4", false},
+		{"../x.mp4", "../x.mp4", false},
+		{`C:\videos\x.mp4`, `C:\videos\x.mp4`, false},
+		{"httpdumps.mp4", "httpdumps.mp4", false},
+		{"file:///tmp/x.mp4", filepath.FromSlash("/tmp/x.mp4"), false},
+		{"FILE:///tmp/x.mp4", filepath.FromSlash("/tmp/x.mp4"), false},
+		{"file://localhost/tmp/x.mp4", filepath.FromSlash("/tmp/x.mp4"), false},
+		{"file:///tmp/my%20video.mp4", filepath.FromSlash("/tmp/my video.mp4"), false},
+		// relative to the current directory
+		{"file:x.mp4", "x.mp4", false},
+		{"file:videos/x%20y.mp4", filepath.FromSlash("videos/x y.mp4"), false},
+		{"file:///C:/videos/x.mp4", drive, false},
+		{"file://server/share/x.mp4", "", true},
+		{"file://", "", true},
+		{"file:///tmp/%zz.mp4", "", true},
+	}
+	for _, test := range tests {
+		got, err := localPath(test.in)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("localPath(%q) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+// sniffLen is how much of the start of a video is read to recognise its
+// container. An MPEG-TS file needs three 188 byte packets.
+const sniffLen = 512
+
+// sourceTypeHint is added to errors about the type of a video
+const sourceTypeHint = "Use -forceUploadAnyType to upload it anyway"
+
+// videoContainer returns the name of the container format that header, the
+// start of a file, is in, or "" if it isn't one Youtube accepts
+func videoContainer(header []byte) string {
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		if bytes.Equal(header[8:10], []byte("qt")) {
+			return "MOV"
+		}
+		return "MP4"
+	case len(header) >= 8 && isQuickTimeAtom(header[4:8]):
+		return "MOV"
+	case bytes.HasPrefix(header, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		if bytes.Contains(header, []byte("webm")) {
+			return "WebM"
+		}
+		return "Matroska"
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("AVI ")):
+		return "AVI"
+	case isTransportStream(header, 0, 188), isTransportStream(header, 4, 192):
+		return "MPEG-TS"
+	case bytes.HasPrefix(header, []byte{0, 0, 1, 0xba}):
+		return "MPEG-PS"
+	case bytes.HasPrefix(header, []byte("FLV")):
+		return "FLV"
+	case bytes.HasPrefix(header, []byte{0x30, 0x26, 0xb2, 0x75, 0x8e, 0x66, 0xcf, 0x11}):
+		return "ASF"
+	}
+	return ""
+}
+
+// isQuickTimeAtom reports whether typ is the type of an atom that older
+// QuickTime files, which have no ftyp atom, may start with
+func isQuickTimeAtom(typ []byte) bool {
+	switch string(typ) {
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	}
+	return false
+}
+
+// isTransportStream reports whether header has MPEG-TS sync bytes at offset
+// in each of its first packets of size bytes. M2TS has a 4 byte timestamp
+// before each 188 byte packet.
+func isTransportStream(header []byte, offset, size int) bool {
+	packets := 0
+	for i := offset; i < len(header); i += size {
+		if header[i] != 0x47 {
+			return false
+		}
+		packets++
+	}
+	// a short file may have fewer packets than sniffLen covers
+	return packets >= 2 || (packets == 1 && len(header) < sniffLen)
+}
+
+// isVideoContentType reports whether a URL source's Content-Type may be a
+// video. Servers often don't know and send application/octet-stream, so
+// that is accepted too.
+func isVideoContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "video/") {
+		return true
+	}
+	switch mediaType {
+	case "application/octet-stream", "binary/octet-stream", "application/mp4", "application/x-matroska":
+		return true
+	}
+	return false
+}
+
+// checkLocalVideo checks that filename is a file that looks like a video,
+// reading the start of it without moving the file offset
+func checkLocalVideo(filename string, file *os.File) error {
+	fi, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error stat'ing %s: %s", filename, err)
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("'%s' is a directory, not a video file", filename)
+	}
+	if fi.Mode().IsRegular() && fi.Size() == 0 {
+		return fmt.Errorf("'%s' is empty", filename)
+	}
+	if *forceAnyType || !fi.Mode().IsRegular() {
+		return nil
+	}
+	header := make([]byte, sniffLen)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("error reading %s: %s", filename, err)
+	}
+	if videoContainer(header[:n]) == "" {
+		return fmt.Errorf("'%s' doesn't look like a video file. %s", filename, sourceTypeHint)
+	}
+	return nil
+}
+
+// checkVideoSource checks that reader, the video opened from filename, looks
+// like a video. Local files are read without moving their offset and URLs
+// are judged by their Content-Type. The start of other sources, e.g. stdin,
+// can't be read twice, so it is buffered and the returned reader must be
+// used in place of reader.
+func checkVideoSource(filename string, reader io.ReadCloser) (io.ReadCloser, error) {
+	switch r := reader.(type) {
+	case *os.File:
+		return reader, checkLocalVideo(filename, r)
+	case *rangeReader:
+		if !*forceAnyType && !isVideoContentType(r.contentType) {
+			return reader, fmt.Errorf("'%s' has Content-Type %s, not a video. %s", filename, r.contentType, sourceTypeHint)
+		}
+		return reader, nil
+	case *cloudReader:
+		if !*forceAnyType && !isVideoContentType(r.contentType) {
+			return reader, fmt.Errorf("'%s' has Content-Type %s, not a video. %s", filename, r.contentType, sourceTypeHint)
+		}
+		return reader, nil
+	}
+
+	buffered := bufio.NewReaderSize(reader, sniffLen)
+	peeked := bufferedReadCloser{buffered, reader}
+	header, err := buffered.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return peeked, fmt.Errorf("error reading %s: %s", filename, err)
+	}
+	if len(header) == 0 {
+		return peeked, fmt.Errorf("'%s' is empty", filename)
+	}
+	if !*forceAnyType && videoContainer(header) == "" {
+		return peeked, fmt.Errorf("'%s' doesn't look like a video. %s", filename, sourceTypeHint)
+	}
+	return peeked, nil
+}
+
+// bufferedReadCloser reads a source through a buffer holding its start
+type bufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+}
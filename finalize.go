@@ -0,0 +1,104 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"google.golang.org/api/youtube/v3"
+)
+
+// finalizeRetries is the number of times Youtube is asked for a video whose
+// upload failed after all of it was sent
+const finalizeRetries = 3
+
+// recoverUpload looks for the video created by an upload whose final request
+// failed after all of the file was sent, as Youtube often creates the video
+// anyway. The upload session is asked for it first, and failing that the
+// channel's recent uploads are searched for one with the same title, started
+// no earlier than the upload. nil is returned if it can't be found.
+func recoverUpload(ctx context.Context, u *uploader.Uploader, state *uploadState, title string, started time.Time) *youtube.Video {
+	fmt.Fprintf(output, "All of the video was sent, checking whether Youtube created it...\n")
+	for i := 0; i < finalizeRetries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(time.Duration(i) * 5 * time.Second):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		if state != nil && state.SessionURI != "" {
+			offset, video, err := u.QuerySession(ctx, state.SessionURI, state.Size)
+			if err == nil && video != nil && video.Id != "" {
+				return video
+			}
+			if err == nil && offset < state.Size {
+				// the session is still open, so the video wasn't created
+				return nil
+			}
+		}
+
+		service := u.Service()
+		id, err := findRecentUpload(ctx, service, title, started)
+		if err != nil {
+			warningsOf(ctx).warnf("Error searching the channel for the uploaded video: %v", err)
+			continue
+		}
+		if id == "" {
+			continue
+		}
+		response, err := service.Videos.List([]string{"snippet", "status"}).Id(id).Context(ctx).Do()
+		if err != nil || len(response.Items) == 0 {
+			return &youtube.Video{Id: id}
+		}
+		return response.Items[0]
+	}
+	return nil
+}
+
+// findRecentUpload searches the channel's most recent videos for the one
+// titled title and published since started, returning its ID or "" if there
+// isn't exactly one
+func findRecentUpload(ctx context.Context, service *youtube.Service, title string, started time.Time) (string, error) {
+	response, err := service.Search.List([]string{"snippet"}).ForMine(true).Type("video").
+		Order("date").MaxResults(10).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	var ids []string
+	for _, item := range response.Items {
+		if item.Id == nil || item.Snippet == nil || item.Snippet.Title != title {
+			continue
+		}
+		// allow for the clocks of this machine and Youtube differing
+		published, err := time.Parse(time.RFC3339, item.Snippet.PublishedAt)
+		if err != nil || published.Before(started.Add(-5*time.Minute)) {
+			continue
+		}
+		ids = append(ids, item.Id.VideoId)
+	}
+	if len(ids) > 1 {
+		warningsOf(ctx).warnf("Found %d recent videos titled '%s', not choosing between them: %v", len(ids), title, ids)
+		return "", nil
+	}
+	if len(ids) == 0 {
+		return "", nil
+	}
+	return ids[0], nil
+}
@@ -0,0 +1,273 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultHistoryFile is the name of the upload history in the config
+// directory
+const defaultHistoryFile = "history.jsonl"
+
+// historyEntry records one upload, successful or not, as a line of the
+// history file
+type historyEntry struct {
+	Time          time.Time `json:"time"`
+	Profile       string    `json:"profile,omitempty"`
+	File          string    `json:"file"`
+	Size          int64     `json:"size,omitempty"`
+	ModTime       time.Time `json:"modTime,omitempty"`
+	SHA256        string    `json:"sha256,omitempty"`
+	Checksum      string    `json:"checksum,omitempty"`
+	VideoID       string    `json:"videoId,omitempty"`
+	Title         string    `json:"title,omitempty"`
+	PrivacyStatus string    `json:"privacyStatus,omitempty"`
+	Duration      float64   `json:"durationSeconds"`
+	AverageRate   float64   `json:"averageBytesPerSecond"`
+	ExitCode      int       `json:"exitCode"`
+	Error         string    `json:"error,omitempty"`
+	AfterUpload   string    `json:"afterUpload,omitempty"`
+}
+
+// historyFlag is -showHistory, which takes an optional number of entries
+type historyFlag int
+
+// defaultShowHistory is the number of entries shown by -showHistory
+const defaultShowHistory = 20
+
+func (h *historyFlag) String() string {
+	return strconv.Itoa(int(*h))
+}
+
+func (h *historyFlag) Set(value string) error {
+	if value == "true" {
+		*h = defaultShowHistory
+		return nil
+	}
+	if value == "false" {
+		*h = 0
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("must be a number of entries")
+	}
+	*h = historyFlag(n)
+	return nil
+}
+
+// IsBoolFlag lets -showHistory be given without a number
+func (h *historyFlag) IsBoolFlag() bool {
+	return true
+}
+
+// history is the upload history, loaded when it is first needed
+var history struct {
+	sync.Mutex
+	loaded  bool
+	entries []historyEntry
+}
+
+// historyPath returns the history file, or "" if -historyFile is 'none'
+func historyPath() (string, error) {
+	switch *historyFile {
+	case "none":
+		return "", nil
+	case "":
+		dir, err := profileDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, defaultHistoryFile), nil
+	}
+	return *historyFile, nil
+}
+
+// historyEntries returns the upload history, oldest first. The caller must
+// hold the history lock.
+func historyEntries() ([]historyEntry, error) {
+	if history.loaded {
+		return history.entries, nil
+	}
+	path, err := historyPath()
+	if err != nil || path == "" {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		history.loaded = true
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading history: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a partly written line shouldn't lose the rest of the history
+			warnf("Ignoring line %d of '%s': %s", line, path, err)
+			continue
+		}
+		history.entries = append(history.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history: %s", err)
+	}
+	history.loaded = true
+	return history.entries, nil
+}
+
+// recordHistory appends the outcome of the upload of file to the history.
+// Failures are only warned about, as the upload itself is unaffected.
+func recordHistory(ctx context.Context, file string, result *uploadResult, uploadErr error) {
+	path, err := historyPath()
+	if err != nil || path == "" {
+		return
+	}
+	e := historyEntry{
+		Time:          time.Now(),
+		Profile:       *profile,
+		File:          file,
+		SHA256:        result.SHA256,
+		Checksum:      result.Checksum,
+		VideoID:       result.VideoID,
+		Title:         result.Title,
+		PrivacyStatus: result.PrivacyStatus,
+		Duration:      result.Duration,
+		AverageRate:   result.AverageRate,
+		AfterUpload:   result.AfterUpload,
+	}
+	if abs, err := filepath.Abs(file); err == nil && file != "-" && !isURL(file) {
+		e.File = abs
+		if fi, err := os.Stat(file); err == nil {
+			e.Size = fi.Size()
+			e.ModTime = fi.ModTime()
+		}
+	}
+	if uploadErr != nil {
+		e.ExitCode = exitCode(uploadErr)
+		e.Error = uploadErr.Error()
+	}
+
+	history.Lock()
+	defer history.Unlock()
+	// the entries are loaded first so that the new one isn't read twice
+	if _, err := historyEntries(); err != nil {
+		warningsOf(ctx).warnf("Error recording upload in history: %v", err)
+		return
+	}
+	if err := appendHistory(path, e); err != nil {
+		warningsOf(ctx).warnf("Error recording upload in history: %v", err)
+		return
+	}
+	history.entries = append(history.entries, e)
+}
+
+func appendHistory(path string, e historyEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// printHistory prints the last n entries of the history as a table
+func printHistory(w io.Writer, n int) error {
+	history.Lock()
+	entries, err := historyEntries()
+	history.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tVIDEO ID\tPRIVACY\tSIZE\tSTATUS\tFILE\tTITLE")
+	for _, e := range entries {
+		status := "ok"
+		if e.Error != "" {
+			status = fmt.Sprintf("failed (%d)", e.ExitCode)
+		}
+		size := ""
+		if e.Size > 0 {
+			size = strconv.FormatInt(e.Size, 10)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04"),
+			e.VideoID, e.PrivacyStatus, size, status, e.File, e.Title)
+	}
+	return tw.Flush()
+}
+
+// findHistoryDuplicate returns a ledger entry for the most recent upload in
+// the history of the file with the ledger key, or nil if there is none
+func findHistoryDuplicate(ctx context.Context, filename, key string) *ledgerEntry {
+	history.Lock()
+	entries, err := historyEntries()
+	history.Unlock()
+	if err != nil {
+		warningsOf(ctx).warnf("Cannot check the upload history for duplicates: %v", err)
+		return nil
+	}
+	hash := keyHash(key)
+	var fi os.FileInfo
+	if hash == "" {
+		if fi, err = os.Stat(filename); err != nil {
+			return nil
+		}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.VideoID == "" || e.Profile != *profile {
+			continue
+		}
+		if hash != "" && e.SHA256 != hash {
+			continue
+		}
+		if hash == "" && (filepath.Base(e.File) != filepath.Base(filename) ||
+			e.Size != fi.Size() || e.ModTime.Unix() != fi.ModTime().Unix()) {
+			continue
+		}
+		return &ledgerEntry{VideoID: e.VideoID, File: e.File, Uploaded: e.Time}
+	}
+	return nil
+}
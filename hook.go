@@ -0,0 +1,78 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// runHook runs -onSuccess or -onFailure for the upload of file, with the
+// outcome in its environment. A failing -onSuccess hook is returned as an
+// error, so that it isn't missed; a failing -onFailure hook is only warned
+// about, as the upload has already failed.
+func runHook(ctx context.Context, file string, result *uploadResult, uploadErr error) error {
+	name, command := "onSuccess", *onSuccess
+	if uploadErr != nil {
+		name, command = "onFailure", *onFailure
+	}
+	if command == "" {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout = promptOutput
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"VIDEO_ID="+result.VideoID,
+		"VIDEO_URL="+result.URL,
+		"FILE="+file,
+		"TITLE="+result.Title,
+		"BYTES_SENT="+strconv.FormatInt(result.FileSize, 10),
+		"DURATION_SECONDS="+strconv.FormatFloat(result.Duration, 'f', 3, 64),
+	)
+	if uploadErr != nil {
+		cmd.Env = append(cmd.Env, "ERROR="+uploadErr.Error())
+	}
+
+	// a status of 0 is printed too, so that it is clear the hook ran
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err == nil {
+		fmt.Fprintf(output, "-%s hook exited with status %d\n", name, cmd.ProcessState.ExitCode())
+	} else if errors.As(err, &exitErr) {
+		err = fmt.Errorf("-%s hook exited with status %d", name, exitErr.ExitCode())
+	} else if err != nil {
+		err = fmt.Errorf("error running -%s hook: %w", name, err)
+	}
+	if err != nil && uploadErr != nil {
+		warningsOf(ctx).warnf("'%s': %v", file, err)
+		return nil
+	}
+	if err != nil {
+		return withCode(errCodeHook, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunHookStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are run with sh")
+	}
+	var buf bytes.Buffer
+	oldOutput, oldPrompt := output, promptOutput
+	output, promptOutput = &buf, &buf
+	defer func() { output, promptOutput = oldOutput, oldPrompt }()
+
+	setFlags(t, map[string]string{"onSuccess": "true", "onFailure": "exit 3"})
+	if err := runHook(context.Background(), "a.mp4", &uploadResult{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "-onSuccess hook exited with status 0") {
+		t.Errorf("status 0 not printed: %q", buf.String())
+	}
+
+	ctx, warnings := withWarnings(context.Background())
+	if err := runHook(ctx, "a.mp4", &uploadResult{}, errors.New("upload failed")); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(warnings.take(), "\n"); !strings.Contains(got, "-onFailure hook exited with status 3") {
+		t.Errorf("got warnings %q", got)
+	}
+
+	setFlags(t, map[string]string{"onSuccess": "exit 2"})
+	if err := runHook(context.Background(), "a.mp4", &uploadResult{}, nil); errorCode(err) != errCodeHook || !strings.Contains(err.Error(), "status 2") {
+		t.Errorf("got %v", err)
+	}
+}
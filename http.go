@@ -15,27 +15,32 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"net/url"
 
-	"github.com/porjo/go-flowrate/flowrate"
 	"google.golang.org/api/youtube/v3"
 )
 
 const inputTimeLayout = "15:04"
 
-type limitTransport struct {
-	rt       http.RoundTripper
-	lr       limitRange
-	reader   *flowrate.Reader
-	filesize int64
-}
-
 type Playlistx struct {
 	Id            string
 	Title         string
 	PrivacyStatus string
+	// Create is set to create the playlist if there is none with the title
+	Create bool
+}
+
+// Caption is a caption track to be inserted after the video is uploaded
+type Caption struct {
+	// BCP-47 language code e.g. 'en','es'
+	Language string `json:"language"`
+	// Name of the caption track. Defaults to the language code
+	Name string `json:"name,omitempty"`
+	// File is a local path or URL
+	File string `json:"file"`
 }
 
 type VideoMeta struct {
@@ -47,10 +52,13 @@ type VideoMeta struct {
 
 	// status
 	PrivacyStatus       string `json:"privacyStatus,omitempty"`
-	Embeddable          bool   `json:"embeddable,omitempty"`
+	Embeddable          *bool  `json:"embeddable,omitempty"`
 	License             string `json:"license,omitempty"`
-	PublicStatsViewable bool   `json:"publicStatsViewable,omitempty"`
+	PublicStatsViewable *bool  `json:"publicStatsViewable,omitempty"`
 	PublishAt           Date   `json:"publishAt,omitempty"`
+	// SelfDeclaredMadeForKids declares whether the video is made for
+	// children, instead of the channel's default audience setting
+	SelfDeclaredMadeForKids *bool `json:"selfDeclaredMadeForKids,omitempty"`
 
 	// recording details
 	Location            *youtube.GeoPoint `json:"location,omitempty"`
@@ -64,80 +72,62 @@ type VideoMeta struct {
 
 	// BCP-47 language code e.g. 'en','es'
 	Language string `json:"language,omitempty"`
+	// AudioLanguage defaults to Language
+	AudioLanguage string `json:"audioLanguage,omitempty"`
+
+	Captions []Caption `json:"captions,omitempty"`
+
+	// MonetizationAllowed sets whether ads may be shown on the video. It
+	// needs a channel in the YouTube Partner Program.
+	MonetizationAllowed *bool `json:"monetizationAllowed,omitempty"`
+	// MonetizationExcludedRegions are the region codes where ads aren't
+	// shown on the video. It requires MonetizationAllowed to be true.
+	MonetizationExcludedRegions []string `json:"monetizationExcludedRegions,omitempty"`
+	// AdFormats and AdBreaks are the per-format ad settings of YouTube
+	// Studio. The Data API's monetizationDetails only has the access policy
+	// above; these are only in the Content ID API for content owners, so
+	// they are recognised just to reject them with an explanation.
+	AdFormats json.RawMessage `json:"adFormats,omitempty"`
+	AdBreaks  json.RawMessage `json:"adBreaks,omitempty"`
+
+	// NotifySubscribers is overridden by the -notifySubscribers flag
+	NotifySubscribers *bool `json:"notifySubscribers,omitempty"`
+
+	// Thumbnail is a JPEG or PNG image, local or URL, to set on the video
+	Thumbnail string `json:"thumbnail,omitempty"`
+
+	// Localizations are translated titles and descriptions, keyed by
+	// BCP-47 language code. They require Language to be set.
+	Localizations map[string]Localization `json:"localizations,omitempty"`
 }
 
-func (t *limitTransport) RoundTrip(r *http.Request) (res *http.Response, err error) {
-	// Content-Type starts with 'multipart/related' where chunksize >= filesize (including chunksize 0)
-	// and 'video' for other chunksizes
-	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/related") ||
-		strings.HasPrefix(r.Header.Get("Content-Type"), "video") {
-		var monitor *flowrate.Monitor
-
-		if t.reader != nil {
-			monitor = t.reader.Monitor
-		}
-
-		// limit is set in limitChecker.Read
-		t.reader = flowrate.NewReader(r.Body, 0)
-
-		if monitor != nil {
-			// carry over stats to new limiter
-			t.reader.Monitor = monitor
-		} else {
-			t.reader.Monitor.SetTransferSize(t.filesize)
-		}
-		r.Body = &limitChecker{t.lr, t.reader}
-	}
-
-	return t.rt.RoundTrip(r)
+// Localization is the title and description of a video in one language
+type Localization struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
 }
 
-func (plx *Playlistx) AddVideoToPlaylist(service *youtube.Service, videoID string) (err error) {
-	listCall := service.Playlists.List("snippet,contentDetails")
-	listCall = listCall.Mine(true)
-	response, err := listCall.Do()
-	if err != nil {
-		return fmt.Errorf("error retrieving playlists: %s", err)
-	}
+// baseTransport is http.DefaultTransport with -proxy and the flags about
+// dialing applied, before it is wrapped for -apiBaseURL and -debugHTTP. The
+// SDKs of cloud sources take their proxy and dialer from it.
+var baseTransport *http.Transport
 
-	var playlist *youtube.Playlist
-	for _, pl := range response.Items {
-		if pl.Id == plx.Id || pl.Snippet.Title == plx.Title {
-			playlist = pl
-			break
-		}
-	}
-
-	// create playlist if it doesn't exist
-	if playlist == nil {
-		if plx.Id != "" {
-			return fmt.Errorf("playlist ID '%s' doesn't exist", plx.Id)
-		}
-		playlist = &youtube.Playlist{}
-		playlist.Snippet = &youtube.PlaylistSnippet{Title: plx.Title}
-		playlist.Status = &youtube.PlaylistStatus{PrivacyStatus: plx.PrivacyStatus}
-		insertCall := service.Playlists.Insert("snippet,status", playlist)
-		// API doesn't return playlist ID here!?
-		playlist, err = insertCall.Do()
-		if err != nil {
-			return fmt.Errorf("error creating playlist with title '%s': %s", plx.Title, err)
-		}
+// proxyTransport returns a copy of the default transport that sends all
+// requests through the http, https or socks5 proxy at proxyURL
+func proxyTransport(proxyURL string) (http.RoundTripper, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL '%s': %s", proxyURL, err)
 	}
-
-	playlistItem := &youtube.PlaylistItem{}
-	playlistItem.Snippet = &youtube.PlaylistItemSnippet{PlaylistId: playlist.Id, Title: playlist.Snippet.Title}
-	playlistItem.Snippet.ResourceId = &youtube.ResourceId{
-		VideoId: videoID,
-		Kind:    "youtube#video",
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL '%s': scheme must be http, https or socks5", proxyURL)
 	}
-
-	insertCall := service.PlaylistItems.Insert("snippet", playlistItem)
-	_, err = insertCall.Do()
-	if err != nil {
-		return err
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL '%s': no host", proxyURL)
 	}
-
-	fmt.Printf("Video added to playlist '%s' (%s)\n", playlist.Snippet.Title, playlist.Id)
-
-	return nil
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(u)
+	return transport, nil
 }
@@ -0,0 +1,100 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProxyTransportConnect(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer target.Close()
+
+	var mu sync.Mutex
+	var connects []string
+	var auth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connects = append(connects, r.Method+" "+r.Host)
+		auth = r.Header.Get("Proxy-Authorization")
+		mu.Unlock()
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go io.Copy(upstream, buf)
+		io.Copy(conn, upstream)
+	}))
+	defer proxy.Close()
+
+	rt, err := proxyTransport("http://user:secret@" + strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := rt.(*http.Transport)
+	// trust the test server's certificate
+	transport.TLSClientConfig = target.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	defer transport.CloseIdleConnections()
+
+	res, err := (&http.Client{Transport: transport}).Get(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("got body %q", body)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := "CONNECT " + strings.TrimPrefix(target.URL, "https://")
+	if len(connects) != 1 || connects[0] != want {
+		t.Errorf("proxy got %v, want [%s]", connects, want)
+	}
+	if wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret")); auth != wantAuth {
+		t.Errorf("Proxy-Authorization %q, want %q", auth, wantAuth)
+	}
+}
+
+func TestProxyTransportInvalid(t *testing.T) {
+	for _, u := range []string{"ftp://proxy:21", "proxy:3128", "http://", "://x"} {
+		if _, err := proxyTransport(u); err == nil {
+			t.Errorf("proxyTransport(%q): no error", u)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// sourceHeaders are the -sourceHeader headers, parsed by parseSourceHeaders
+var sourceHeaders = http.Header{}
+
+// parseSourceHeaders parses the -sourceHeader flags. Environment variable
+// references such as ${TOKEN} in the values are expanded. The values may be
+// secret, so they are never included in messages.
+func parseSourceHeaders(headers []string) error {
+	for _, h := range headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			// the header may be secret, so isn't included
+			return fmt.Errorf("invalid -sourceHeader, must be in the form 'Name: Value'")
+		}
+		name := strings.TrimSpace(h[:i])
+		sourceHeaders.Add(name, os.ExpandEnv(strings.TrimSpace(h[i+1:])))
+	}
+	if *sourceAuth != "" && !strings.Contains(*sourceAuth, ":") {
+		return fmt.Errorf("invalid -sourceBasicAuth, must be in the form user:password")
+	}
+	return nil
+}
+
+// sourceRequest returns the request that fetches the source URL, starting
+// at byte offset, with the -sourceHeader headers and -sourceBasicAuth
+// credentials
+func sourceRequest(url string, offset int64) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range sourceHeaders {
+		req.Header[name] = values
+	}
+	if *sourceAuth != "" {
+		auth := os.ExpandEnv(*sourceAuth)
+		i := strings.Index(auth, ":")
+		req.SetBasicAuth(auth[:i], auth[i+1:])
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return req, nil
+}
+
+// sourceStatusError describes an error response to a source request
+func sourceStatusError(url string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("source fetch unauthorized: %s returned %s. Check -sourceHeader and -sourceBasicAuth", url, resp.Status)
+	}
+	return fmt.Errorf("error opening %s: server returned %s", url, resp.Status)
+}
+
+// checkSourceAccess requests the first byte of a URL source, fetches the
+// metadata of an s3:// or gs:// object, or logs in to the host of an sftp://
+// URL, so that missing or wrong credentials are reported before anything is
+// sent to Youtube
+func checkSourceAccess(url string) error {
+	if isSFTPURL(url) {
+		return checkSFTPAccess(url)
+	}
+	if isCloudURL(url) {
+		return checkCloudAccess(url)
+	}
+	req, err := sourceRequest(url, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", url, err)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// other problems are reported when the source is opened
+		return nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return sourceStatusError(url, resp)
+	}
+	return nil
+}
+
+// openRemote opens a remote source, over SFTP for sftp:// URLs, with the
+// cloud's SDK for s3:// and gs:// URLs and HTTP otherwise
+func openRemote(source string) (io.ReadCloser, int64, error) {
+	if isSFTPURL(source) {
+		return openSFTP(source)
+	}
+	if isCloudURL(source) {
+		return openCloud(source)
+	}
+	return openHTTP(source)
+}
+
+// openHTTP fetches a source file from a URL, following redirects. The size
+// is 0 if the server doesn't give one.
+func openHTTP(url string) (io.ReadCloser, int64, error) {
+	req, err := sourceRequest(url, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening %s: %s", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening %s: %s", url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, 0, sourceStatusError(url, resp)
+	}
+	filesize := resp.ContentLength
+	if filesize < 0 {
+		// unknown size e.g. a chunked response
+		filesize = 0
+	}
+	return &rangeReader{
+		url:          url,
+		body:         resp.Body,
+		size:         filesize,
+		acceptRanges: resp.Header.Get("Accept-Ranges") == "bytes",
+		contentType:  resp.Header.Get("Content-Type"),
+	}, filesize, nil
+}
+
+// rangeReader reads a source URL, reconnecting with a Range request to
+// continue where it left off if the connection fails
+type rangeReader struct {
+	url          string
+	body         io.ReadCloser
+	offset       int64
+	size         int64
+	acceptRanges bool
+	// contentType is the Content-Type of the first response
+	contentType string
+	// reconnects is the number of times the download was continued
+	reconnects int
+	// warnings are those of the upload the source is read for
+	warnings *warningList
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.size > 0 && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+
+	if !r.acceptRanges {
+		return n, fmt.Errorf("error reading %s: %s. The server doesn't support Range requests, so the download can't be continued", r.url, err)
+	}
+	if rerr := r.reconnect(err); rerr != nil {
+		return n, rerr
+	}
+	return n, nil
+}
+
+// reconnect continues the download at the current offset after err
+func (r *rangeReader) reconnect(err error) error {
+	r.body.Close()
+	for attempt := 1; ; attempt++ {
+		if attempt > *sourceRetries {
+			return fmt.Errorf("error reading %s at byte %d, giving up after %d reconnects: %s", r.url, r.offset, *sourceRetries, err)
+		}
+		r.warnings.warnf("Error reading %s at byte %d, reconnecting: %s", r.url, r.offset, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+
+		var req *http.Request
+		req, err = sourceRequest(r.url, r.offset)
+		if err != nil {
+			return err
+		}
+		var resp *http.Response
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			// a 200 would restart the file from the beginning
+			return fmt.Errorf("error continuing %s at byte %d: server returned %s", r.url, r.offset, resp.Status)
+		}
+		r.body = resp.Body
+		r.reconnects++
+		return nil
+	}
+}
+
+func (r *rangeReader) Close() error {
+	return r.body.Close()
+}
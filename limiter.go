@@ -19,48 +19,13 @@ import (
 	"strings"
 	"time"
 
-	"github.com/porjo/go-flowrate/flowrate"
+	"github.com/porjo/youtubeuploader/uploader"
 )
 
-type limitRange struct {
-	start time.Time
-	end   time.Time
-}
-
-type limitChecker struct {
-	limitRange
-	reader *flowrate.Reader
-}
-
-func (lc *limitChecker) Read(p []byte) (n int, err error) {
-	if lc.start.IsZero() || lc.end.IsZero() {
-		lc.reader.SetLimit(int64(*rate * 125))
-		return lc.reader.Read(p)
-	}
-
-	now := time.Now()
-
-	if now.Sub(lc.start) >= time.Hour*24 {
-		lc.start = lc.start.AddDate(0, 0, 1)
-		lc.end = lc.end.AddDate(0, 0, 1)
-	}
-
-	if lc.start.Before(now) && lc.end.After(now) {
-		// kbit/s to B/s = 1000/8 = 125
-		lc.reader.SetLimit(int64(*rate * 125))
-	} else {
-		lc.reader.SetLimit(0)
-	}
-
-	return lc.reader.Read(p)
-}
-
-func (lc *limitChecker) Close() error {
-	return nil
-}
-
-func parseLimitBetween(between string) (limitRange, error) {
-	var lr limitRange
+// parseLimitBetween parses -limitBetween, e.g. 10:00-14:00, as the window of
+// today the rate limit applies in
+func parseLimitBetween(between string) (uploader.Window, error) {
+	var lr uploader.Window
 	var err error
 	var start, end time.Time
 	parts := strings.Split(between, "-")
@@ -74,17 +39,17 @@ func parseLimitBetween(between string) (limitRange, error) {
 	if err != nil {
 		return lr, fmt.Errorf("limitBetween start time was invalid: %v", err)
 	}
-	lr.start = time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, time.Local)
+	lr.Start = time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, time.Local)
 
 	end, err = time.ParseInLocation(inputTimeLayout, parts[1], time.Local)
 	if err != nil {
 		return lr, fmt.Errorf("limitBetween end time was invalid: %v", err)
 	}
-	lr.end = time.Date(now.Year(), now.Month(), now.Day(), end.Hour(), end.Minute(), 0, 0, time.Local)
+	lr.End = time.Date(now.Year(), now.Month(), now.Day(), end.Hour(), end.Minute(), 0, 0, time.Local)
 
 	// handle range spanning midnight
-	if lr.end.Before(lr.start) {
-		lr.end = lr.end.AddDate(0, 0, 1)
+	if lr.End.Before(lr.Start) {
+		lr.End = lr.End.AddDate(0, 0, 1)
 	}
 
 	return lr, nil
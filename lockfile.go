@@ -0,0 +1,183 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// noLockFile is the value of -lockFile that turns locking off
+const noLockFile = "none"
+
+// lockPollInterval is how often a lock held by another process is tried
+// again while waiting for it with -lockWait
+const lockPollInterval = 500 * time.Millisecond
+
+// processLock is a lock file held by this process. The lock itself is taken
+// by the operating system, so it goes away with the process, and the file
+// holds the process ID while it is held so that the holder can be named.
+type processLock struct {
+	path string
+	f    *os.File
+}
+
+// lockFiles returns the lock files for this run: -lockFile, or by default
+// one for each profile in the config directory next to its token, so that
+// runs using different accounts don't wait for each other
+func lockFiles() ([]string, error) {
+	if *lockFile != "" {
+		if *lockFile == noLockFile {
+			return nil, nil
+		}
+		return []string{*lockFile}, nil
+	}
+	dir, err := profileDir()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{*profile}
+	if len(profileNames) > 0 {
+		names = append([]string{}, profileNames...)
+	}
+	// always taken in the same order, so that two runs with overlapping
+	// -profiles can't each hold a lock the other is waiting for
+	sort.Strings(names)
+	var paths []string
+	for _, name := range names {
+		if name == "" {
+			paths = append(paths, filepath.Join(dir, "youtubeuploader.lock"))
+		} else {
+			paths = append(paths, filepath.Join(dir, "locks", name+".lock"))
+		}
+	}
+	return paths, nil
+}
+
+// acquireLocks takes the lock files of the run, waiting up to -lockWait for
+// another process to release them, and returns a function which releases
+// them. The locks are held for the whole run, so that e.g. an hourly cron job
+// doesn't start uploading the same files while the last one is still going.
+func acquireLocks() (func(), error) {
+	paths, err := lockFiles()
+	if err != nil {
+		return nil, withCode(errCodeUsage, err)
+	}
+	var locks []*processLock
+	release := func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].release()
+		}
+	}
+	for _, path := range paths {
+		l, err := acquireLock(path, *lockWait)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+	return release, nil
+}
+
+// acquireLock takes the lock file at path, trying again until wait has passed
+// if another process holds it
+func acquireLock(path string, wait time.Duration) (*processLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("cannot create the lock file directory: %s", err))
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("cannot open the lock file: %s", err))
+	}
+
+	deadline := time.Now().Add(wait)
+	waiting := false
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, withCode(errCodeUsage, fmt.Errorf("cannot lock '%s': %s", path, err))
+		}
+		if locked {
+			break
+		}
+		holder := describeLockHolder(lockHolder(f))
+		if !time.Now().Before(deadline) {
+			f.Close()
+			if wait > 0 {
+				return nil, withCode(errCodeLocked, fmt.Errorf("%s still holds the lock '%s' after waiting %s", holder, path, wait))
+			}
+			return nil, withCode(errCodeLocked, fmt.Errorf("%s holds the lock '%s'. Use -lockWait to wait for it to finish", holder, path))
+		}
+		if !waiting {
+			fmt.Fprintf(output, "Waiting up to %s for %s to release the lock '%s'\n", wait, holder, path)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	// the process ID is removed when the lock is released, so one that is
+	// left behind is from a process that didn't get to do that
+	if pid := lockHolder(f); pid != 0 && pid != os.Getpid() && !processExists(pid) {
+		fmt.Fprintf(output, "Breaking the stale lock '%s' of process %d, which no longer exists\n", path, pid)
+	}
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		warnf("Cannot write the process ID to the lock file '%s': %s", path, err)
+	}
+	return &processLock{path: path, f: f}, nil
+}
+
+// release empties the lock file and unlocks it. The file isn't removed, as
+// another process may already have it open and be waiting for the lock.
+func (l *processLock) release() {
+	l.f.Truncate(0)
+	unlockFile(l.f)
+	l.f.Close()
+}
+
+// lockHolder returns the process ID written to the lock file f, or 0 if
+// there is none
+func lockHolder(f *os.File) int {
+	if _, err := f.Seek(0, 0); err != nil {
+		return 0
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func describeLockHolder(pid int) string {
+	if pid == 0 {
+		return "another youtubeuploader"
+	}
+	return fmt.Sprintf("another youtubeuploader (process %d)", pid)
+}
@@ -0,0 +1,157 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// setThumbnail sets the thumbnail of the video to data, read from file
+func setThumbnail(ctx context.Context, service *youtube.Service, videoID, file string, data []byte) error {
+	fmt.Fprintf(output, "Uploading thumbnail '%s'...\n", file)
+	err := retryCall(ctx, func() error {
+		_, err := service.Thumbnails.Set(videoID).Media(bytes.NewReader(data)).Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error uploading thumbnail for video ID %s: %w", videoID, err)
+	}
+	fmt.Fprintf(output, "Thumbnail uploaded!\n")
+	return nil
+}
+
+// insertCaption adds the caption track c, read from r, to the video
+func insertCaption(service *youtube.Service, videoID string, c Caption, r io.Reader) error {
+	captionObj := &youtube.Caption{
+		Snippet: &youtube.CaptionSnippet{
+			VideoId:  videoID,
+			Language: c.Language,
+			Name:     c.Name,
+		},
+	}
+	if captionObj.Snippet.Name == "" {
+		captionObj.Snippet.Name = c.Language
+	}
+	captionRes, err := service.Captions.Insert([]string{"snippet"}, captionObj).Sync(true).Media(r).Do()
+	if err != nil {
+		if captionRes != nil {
+			return fmt.Errorf("Error inserting caption '%s': %w, %v", c.File, err, captionRes.HTTPStatusCode)
+		}
+		return fmt.Errorf("Error inserting caption '%s': %w", c.File, err)
+	}
+	fmt.Fprintf(output, "Caption '%s' (%s) uploaded!\n", c.File, c.Language)
+	return nil
+}
+
+// maintenanceFiles checks and returns the thumbnail for -setThumbnail and
+// the captions for -setCaptions. The file may be given as the argument after
+// the video ID, e.g. -setThumbnail ID thumb.jpg, when only one of them is
+// used.
+func maintenanceFiles() (*maintenance, error) {
+	m := &maintenance{thumbFile: *thumbnail}
+	if *setThumb == "" && *setCaps == "" {
+		return m, nil
+	}
+	specs := append([]string{}, captions...)
+	args := flag.Args()
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("flag %s was given after the file, so it wasn't used. Give the files last", arg)
+		}
+	}
+	if len(args) > 0 {
+		switch {
+		case *setThumb != "" && *setCaps != "":
+			return nil, errors.New("use -thumbnail and -caption to give the files when both -setThumbnail and -setCaptions are used")
+		case *setThumb != "":
+			if len(args) > 1 || m.thumbFile != "" {
+				return nil, errors.New("-setThumbnail takes a single thumbnail file")
+			}
+			m.thumbFile = args[0]
+		default:
+			specs = append(specs, args...)
+		}
+	}
+
+	if *setThumb != "" {
+		if m.thumbFile == "" {
+			return nil, errors.New("-setThumbnail needs a thumbnail file: -setThumbnail VIDEO_ID FILE")
+		}
+		var err error
+		if m.thumbData, err = LoadThumbnail(m.thumbFile); err != nil {
+			return nil, err
+		}
+	}
+	if *setCaps != "" {
+		if len(specs) == 0 {
+			return nil, errors.New("-setCaptions needs a caption: -setCaptions VIDEO_ID lang=file")
+		}
+		for _, spec := range specs {
+			c := parseCaption(spec, *language)
+			r, _, err := Open(c.File)
+			if err != nil {
+				return nil, err
+			}
+			r.Close()
+			m.captions = append(m.captions, c)
+		}
+	}
+	return m, nil
+}
+
+// maintenance is the work of -setThumbnail and -setCaptions
+type maintenance struct {
+	thumbFile string
+	thumbData []byte
+	captions  []Caption
+}
+
+// run sets the thumbnail of the -setThumbnail video and adds captions to the
+// -setCaptions video, without uploading anything
+func (m *maintenance) run(service *youtube.Service) error {
+	if *setThumb != "" {
+		result := &uploadResult{File: m.thumbFile}
+		result.setVideoURLs(*setThumb)
+		if err := setThumbnail(context.Background(), service, *setThumb, m.thumbFile, m.thumbData); err != nil {
+			return withCode(errCodeThumbnail, err)
+		}
+		result.Warnings = takeWarnings()
+		writeResult(result)
+	}
+	for _, c := range m.captions {
+		result := &uploadResult{File: c.File}
+		result.setVideoURLs(*setCaps)
+		r, _, err := Open(c.File)
+		if err != nil {
+			return withCode(errCodeCaption, err)
+		}
+		err = insertCaption(service, *setCaps, c, r)
+		r.Close()
+		if err != nil {
+			return withCode(errCodeCaption, err)
+		}
+		result.Warnings = takeWarnings()
+		writeResult(result)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// manifestDoneSuffix is appended to the -manifest file name to give its
+// journal of finished lines
+const manifestDoneSuffix = ".done"
+
+// manifestDone is a line of the journal, recording that a line of the
+// manifest has been uploaded
+type manifestDone struct {
+	Line    int    `json:"line"`
+	File    string `json:"file"`
+	VideoID string `json:"videoId"`
+	// Profile is set when uploading to several -profiles, as each of them
+	// uploads the line
+	Profile string `json:"profile,omitempty"`
+}
+
+// manifestJournal serialises appends to the journal by concurrent uploads
+var manifestJournal sync.Mutex
+
+// loadManifest returns a job for each line of the manifest at path that
+// hasn't already been uploaded according to its journal. Each line is a JSON
+// object with the fields of the meta JSON file, plus the file to upload.
+// Lines that can't be parsed or fail validation are reported and skipped,
+// and the number of them is returned.
+func loadManifest(path string) ([]uploadJob, int, error) {
+	done, err := readManifestDone(path + manifestDoneSuffix)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading manifest: %s", err)
+	}
+	defer f.Close()
+
+	var jobs []uploadJob
+	var invalid int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		job, err := manifestJob(data)
+		if err != nil {
+			warnf("Skipping line %d of manifest '%s': %s", line, path, err)
+			invalid++
+			continue
+		}
+		if d, ok := done[line]; ok && d.File == job.filename {
+			fmt.Fprintf(output, "Line %d of manifest '%s' was uploaded as video ID %s, skipping\n", line, path, d.VideoID)
+			continue
+		}
+		// checked after the journal, as -afterUpload may have removed the
+		// files of lines that were uploaded
+		if job.filename != "-" && !isURL(job.filename) {
+			if _, err := os.Stat(job.filename); err != nil {
+				warnf("Skipping line %d of manifest '%s': %s", line, path, err)
+				invalid++
+				continue
+			}
+		}
+		job.manifest = path
+		job.manifestLine = line
+		jobs = append(jobs, job)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading manifest: %s", err)
+	}
+	return jobs, invalid, nil
+}
+
+// manifestJob builds the job for a line of the manifest, merging in the
+// flags like a meta JSON file
+func manifestJob(data []byte) (uploadJob, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return uploadJob{}, err
+	}
+	var job uploadJob
+	if raw, ok := fields["file"]; ok {
+		if err := json.Unmarshal(raw, &job.filename); err != nil {
+			return job, fmt.Errorf("invalid file: %s", err)
+		}
+	}
+	if job.filename == "" {
+		return job, errors.New("no file given")
+	}
+	filename, err := localPath(job.filename)
+	if err != nil {
+		return job, err
+	}
+	job.filename = filename
+
+	// with -profiles, the fields in "profiles" under the current profile
+	// replace those of the line
+	if raw, ok := fields["profiles"]; ok {
+		var overrides map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &overrides); err != nil {
+			return job, fmt.Errorf("invalid profiles: %s", err)
+		}
+		for name, v := range overrides[*profile] {
+			if name == "file" {
+				return job, fmt.Errorf("the file can't be changed for profile '%s'", *profile)
+			}
+			fields[name] = v
+		}
+	}
+
+	// the rest of the line is decoded as the meta JSON file would be
+	delete(fields, "file")
+	delete(fields, "profiles")
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return job, err
+	}
+	job.videoMeta, err = decodeVideoMeta(rest)
+	if err != nil {
+		return job, err
+	}
+	upload := newVideo()
+	applyVideoMeta(job.videoMeta, upload)
+	job.upload, job.templates, err = finishVideo(upload, "")
+	return job, err
+}
+
+// readManifestDone reads the journal of uploaded manifest lines, keyed by
+// line number. Lines uploaded to other -profiles are left out. A missing
+// journal means nothing has been uploaded.
+func readManifestDone(path string) (map[int]manifestDone, error) {
+	done := make(map[int]manifestDone)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest journal: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d manifestDone
+		// a partly written line is from an upload that wasn't recorded
+		if err := json.Unmarshal(scanner.Bytes(), &d); err == nil && (d.Profile == "" || d.Profile == *profile) {
+			done[d.Line] = d
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading manifest journal: %s", err)
+	}
+	return done, nil
+}
+
+// markManifestDone records in the journal that the manifest line of job was
+// uploaded as videoID, so that it is skipped if the manifest is run again.
+// Failures are warned about, as the upload itself succeeded.
+func markManifestDone(ctx context.Context, job uploadJob, videoID string) {
+	d := manifestDone{Line: job.manifestLine, File: job.filename, VideoID: videoID}
+	if len(profileNames) > 0 {
+		d.Profile = *profile
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	manifestJournal.Lock()
+	defer manifestJournal.Unlock()
+	path := job.manifest + manifestDoneSuffix
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		warningsOf(ctx).warnf("Error recording line %d of the manifest as uploaded in '%s': %v", job.manifestLine, path, err)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+// profileNames is -profiles, the profiles to upload the same files to
+var profileNames stringList
+
+// profileMeta holds the -metaJSON.<profile> flags, keyed by profile
+var profileMeta = map[string]*string{}
+
+// profileMetaPrefix starts the name of a -metaJSON.<profile> flag
+const profileMetaPrefix = "metaJSON."
+
+func init() {
+	flag.Var(&profileNames, "profiles", "Comma separated profiles to upload the same files to, one after the other, e.g. main,clips. -metaJSON.<profile> gives a profile its own meta JSON file")
+}
+
+// registerProfileMetaFlags defines a -metaJSON.<profile> flag for each one in
+// args, as the profiles aren't known until the flags have been parsed
+func registerProfileMetaFlags(args []string) {
+	for _, arg := range args {
+		if arg == "--" {
+			return
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg || !strings.HasPrefix(name, profileMetaPrefix) {
+			continue
+		}
+		if i := strings.Index(name, "="); i >= 0 {
+			name = name[:i]
+		}
+		p := strings.TrimPrefix(name, profileMetaPrefix)
+		if _, ok := profileMeta[p]; ok || p == "" {
+			continue
+		}
+		profileMeta[p] = flag.String(name, "", fmt.Sprintf("Meta JSON file used instead of -metaJSON for uploads to the %s profile", p))
+	}
+}
+
+// validateProfiles checks -profiles against the flags it can't be used with
+func validateProfiles() error {
+	for name := range profileMeta {
+		if !profileNames.contains(name) {
+			return fmt.Errorf("-%s%s was given but %s isn't one of -profiles", profileMetaPrefix, name, name)
+		}
+	}
+	if len(profileNames) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, name := range profileNames {
+		if err := validateProfile(name); err != nil {
+			return err
+		}
+		if seen[name] {
+			return fmt.Errorf("profile '%s' is given twice in -profiles", name)
+		}
+		seen[name] = true
+	}
+	switch {
+	case *profile != "":
+		return fmt.Errorf("-profile and -profiles can't be used together")
+	case *cache != "":
+		return fmt.Errorf("-cache can't be used with -profiles, as each profile has its own token")
+	case *videoID != "" || *setThumb != "" || *setCaps != "":
+		return fmt.Errorf("-profiles can only be used to upload videos, as an existing video belongs to one account")
+	}
+	for _, f := range filenames {
+		if f == "-" {
+			return fmt.Errorf("-filename - can't be used with -profiles, as stdin can only be read once")
+		}
+	}
+	return nil
+}
+
+func (s stringList) contains(v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadToProfiles uploads files and the -manifest to each of -profiles in
+// turn, then prints how each went. URL sources are downloaded once and
+// shared. The error is that of the first profile that failed.
+func uploadToProfiles(files []string, maint *maintenance, limitRange uploader.Window) error {
+	if !*dryRun {
+		sourceCache.enable()
+		defer sourceCache.remove()
+	}
+
+	baseMeta := *metaJSON
+	errs := make([]error, len(profileNames))
+	ran := make([]bool, len(profileNames))
+	var failure error
+	for i, name := range profileNames {
+		if atomic.LoadInt32(&interrupted) != 0 || (failure != nil && *failFast) {
+			break
+		}
+		*profile = name
+		*metaJSON = baseMeta
+		if m := profileMeta[name]; m != nil && *m != "" {
+			*metaJSON = *m
+		}
+		fmt.Fprintf(output, "\nUploading to profile '%s' (%d/%d)\n", name, i+1, len(profileNames))
+		ran[i] = true
+		err := uploadFiles(files, maint, limitRange)
+		if err == nil {
+			continue
+		}
+		// the other profiles are still uploaded to, so the error is reported
+		// now rather than by main
+		if _, ok := err.(reportedError); !ok {
+			reportError("", "", err)
+			err = reportedError{errorCode(err)}
+		}
+		errs[i] = err
+		if failure == nil {
+			failure = err
+		}
+	}
+
+	fmt.Fprintf(output, "\n")
+	var succeeded int
+	for i, name := range profileNames {
+		switch {
+		case !ran[i]:
+			fmt.Fprintf(output, "Profile '%s': skipped\n", name)
+		case errs[i] != nil:
+			fmt.Fprintf(output, "Profile '%s': failed\n", name)
+		default:
+			fmt.Fprintf(output, "Profile '%s': succeeded\n", name)
+			succeeded++
+		}
+	}
+	fmt.Fprintf(output, "%d of %d profiles succeeded\n", succeeded, len(profileNames))
+	return failure
+}
+
+// sourceCache keeps a local copy of each URL source while uploading to
+// several profiles, so that it is only downloaded once
+var sourceCache urlCache
+
+type urlCache struct {
+	mu      sync.Mutex
+	enabled bool
+	paths   map[string]string
+}
+
+func (c *urlCache) enable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = true
+	c.paths = make(map[string]string)
+}
+
+// open opens the local copy of the URL source, downloading it first if this
+// is the first time it is used. ok is false if the cache isn't enabled.
+func (c *urlCache) open(source string) (r io.ReadCloser, size int64, ok bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return nil, 0, false, nil
+	}
+	p, cached := c.paths[source]
+	if !cached {
+		if p, err = download(source); err != nil {
+			return nil, 0, true, err
+		}
+		c.paths[source] = p
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, true, fmt.Errorf("error opening the copy of %s: %s", source, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, true, fmt.Errorf("error opening the copy of %s: %s", source, err)
+	}
+	return f, fi.Size(), true, nil
+}
+
+// remove deletes the local copies
+func (c *urlCache) remove() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.paths {
+		os.Remove(p)
+	}
+	c.enabled = false
+	c.paths = nil
+}
+
+// download copies the URL source to a temporary file, keeping its extension.
+// A video is checked by its contents once the copy is opened.
+func download(source string) (string, error) {
+	r, _, err := openRemote(source)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	var ext string
+	if u, err := url.Parse(source); err == nil {
+		ext = path.Ext(u.Path)
+	}
+	f, err := ioutil.TempFile("", "youtubeuploader-*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("error downloading %s: %s", source, err)
+	}
+	fmt.Fprintf(output, "Downloading '%s' to upload to each profile...\n", source)
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error downloading %s: %s", source, err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,150 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// notifyRetries is the number of times a failed webhook request is retried
+const notifyRetries = 3
+
+// notifyBody is the parsed -notifyTemplate, or nil to send notifyPayload as
+// JSON
+var notifyBody *template.Template
+
+// notifyPayload is sent to -notifyURL when each upload finishes. It is also
+// the data for -notifyTemplate.
+type notifyPayload struct {
+	File        string  `json:"file"`
+	VideoID     string  `json:"videoId,omitempty"`
+	URL         string  `json:"url,omitempty"`
+	Title       string  `json:"title,omitempty"`
+	FileSize    int64   `json:"fileSize"`
+	Duration    float64 `json:"durationSeconds"`
+	AverageRate float64 `json:"averageBytesPerSecond"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// parseNotifyTemplate parses -notifyTemplate, so that errors are reported
+// before any upload starts
+func parseNotifyTemplate() error {
+	if *notifyTmpl == "" {
+		return nil
+	}
+	if *notifyURL == "" {
+		return fmt.Errorf("-notifyTemplate requires -notifyURL")
+	}
+	funcs := template.FuncMap{
+		// json quotes a value, so that it can be placed in a JSON body
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+	t, err := template.New("notifyTemplate").Funcs(funcs).Option("missingkey=error").Parse(*notifyTmpl)
+	if err != nil {
+		return fmt.Errorf("invalid template in -notifyTemplate: %s", err)
+	}
+	notifyBody = t
+	return nil
+}
+
+// notifyUpload posts the outcome of the upload of file to -notifyURL, if it
+// is set. Failures are only warned about, as they don't affect the upload.
+func notifyUpload(ctx context.Context, file string, result *uploadResult, uploadErr error) {
+	if *notifyURL == "" {
+		return
+	}
+	payload := newNotifyPayload(file, result, uploadErr)
+
+	var body bytes.Buffer
+	var err error
+	if notifyBody != nil {
+		err = notifyBody.Execute(&body, payload)
+	} else {
+		err = json.NewEncoder(&body).Encode(payload)
+	}
+	if err != nil {
+		warningsOf(ctx).warnf("Error building -notifyURL request for '%s': %v", file, err)
+		return
+	}
+
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := postNotification(body.Bytes())
+		if err == nil {
+			return
+		}
+		if !retry || attempt == notifyRetries {
+			warningsOf(ctx).warnf("Error notifying -notifyURL of '%s': %v", file, err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// newNotifyPayload summarises the outcome of the upload of file
+func newNotifyPayload(file string, result *uploadResult, uploadErr error) notifyPayload {
+	payload := notifyPayload{
+		File:        file,
+		VideoID:     result.VideoID,
+		URL:         result.URL,
+		Title:       result.Title,
+		FileSize:    result.FileSize,
+		Duration:    result.Duration,
+		AverageRate: result.AverageRate,
+	}
+	if uploadErr != nil {
+		payload.Error = uploadErr.Error()
+	}
+	return payload
+}
+
+// postNotification sends body to -notifyURL, reporting whether a failure is
+// worth retrying
+func postNotification(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", *notifyURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "youtubeuploader/"+appVersion)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
@@ -15,22 +15,27 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
+	"google.golang.org/api/youtube/v3"
 )
 
 const missingClientSecretsMessage = `
@@ -49,10 +54,22 @@ https://developers.google.com/api-client-library/python/guide/aaa_client_secrets
 `
 
 var (
-	clientSecretsFile = flag.String("secrets", "client_secrets.json", "Client Secrets configuration")
-	cache             = flag.String("cache", "request.token", "Token cache file")
+	clientSecretsFile = flag.String("secrets", "", "Client Secrets configuration. Defaults to client_secrets.json in the config directory e.g. ~/.config/youtubeuploader, then in the current directory")
+	cache             = flag.String("cache", "", "Token cache file. Defaults to request.token in the config directory e.g. ~/.config/youtubeuploader")
+	profile           = flag.String("profile", "", "Name of the account profile whose token and client secrets are used, kept in the config directory e.g. ~/.config/youtubeuploader/tokens/<profile>.json")
+	showProfiles      = flag.Bool("listProfiles", false, "List the profiles that have a cached token and exit")
+	reauth            = flag.Bool("reauth", false, "Ignore the cached token and authorise again")
+	serviceAcct       = flag.String("serviceAccountFile", "", "Service account JSON key to authorise with instead of OAuth client secrets, e.g. with -impersonate for domain-wide delegation")
+	impersonate       = flag.String("impersonate", "", "Email address of the Workspace user whose channel a -serviceAccountFile account uploads to")
+	useADC            = flag.Bool("useADC", false, "Authorise with the Application Default Credentials: GOOGLE_APPLICATION_CREDENTIALS or those from 'gcloud auth application-default login'")
+	authDevice        = flag.Bool("authDevice", false, "Authorise with a code entered on another device, for machines without a browser. Requires client secrets for a 'TVs and Limited Input devices' application type")
 )
 
+func init() {
+	flag.StringVar(clientSecretsFile, "secretsFile", "", "Same as -secrets")
+	flag.StringVar(cache, "tokenCache", "", "Same as -cache")
+}
+
 // CallbackStatus is returned from the oauth2 callback
 type CallbackStatus struct {
 	code  string
@@ -61,8 +78,16 @@ type CallbackStatus struct {
 
 // Cache specifies the methods that implement a Token cache.
 type Cache interface {
-	Token() (*oauth2.Token, error)
-	PutToken(*oauth2.Token) error
+	Token() (*oauth2.Token, []string, error)
+	PutToken(*oauth2.Token, []string) error
+}
+
+// cachedToken is the format of the token cache file. Scopes records the
+// scopes that were granted so that a new consent can be requested when more
+// are needed. Files written by older versions have no scopes.
+type cachedToken struct {
+	*oauth2.Token
+	Scopes []string `json:"scopes,omitempty"`
 }
 
 // CacheFile implements Cache. Its value is the name of the file in which
@@ -103,14 +128,17 @@ func openURL(url string) error {
 	return err
 }
 
-// readConfig reads the configuration from clientSecretsFile.
+// readConfig reads the configuration from the client secrets file.
 // It returns an oauth configuration object for use with the Google API client.
 func readConfig(scopes []string) (*oauth2.Config, error) {
 	// Read the secrets file
-	data, err := ioutil.ReadFile(*clientSecretsFile)
+	secrets := secretsFile()
+	data, err := ioutil.ReadFile(secrets)
 	if err != nil {
-		pwd, _ := os.Getwd()
-		fullPath := filepath.Join(pwd, *clientSecretsFile)
+		fullPath, _ := filepath.Abs(secrets)
+		if dir, err := profileDir(); err == nil && *clientSecretsFile == "" {
+			fullPath = filepath.Join(dir, legacySecretsFile) + "\n\nor:\n\n   " + fullPath
+		}
 		return nil, fmt.Errorf(missingClientSecretsMessage, fullPath)
 	}
 
@@ -144,12 +172,13 @@ func readConfig(scopes []string) (*oauth2.Config, error) {
 	return oCfg, nil
 }
 
-// startWebServer starts a web server that listens on http://localhost:8080.
+// startWebServer starts a web server that listens on the -oAuthPort port,
+// returning the port actually used. Port 0 picks a free port.
 // The webserver waits for an oauth code in the three-legged auth flow.
-func startWebServer() (callbackCh chan CallbackStatus, err error) {
+func startWebServer() (callbackCh chan CallbackStatus, port int, err error) {
 	listener, err := net.Listen("tcp", ":"+strconv.Itoa(*oAuthPort))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	callbackCh = make(chan CallbackStatus)
 	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -166,15 +195,29 @@ func startWebServer() (callbackCh chan CallbackStatus, err error) {
 		}
 	}))
 
-	return callbackCh, nil
+	return callbackCh, listener.Addr().(*net.TCPAddr).Port, nil
 }
 
-// buildOAuthHTTPClient takes the user through the three-legged OAuth flow.
+// redirectURL returns the redirect URI pointing at the local web server on
+// port. The path of the configured redirect URI is kept.
+func redirectURL(configured string, port int) string {
+	path := "/oauth2callback"
+	if u, err := neturl.Parse(configured); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Path != "" {
+		path = u.Path
+	}
+	return "http://" + net.JoinHostPort(*oAuthHost, strconv.Itoa(port)) + path
+}
+
+// buildOAuthTokenSource takes the user through the three-legged OAuth flow.
 // It opens a browser in the native OS or outputs a URL, then blocks until
 // the redirect completes to the /oauth2callback URI.
-// It returns an instance of an HTTP client that can be passed to the
-// constructor of the YouTube client.
-func buildOAuthHTTPClient(ctx context.Context, scopes []string) (*http.Client, error) {
+// It returns a token source from which HTTP clients that can be passed to
+// the constructor of the YouTube client are made by newOAuthClient.
+func buildOAuthTokenSource(ctx context.Context, scopes []string) (oauth2.TokenSource, error) {
+	if *serviceAcct != "" || *useADC {
+		return credentialsTokenSource(ctx, scopes)
+	}
+
 	config, err := readConfig(scopes)
 	if err != nil {
 		msg := fmt.Sprintf("Cannot read configuration file: %v", err)
@@ -184,90 +227,231 @@ func buildOAuthHTTPClient(ctx context.Context, scopes []string) (*http.Client, e
 	// Try to read the token from the cache file.
 	// If an error occurs, do the three-legged OAuth flow because
 	// the token is invalid or doesn't exist.
-	tokenCache := CacheFile(*cache)
-	token, err := tokenCache.Token()
+	cacheFile, err := tokenCacheFile()
+	if err != nil {
+		return nil, err
+	}
+	tokenCache := CacheFile(cacheFile)
+	token, tokenScopes, err := tokenCache.Token()
+	if err == nil && *reauth {
+		err = errors.New("reauthorisation requested")
+	} else if err == nil && !hasScopes(tokenScopes, scopes) {
+		fmt.Fprintln(promptOutput, "Cached token is missing required scopes, requesting new authorisation")
+		err = errors.New("missing scopes")
+		// keep the scopes already granted, so that e.g. authorising to list
+		// playlists doesn't lose the scope needed to upload
+		if tokenScopes == nil {
+			tokenScopes = defaultScopes
+		}
+		scopes = mergeScopes(tokenScopes, scopes)
+		config.Scopes = scopes
+	}
 	if err != nil {
+		if *authDevice {
+			token, err = deviceToken(ctx, config, scopes)
+		} else {
+			token, err = browserToken(ctx, config)
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = tokenCache.PutToken(token, scopes)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		// You must always provide a non-zero string and validate that it matches
-		// the state query parameter on your redirect callback
-		randState := fmt.Sprintf("st%d", time.Now().UnixNano())
-
-		callbackCh := make(chan CallbackStatus)
-		if !*headlessAuth {
-			// Start web server.
-			// This is how this program receives the authorization code
-			// when the browser redirects.
-			callbackCh, err = startWebServer()
-			if err != nil {
-				return nil, err
-			}
+	return newRefreshingTokenSource(token, refreshFunc(ctx, config, token)), nil
+}
+
+// refreshFunc returns a function that gets a new access token with the
+// refresh token of token, keeping any new refresh token it is given
+func refreshFunc(ctx context.Context, config *oauth2.Config, token *oauth2.Token) func() (*oauth2.Token, error) {
+	refreshToken := token.RefreshToken
+	return func() (*oauth2.Token, error) {
+		t, err := config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+		if err != nil {
+			return nil, err
 		}
+		refreshToken = t.RefreshToken
+		return t, nil
+	}
+}
 
-		url := config.AuthCodeURL(randState, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+// browserToken does the three-legged OAuth flow: the user authorises the
+// application in their browser, which is redirected back to a local web
+// server or, with -headlessAuth, to a localhost URL to paste back.
+func browserToken(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	// You must always provide a non-zero string and validate that it matches
+	// the state query parameter on your redirect callback
+	randState := fmt.Sprintf("st%d", time.Now().UnixNano())
 
-		var cbs CallbackStatus
+	var err error
+	callbackCh := make(chan CallbackStatus)
+	if !*headlessAuth {
+		// Start web server.
+		// This is how this program receives the authorization code
+		// when the browser redirects.
+		var port int
+		callbackCh, port, err = startWebServer()
+		if err != nil {
+			return nil, err
+		}
+		config.RedirectURL = redirectURL(config.RedirectURL, port)
+	} else if !strings.HasPrefix(config.RedirectURL, "http") {
+		// Google has shut down the out-of-band flow, which showed the code
+		// in the browser. The code is read from the URL of the redirect to
+		// localhost instead, which fails to load.
+		config.RedirectURL = "http://localhost"
+	}
 
-		if *headlessAuth {
-			fmt.Printf("Visit the URL for the auth dialog: %v\n", url)
+	url := config.AuthCodeURL(randState, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
 
-			fmt.Printf("Enter authorisation code here: ")
-			// FIXME: how to check state?
-			cbs.state = randState
-			if _, err := fmt.Scanln(&cbs.code); err != nil {
-				return nil, err
-			}
+	var cbs CallbackStatus
+
+	if *headlessAuth {
+		fmt.Fprintf(promptOutput, "Visit the URL for the auth dialog: %v\n", url)
+
+		fmt.Fprintln(promptOutput, "After authorising, the browser is redirected to a http://localhost URL that fails to load.")
+		fmt.Fprintf(promptOutput, "Enter the URL you were redirected to, or the code in it: ")
+		cbs, err = readAuthCode(os.Stdin, randState)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = openURL(url)
+		if err != nil {
+			fmt.Fprintln(promptOutput, "Visit the URL below to get a code.",
+				" This program will pause until the site is visted.")
+			fmt.Fprintln(promptOutput, url)
 		} else {
-			err = openURL(url)
-			if err != nil {
-				fmt.Println("Visit the URL below to get a code.",
-					" This program will pause until the site is visted.")
-			} else {
-				fmt.Println("Your browser has been opened to an authorization URL.",
-					" This program will resume once authorization has been provided.")
-			}
+			fmt.Fprintln(promptOutput, "Your browser has been opened to an authorization URL.",
+				" This program will resume once authorization has been provided.")
+		}
+
+		// Wait for the web server to get the code.
+		cbs = <-callbackCh
+	}
 
-			// Wait for the web server to get the code.
-			cbs = <-callbackCh
+	if cbs.state != randState {
+		return nil, fmt.Errorf("expecting state '%s', received state '%s'", randState, cbs.state)
+	}
+
+	token, err := config.Exchange(ctx, cbs.code)
+	if err != nil {
+		if strings.Contains(err.Error(), "redirect_uri_mismatch") {
+			return nil, fmt.Errorf("%s\nAdd '%s' to the authorized redirect URIs of the OAuth client in the Google Cloud Console", err, config.RedirectURL)
 		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// newOAuthClient returns an HTTP client that authorises its requests with
+// tokens from ts, sending them with base
+func newOAuthClient(ts oauth2.TokenSource, base http.RoundTripper) *http.Client {
+	// the token is consulted for each request, including each chunk of an
+	// upload, and a request rejected with 401 is sent again with a new one
+	if rts, ok := ts.(*refreshingTokenSource); ok {
+		base = &reauthTransport{source: rts, base: base}
+	}
+	return &http.Client{
+		Transport: &quotaTransport{&oauth2.Transport{Source: ts, Base: base}},
+	}
+}
 
-		if cbs.state != randState {
-			return nil, fmt.Errorf("expecting state '%s', received state '%s'", randState, cbs.state)
+// broaderScopes lists the scopes that include each scope, so that e.g. a
+// token granted the full youtube scope can be used for read-only commands
+var broaderScopes = map[string][]string{
+	youtube.YoutubeReadonlyScope: {youtube.YoutubeScope, youtube.YoutubeForceSslScope},
+}
+
+// hasScopes reports whether every scope in wanted, or a broader one, is
+// present in granted. The default scopes are assumed to be granted when no
+// scopes were recorded.
+func hasScopes(granted, wanted []string) bool {
+	if granted == nil {
+		granted = defaultScopes
+	}
+	for _, w := range wanted {
+		found := false
+		for _, g := range granted {
+			if g == w || hasScope(broaderScopes[w], g) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
 		}
+	}
+	return true
+}
 
-		token, err = config.Exchange(oauth2.NoContext, cbs.code)
-		if err != nil {
-			return nil, err
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
 		}
-		err = tokenCache.PutToken(token)
-		if err != nil {
-			return nil, err
+	}
+	return false
+}
+
+// mergeScopes returns the scopes in a followed by those in b that aren't in a
+func mergeScopes(a, b []string) []string {
+	merged := append([]string{}, a...)
+	for _, s := range b {
+		if !hasScope(merged, s) {
+			merged = append(merged, s)
 		}
 	}
+	return merged
+}
 
-	return config.Client(ctx, token), nil
+// readAuthCode reads the authorisation code pasted by the user in the
+// headless flow. Surrounding whitespace is ignored. The full redirect URL may
+// be pasted instead, in which case the code and state are taken from it.
+func readAuthCode(r io.Reader, state string) (CallbackStatus, error) {
+	cbs := CallbackStatus{state: state}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return cbs, fmt.Errorf("error reading authorisation code: %s", err)
+	}
+	cbs.code = strings.TrimSpace(line)
+
+	if u, err := neturl.Parse(cbs.code); err == nil && u.Query().Get("code") != "" {
+		cbs.code = u.Query().Get("code")
+		cbs.state = u.Query().Get("state")
+	}
+	if cbs.code == "" {
+		return cbs, errors.New("no authorisation code entered")
+	}
+	return cbs, nil
 }
 
-// Token retreives the token from the token cache
-func (f CacheFile) Token() (*oauth2.Token, error) {
+// Token retreives the token and its scopes from the token cache
+func (f CacheFile) Token() (*oauth2.Token, []string, error) {
 	file, err := os.Open(string(f))
 	if err != nil {
-		return nil, fmt.Errorf("CacheFile.Token: %s", err.Error())
+		return nil, nil, fmt.Errorf("CacheFile.Token: %s", err.Error())
 	}
 	defer file.Close()
-	tok := &oauth2.Token{}
-	if err := json.NewDecoder(file).Decode(tok); err != nil {
-		return nil, fmt.Errorf("CacheFile.Token: %s", err.Error())
+	tok := cachedToken{Token: &oauth2.Token{}}
+	if err := json.NewDecoder(file).Decode(&tok); err != nil {
+		return nil, nil, fmt.Errorf("CacheFile.Token: %s", err.Error())
 	}
-	return tok, nil
+	return tok.Token, tok.Scopes, nil
 }
 
-// PutToken stores the token in the token cache
-func (f CacheFile) PutToken(tok *oauth2.Token) error {
+// PutToken stores the token and its scopes in the token cache
+func (f CacheFile) PutToken(tok *oauth2.Token, scopes []string) error {
 	file, err := os.OpenFile(string(f), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("CacheFile.PutToken: %s", err.Error())
 	}
-	if err := json.NewEncoder(file).Encode(tok); err != nil {
+	// an existing file keeps its permissions when truncated
+	file.Chmod(0600)
+	if err := json.NewEncoder(file).Encode(cachedToken{Token: tok, Scopes: scopes}); err != nil {
 		file.Close()
 		return fmt.Errorf("CacheFile.PutToken: %s", err.Error())
 	}
@@ -0,0 +1,304 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+)
+
+// error codes reported in JSON output mode
+const (
+	errCodeUsage      = "usage"
+	errCodeAuth       = "auth"
+	errCodeSource     = "source"
+	errCodeUpload     = "upload"
+	errCodeThumbnail  = "thumbnail"
+	errCodeCaption    = "caption"
+	errCodePlaylist   = "playlist"
+	errCodeProcessing = "processing"
+	errCodeHook       = "hook"
+	errCodeTimeout    = "timeout"
+	errCodeQuota      = "quota"
+	errCodeLocked     = "locked"
+)
+
+// exit codes for each error code. Other errors exit with 1.
+var exitCodes = map[string]int{
+	errCodeUsage:      2,
+	errCodeAuth:       3,
+	errCodeQuota:      4,
+	errCodeUpload:     5,
+	errCodeSource:     6,
+	errCodeThumbnail:  7,
+	errCodeCaption:    7,
+	errCodePlaylist:   7,
+	errCodeProcessing: 7,
+	errCodeHook:       7,
+	errCodeTimeout:    8,
+	errCodeLocked:     9,
+}
+
+// quotaReasons are the API error reasons that mean a quota or limit has been
+// reached, so that retrying later may succeed
+var quotaReasons = map[string]bool{
+	"quotaExceeded":         true,
+	"dailyLimitExceeded":    true,
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"uploadLimitExceeded":   true,
+}
+
+// reportedError is returned when the errors have already been reported. Its
+// code determines the exit code.
+type reportedError struct {
+	code string
+}
+
+func (e reportedError) Error() string {
+	return "errors reported"
+}
+
+var (
+	// output receives human readable messages. It is discarded in JSON
+	// output mode.
+	output io.Writer = os.Stdout
+
+	// promptOutput receives messages the user must see, such as the OAuth
+	// authorisation URL. It is stderr in JSON output mode.
+	promptOutput io.Writer = os.Stdout
+
+	// runWarnings collects the warnings that aren't about an upload, to be
+	// included in the JSON output
+	runWarnings warningList
+)
+
+// uploadResult is the summary of an upload emitted in JSON output mode
+type uploadResult struct {
+	File          string   `json:"file"`
+	Profile       string   `json:"profile,omitempty"`
+	VideoID       string   `json:"videoId,omitempty"`
+	URL           string   `json:"url,omitempty"`
+	ShortURL      string   `json:"shortUrl,omitempty"`
+	StudioURL     string   `json:"studioUrl,omitempty"`
+	Title         string   `json:"title,omitempty"`
+	PrivacyStatus string   `json:"privacyStatus,omitempty"`
+	FileSize      int64    `json:"fileSize"`
+	SHA256        string   `json:"sha256,omitempty"`
+	Checksum      string   `json:"checksum,omitempty"`
+	Duration      float64  `json:"durationSeconds"`
+	AverageRate   float64  `json:"averageBytesPerSecond"`
+	Warnings      []string `json:"warnings,omitempty"`
+	Sidecars      []string `json:"sidecars,omitempty"`
+	// AfterUpload is what -afterUpload did with the file, e.g. "deleted"
+	AfterUpload string `json:"afterUpload,omitempty"`
+	// SourceReconnects is the number of times a URL source was reconnected
+	SourceReconnects int            `json:"sourceReconnects,omitempty"`
+	Stats            *transferStats `json:"stats,omitempty"`
+	// Timing is the timing of each request with -timing
+	Timing *timingReport `json:"timing,omitempty"`
+	// Deleted is set when -deleteOnProcessingFailure deleted the video
+	Deleted bool `json:"deleted,omitempty"`
+	// Skipped is set when -skipDuplicates found the file was uploaded before
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// jsonError is emitted on stderr in JSON output mode when something fails
+type jsonError struct {
+	File    string   `json:"file,omitempty"`
+	VideoID string   `json:"videoId,omitempty"`
+	Code    string   `json:"code"`
+	Error   string   `json:"error"`
+	Reasons []string `json:"reasons,omitempty"`
+	Hint    string   `json:"hint,omitempty"`
+}
+
+// codedError associates an error with a code for JSON output mode
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode associates err with code. Errors from the API caused by a quota
+// being exceeded are given the quota code instead.
+func withCode(code string, err error) error {
+	if isQuotaError(err) {
+		code = errCodeQuota
+	}
+	return codedError{code: code, err: err}
+}
+
+// errorCode returns the code of err, if it has one
+func errorCode(err error) string {
+	var ce codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	var re reportedError
+	if errors.As(err, &re) {
+		return re.code
+	}
+	return "error"
+}
+
+// exitCode returns the process exit code for err
+func exitCode(err error) int {
+	if code, ok := exitCodes[errorCode(err)]; ok {
+		return code
+	}
+	return 1
+}
+
+// isQuotaError reports whether err is an API error caused by a quota or
+// limit
+func isQuotaError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if quotaReasons[item.Reason] {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonOutput() bool {
+	return *outputFormat == "json"
+}
+
+// warningList collects warnings for the JSON output. Each upload has its
+// own, so that the warnings of uploads running at the same time don't end up
+// in each other's results.
+type warningList struct {
+	mu   sync.Mutex
+	list []string
+}
+
+// warnf prints a warning and records it in w, or with the run's warnings if
+// w is nil
+func (w *warningList) warnf(format string, a ...interface{}) {
+	if w == nil {
+		w = &runWarnings
+	}
+	msg := fmt.Sprintf(format, a...)
+	w.mu.Lock()
+	w.list = append(w.list, msg)
+	w.mu.Unlock()
+	fmt.Fprintln(output, colorize(output, styleWarning, msg))
+}
+
+// take returns the warnings recorded in w since it was last called
+func (w *warningList) take() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := w.list
+	w.list = nil
+	return list
+}
+
+// warnf prints a warning that isn't about a particular upload and records it
+// for the JSON output
+func warnf(format string, a ...interface{}) {
+	runWarnings.warnf(format, a...)
+}
+
+// takeWarnings returns the warnings recorded by warnf since it was last
+// called
+func takeWarnings() []string {
+	return runWarnings.take()
+}
+
+type warningsKey struct{}
+
+// withWarnings returns a context for an upload whose warnings are recorded
+// in the returned list
+func withWarnings(ctx context.Context) (context.Context, *warningList) {
+	w := &warningList{}
+	return context.WithValue(ctx, warningsKey{}, w), w
+}
+
+// warningsOf returns the warnings of the upload of ctx, which is nil, and so
+// the run's warnings, outside of an upload
+func warningsOf(ctx context.Context) *warningList {
+	w, _ := ctx.Value(warningsKey{}).(*warningList)
+	return w
+}
+
+// writeResult emits an upload result on stdout in JSON output mode
+func writeResult(result *uploadResult) {
+	if !jsonOutput() {
+		return
+	}
+	json.NewEncoder(os.Stdout).Encode(result)
+}
+
+// reportError prints an error, as JSON on stderr in JSON output mode
+func reportError(file, videoID string, err error) {
+	if jsonOutput() {
+		json.NewEncoder(os.Stderr).Encode(jsonError{
+			File:    file,
+			VideoID: videoID,
+			Code:    errorCode(err),
+			Error:   err.Error(),
+			Reasons: apiErrorReasons(err),
+			Hint:    apiErrorHint(err),
+		})
+		return
+	}
+	if hint := apiErrorHint(err); hint != "" {
+		defer log.Printf("Youtube says %s", hint)
+	}
+	var msg string
+	switch {
+	case file == "":
+		msg = err.Error()
+	case videoID != "":
+		msg = fmt.Sprintf("'%s': video ID %s: %v", file, videoID, err)
+	default:
+		msg = fmt.Sprintf("'%s': %v", file, err)
+	}
+	log.Print(colorize(log.Writer(), styleError, msg))
+}
+
+func setOutputFormat() error {
+	switch *outputFormat {
+	case "text":
+	case "json":
+		output = ioutil.Discard
+		promptOutput = os.Stderr
+	default:
+		return fmt.Errorf("invalid value for -out '%s', must be 'text' or 'json'", *outputFormat)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestWarningsPerUpload(t *testing.T) {
+	oldOutput := output
+	output = ioutil.Discard
+	defer func() { output = oldOutput }()
+	takeWarnings()
+
+	// uploads running at the same time each get only their own warnings
+	const uploads, each = 4, 50
+	lists := make([]*warningList, uploads)
+	var wg sync.WaitGroup
+	for i := range lists {
+		var ctx context.Context
+		ctx, lists[i] = withWarnings(context.Background())
+		worker := &uploadWorker{}
+		worker.setWarnings(warningsOf(ctx))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				if j%2 == 0 {
+					warningsOf(ctx).warnf("upload %d", i)
+				} else {
+					worker.warnf("upload %d", i)
+				}
+			}
+		}(i)
+	}
+	// and warnings outside of an upload are the run's
+	warningsOf(context.Background()).warnf("run")
+	warnf("run")
+	wg.Wait()
+
+	for i, list := range lists {
+		got := list.take()
+		if len(got) != each {
+			t.Errorf("upload %d: got %d warnings, want %d", i, len(got), each)
+		}
+		for _, w := range got {
+			if w != fmt.Sprintf("upload %d", i) {
+				t.Errorf("upload %d: got warning %q", i, w)
+			}
+		}
+		if again := list.take(); len(again) != 0 {
+			t.Errorf("upload %d: warnings taken twice: %q", i, again)
+		}
+	}
+	if got := takeWarnings(); len(got) != 2 || got[0] != "run" || got[1] != "run" {
+		t.Errorf("got run warnings %q", got)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+// uploadPause stops all uploads reading from their source while it is
+// paused. It is paused and resumed by SIGUSR1 and SIGUSR2.
+var uploadPause uploader.Pause
+
+// pauseUploads pauses the uploads of workers, printing how far each has got
+func pauseUploads(workers []*uploadWorker) {
+	if !uploadPause.Pause() {
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+	for _, w := range workers {
+		if filename, _ := w.current(); filename != "" {
+			fmt.Fprintf(output, "\n%s: '%s' paused at %d bytes\n", now, filename, w.progress().Sent)
+		}
+	}
+	fmt.Fprintf(output, "Send SIGUSR2 to resume\n")
+}
+
+// resumeUploads resumes paused uploads
+func resumeUploads() {
+	if uploadPause.Resume() {
+		fmt.Fprintf(output, "\n%s: resumed\n", time.Now().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchPauseSignals pauses the uploads of workers on SIGUSR1 and resumes
+// them on SIGUSR2. The returned function stops watching, resuming the
+// uploads if they are paused.
+func watchPauseSignals(workers []*uploadWorker) func() {
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGUSR1, syscall.SIGUSR2)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigChan:
+				if sig == syscall.SIGUSR1 {
+					pauseUploads(workers)
+				} else {
+					resumeUploads()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+		uploadPause.Resume()
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"google.golang.org/api/youtube/v3"
+)
+
+// playlistCacheFile is the name of the cache of playlist IDs by title in the
+// config directory
+const playlistCacheFile = "playlists.json"
+
+// playlistCache maps playlist titles to their IDs, to save listing the
+// channel's playlists on every upload. Keys are the profile and the lower
+// case title, as titles are matched case-insensitively.
+var playlistCache struct {
+	sync.Mutex
+	loaded bool
+	ids    map[string]string
+}
+
+func playlistCacheKey(title string) string {
+	return *profile + "/" + strings.ToLower(title)
+}
+
+// cachedPlaylist returns the cached ID of the playlist titled title, or ""
+func cachedPlaylist(title string) string {
+	playlistCache.Lock()
+	defer playlistCache.Unlock()
+	if !playlistCache.loaded {
+		playlistCache.loaded = true
+		playlistCache.ids = make(map[string]string)
+		if dir, err := profileDir(); err == nil {
+			// a missing or corrupt cache only costs quota
+			if data, err := ioutil.ReadFile(filepath.Join(dir, playlistCacheFile)); err == nil {
+				json.Unmarshal(data, &playlistCache.ids)
+			}
+		}
+	}
+	return playlistCache.ids[playlistCacheKey(title)]
+}
+
+// cachePlaylist records the ID of the playlist titled title, or forgets it
+// if id is "". Failures are ignored, as the cache only saves quota.
+func cachePlaylist(title, id string) {
+	playlistCache.Lock()
+	defer playlistCache.Unlock()
+	if playlistCache.ids == nil {
+		return
+	}
+	if id == "" {
+		delete(playlistCache.ids, playlistCacheKey(title))
+	} else {
+		playlistCache.ids[playlistCacheKey(title)] = id
+	}
+	dir, err := profileDir()
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(playlistCache.ids, "", "  ")
+	if err != nil || os.MkdirAll(dir, 0700) != nil {
+		return
+	}
+	path := filepath.Join(dir, playlistCacheFile)
+	if ioutil.WriteFile(path+".tmp", data, 0600) == nil {
+		os.Rename(path+".tmp", path)
+	}
+}
+
+// findPlaylist returns the ID of the channel's playlist titled title, matched
+// case-insensitively, or "" if there is none. More than one playlist with the
+// title is an error listing their IDs.
+func findPlaylist(service *youtube.Service, title string) (string, error) {
+	if id := cachedPlaylist(title); id != "" {
+		return id, nil
+	}
+
+	var ids []string
+	call := service.Playlists.List([]string{"snippet"}).Mine(true).MaxResults(50)
+	for {
+		response, err := call.Do()
+		if err != nil {
+			return "", fmt.Errorf("error retrieving playlists: %w", err)
+		}
+		for _, pl := range response.Items {
+			if pl.Snippet != nil && strings.EqualFold(pl.Snippet.Title, title) {
+				ids = append(ids, pl.Id)
+			}
+		}
+		if response.NextPageToken == "" {
+			break
+		}
+		call = call.PageToken(response.NextPageToken)
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", nil
+	case 1:
+		cachePlaylist(title, ids[0])
+		return ids[0], nil
+	}
+	return "", fmt.Errorf("%d playlists are titled '%s': %s. Use -playlistID to choose one", len(ids), title, strings.Join(ids, ", "))
+}
+
+// insertPlaylist creates a playlist titled title with the privacy status
+func insertPlaylist(service *youtube.Service, title, privacyStatus string) (string, error) {
+	playlist := &youtube.Playlist{
+		Snippet: &youtube.PlaylistSnippet{Title: title},
+		Status:  &youtube.PlaylistStatus{PrivacyStatus: privacyStatus},
+	}
+	playlist, err := service.Playlists.Insert([]string{"snippet", "status"}, playlist).Do()
+	if err != nil {
+		return "", fmt.Errorf("error creating playlist with title '%s': %w", title, err)
+	}
+	fmt.Fprintf(output, "Created playlist '%s' (%s)\n", title, playlist.Id)
+	cachePlaylist(title, playlist.Id)
+	return playlist.Id, nil
+}
+
+// AddVideoToPlaylist adds the video to the playlist with plx's ID or, if it
+// has none, the one with its title, which is created if plx.Create is set
+func (plx *Playlistx) AddVideoToPlaylist(ctx context.Context, u *uploader.Uploader, videoID string) error {
+	service := u.Service()
+	id := plx.Id
+	cached := false
+	if id == "" {
+		var err error
+		cached = cachedPlaylist(plx.Title) != ""
+		id, err = findPlaylist(service, plx.Title)
+		if err != nil {
+			return err
+		}
+		if id == "" {
+			if !plx.Create {
+				return fmt.Errorf("there is no playlist titled '%s'. Use -createPlaylist to create it", plx.Title)
+			}
+			id, err = insertPlaylist(service, plx.Title, plx.PrivacyStatus)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := u.AddToPlaylist(ctx, id, videoID)
+	if errors.Is(err, uploader.ErrPlaylistNotFound) {
+		if cached {
+			// the cached playlist has been deleted, so look it up again
+			cachePlaylist(plx.Title, "")
+			return plx.AddVideoToPlaylist(ctx, u, videoID)
+		}
+		return fmt.Errorf("playlist ID '%s' doesn't exist", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if plx.Title != "" {
+		fmt.Fprintf(output, "Video added to playlist '%s' (%s)\n", plx.Title, id)
+	} else {
+		fmt.Fprintf(output, "Video added to playlist %s\n", id)
+	}
+	return nil
+}
+
+// playlistInfo is a playlist in the -listPlaylists JSON output
+type playlistInfo struct {
+	ID            string `json:"id"`
+	Title         string `json:"title"`
+	PrivacyStatus string `json:"privacyStatus"`
+	ItemCount     int64  `json:"itemCount"`
+}
+
+// printPlaylists prints the channel's playlists for -listPlaylists, as a
+// table or in JSON output mode a JSON array
+func printPlaylists() error {
+	service, err := newService(readOnlyScopes)
+	if err != nil {
+		return err
+	}
+
+	infos := []playlistInfo{}
+	call := service.Playlists.List([]string{"snippet", "status", "contentDetails"}).Mine(true).MaxResults(50)
+	for {
+		response, err := call.Do()
+		if err != nil {
+			return fmt.Errorf("error retrieving playlists: %w", err)
+		}
+		for _, pl := range response.Items {
+			info := playlistInfo{ID: pl.Id}
+			if pl.Snippet != nil {
+				info.Title = pl.Snippet.Title
+			}
+			if pl.Status != nil {
+				info.PrivacyStatus = pl.Status.PrivacyStatus
+			}
+			if pl.ContentDetails != nil {
+				info.ItemCount = pl.ContentDetails.ItemCount
+			}
+			infos = append(infos, info)
+		}
+		if response.NextPageToken == "" {
+			break
+		}
+		call = call.PageToken(response.NextPageToken)
+	}
+
+	if jsonOutput() {
+		return json.NewEncoder(os.Stdout).Encode(infos)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPRIVACY\tITEMS\tTITLE")
+	for _, pl := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", pl.ID, pl.PrivacyStatus, pl.ItemCount, pl.Title)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,106 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+// premiereTime is the time given by -premiere, zero if it isn't set
+var premiereTime time.Time
+
+// validatePremiere checks the value of -premiere, which must be in the
+// future
+func validatePremiere() error {
+	if *premiere == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, *premiere)
+	if err != nil {
+		return fmt.Errorf("invalid value for -premiere '%s', must be an RFC3339 time e.g. 2024-06-01T18:00:00+01:00", *premiere)
+	}
+	if !t.After(time.Now()) {
+		return fmt.Errorf("-premiere %s is in the past", t.Local())
+	}
+	premiereTime = t
+	return nil
+}
+
+// applyPremiere makes video private and scheduled to be published at the
+// -premiere time, which wins over the privacy and publishAt of the flags and
+// meta JSON
+func applyPremiere(video *youtube.Video) {
+	if premiereTime.IsZero() {
+		return
+	}
+	if isFlagSet("privacy") && normalizeEnum(*privacy, privacyStatuses) != "private" {
+		warnf("-premiere needs the video to be private until it is published. Ignoring -privacy %s...", *privacy)
+	}
+	video.Status.PrivacyStatus = "private"
+	video.Status.PublishAt = premiereTime.UTC().Format(ytDateLayout)
+}
+
+// reportPremiere prints when video, uploaded with -premiere, will go public
+// and where to watch it. The Data API can schedule a video to be published,
+// but not turn an upload into a Premiere, so unless Youtube says it is one
+// (liveBroadcastContent 'upcoming') that is explained rather than left to be
+// found out when the video goes public.
+func reportPremiere(ctx context.Context, w io.Writer, video *youtube.Video) {
+	if premiereTime.IsZero() {
+		return
+	}
+	if video.Snippet != nil && video.Snippet.LiveBroadcastContent == "upcoming" {
+		fmt.Fprintf(w, "Premiere at %s: %s\n", premiereTime.Local().Format(time.RFC1123), watchURL(video.Id))
+		return
+	}
+	fmt.Fprintf(w, "Scheduled to be published at %s: %s\n", premiereTime.Local().Format(time.RFC1123), watchURL(video.Id))
+	warningsOf(ctx).warnf("The Youtube API can't make an uploaded video a Premiere, so it will be published as a normal video at that time. To premiere it instead, choose Premiere under Visibility in Studio: %s", studioURL(video.Id))
+}
+
+// premiereError is the API refusing to schedule a video for -premiere
+type premiereError struct {
+	err error
+}
+
+func (e premiereError) Error() string {
+	return fmt.Sprintf("the video couldn't be scheduled for -premiere %s: %v", premiereTime.Local(), e.err)
+}
+
+func (e premiereError) Unwrap() error { return e.err }
+
+// checkPremiereError returns a premiereError for err if -premiere is set and
+// the API rejected the publish time, or forbade scheduling it, which it does
+// for channels that aren't verified
+func checkPremiereError(err error) error {
+	var apiErr *googleapi.Error
+	if premiereTime.IsZero() || !errors.As(err, &apiErr) ||
+		(apiErr.Code != http.StatusBadRequest && apiErr.Code != http.StatusForbidden) {
+		return err
+	}
+	for _, reason := range apiErrorReasons(err) {
+		if reason == "invalidPublishAt" || reason == "forbidden" {
+			return premiereError{err}
+		}
+	}
+	return err
+}
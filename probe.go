@@ -0,0 +1,385 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// errUnknownContainer is returned by probeVideo for a file that isn't MP4,
+// MOV or Matroska
+var errUnknownContainer = errors.New("only MP4, MOV, Matroska and WebM files can be probed")
+
+// videoInfo is what probeVideo reads from the headers of a video file
+type videoInfo struct {
+	Duration time.Duration
+	// Width and Height are the displayed size of the first video track,
+	// after any rotation
+	Width, Height int
+}
+
+// probeVideo reads the duration and picture size of the video in r, of size
+// bytes, from the headers of its container, without decoding it
+func probeVideo(r io.ReaderAt, size int64) (videoInfo, error) {
+	header := make([]byte, sniffLen)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return videoInfo{}, err
+	}
+	switch videoContainer(header[:n]) {
+	case "MP4", "MOV":
+		return probeMP4(r, size)
+	case "Matroska", "WebM":
+		return probeMatroska(r, size)
+	}
+	return videoInfo{}, errUnknownContainer
+}
+
+// mp4Box is the position of an ISO base media file format box, or QuickTime
+// atom: its type, and the offset and size of its contents
+type mp4Box struct {
+	typ          string
+	offset, size int64
+}
+
+// mp4Boxes lists the boxes between start and end
+func mp4Boxes(r io.ReaderAt, start, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	buf := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		if _, err := r.ReadAt(buf[:8], pos); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(buf))
+		typ := string(buf[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0:
+			// the box runs to the end of the file
+			size = end - pos
+		case 1:
+			// a 64-bit size follows the type
+			if _, err := r.ReadAt(buf[8:16], pos+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(buf[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen || pos+size > end {
+			return nil, fmt.Errorf("box '%s' at %d has an invalid size", typ, pos)
+		}
+		boxes = append(boxes, mp4Box{typ, pos + headerLen, size - headerLen})
+		pos += size
+	}
+	return boxes, nil
+}
+
+// readBox reads the contents of box, up to limit bytes
+func readBox(r io.ReaderAt, box mp4Box, limit int64) ([]byte, error) {
+	if box.size < limit {
+		limit = box.size
+	}
+	data := make([]byte, limit)
+	_, err := r.ReadAt(data, box.offset)
+	return data, err
+}
+
+func probeMP4(r io.ReaderAt, size int64) (videoInfo, error) {
+	var info videoInfo
+	top, err := mp4Boxes(r, 0, size)
+	if err != nil {
+		return info, err
+	}
+	var moov *mp4Box
+	for i := range top {
+		if top[i].typ == "moov" {
+			moov = &top[i]
+		}
+	}
+	if moov == nil {
+		return info, errors.New("no moov box")
+	}
+	boxes, err := mp4Boxes(r, moov.offset, moov.offset+moov.size)
+	if err != nil {
+		return info, err
+	}
+	for _, box := range boxes {
+		switch box.typ {
+		case "mvhd":
+			data, err := readBox(r, box, 32)
+			if err != nil {
+				return info, err
+			}
+			// version 1 has 64-bit times and duration
+			var timescale uint32
+			var duration uint64
+			if len(data) >= 32 && data[0] == 1 {
+				timescale = binary.BigEndian.Uint32(data[20:24])
+				duration = binary.BigEndian.Uint64(data[24:32])
+			} else if len(data) >= 20 {
+				timescale = binary.BigEndian.Uint32(data[12:16])
+				duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+			}
+			if timescale > 0 {
+				info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+			}
+		case "trak":
+			if info.Width != 0 {
+				continue
+			}
+			trak, err := mp4Boxes(r, box.offset, box.offset+box.size)
+			if err != nil {
+				return info, err
+			}
+			for _, b := range trak {
+				if b.typ != "tkhd" {
+					continue
+				}
+				// a version 1 box, with 64-bit times, is 96 bytes long
+				data, err := readBox(r, b, 96)
+				if err != nil {
+					return info, err
+				}
+				info.Width, info.Height = tkhdSize(data)
+			}
+		}
+	}
+	return info, nil
+}
+
+// tkhdSize returns the picture size given by the contents of a track header
+// box, which is 0x0 for an audio track
+func tkhdSize(data []byte) (int, int) {
+	// the matrix and the 16.16 fixed point size end the box, after 64-bit
+	// times in version 1
+	matrix := 40
+	if len(data) > 0 && data[0] == 1 {
+		matrix = 52
+	}
+	if len(data) < matrix+44 {
+		return 0, 0
+	}
+	width := int(binary.BigEndian.Uint32(data[matrix+36:]) >> 16)
+	height := int(binary.BigEndian.Uint32(data[matrix+40:]) >> 16)
+	// a phone held upright records a landscape picture with a matrix that
+	// rotates it by 90 degrees, leaving a and d of the matrix 0
+	a := int32(binary.BigEndian.Uint32(data[matrix:]))
+	d := int32(binary.BigEndian.Uint32(data[matrix+16:]))
+	if a == 0 && d == 0 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// Matroska element IDs
+const (
+	ebmlSegment          = 0x18538067
+	ebmlInfo             = 0x1549a966
+	ebmlTimecodeScale    = 0x2ad7b1
+	ebmlDuration         = 0x4489
+	ebmlTracks           = 0x1654ae6b
+	ebmlTrackEntry       = 0xae
+	ebmlTrackType        = 0x83
+	ebmlVideo            = 0xe0
+	ebmlPixelWidth       = 0xb0
+	ebmlPixelHeight      = 0xba
+	ebmlDisplayWidth     = 0x54b0
+	ebmlDisplayHeight    = 0x54ba
+	matroskaVideoTrack   = 1
+	defaultTimecodeScale = 1000000
+)
+
+// ebmlElement is the position of a Matroska element: its ID, and the offset
+// and size of its contents
+type ebmlElement struct {
+	id           uint64
+	offset, size int64
+}
+
+// readVint reads an EBML variable length integer at pos, returning it and
+// its length. The marker bit is kept for IDs and removed for sizes.
+func readVint(r io.ReaderAt, pos int64, keepMarker bool) (uint64, int, error) {
+	buf := make([]byte, 8)
+	if _, err := r.ReadAt(buf[:1], pos); err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && buf[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, fmt.Errorf("invalid EBML integer at %d", pos)
+	}
+	if _, err := r.ReadAt(buf[1:length], pos+1); err != nil {
+		return 0, 0, err
+	}
+	value := uint64(buf[0])
+	if !keepMarker {
+		value &= 0xff >> uint(length)
+	}
+	allOnes := value == uint64(0xff>>uint(length))
+	for _, b := range buf[1:length] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xff
+	}
+	if !keepMarker && allOnes {
+		return math.MaxUint64, length, nil
+	}
+	return value, length, nil
+}
+
+// ebmlElements lists the elements between start and end. An element of
+// unknown size, e.g. a live stream's Segment, runs to end.
+func ebmlElements(r io.ReaderAt, start, end int64) ([]ebmlElement, error) {
+	var elements []ebmlElement
+	for pos := start; pos < end; {
+		id, idLen, err := readVint(r, pos, true)
+		if err != nil {
+			return elements, err
+		}
+		size, sizeLen, err := readVint(r, pos+int64(idLen), false)
+		if err != nil {
+			return elements, err
+		}
+		offset := pos + int64(idLen+sizeLen)
+		if size == math.MaxUint64 || offset+int64(size) > end {
+			size = uint64(end - offset)
+		}
+		elements = append(elements, ebmlElement{id, offset, int64(size)})
+		pos = offset + int64(size)
+	}
+	return elements, nil
+}
+
+// ebmlUint reads the contents of an unsigned integer element
+func ebmlUint(r io.ReaderAt, e ebmlElement) uint64 {
+	if e.size > 8 {
+		return 0
+	}
+	buf := make([]byte, e.size)
+	if _, err := r.ReadAt(buf, e.offset); err != nil {
+		return 0
+	}
+	var value uint64
+	for _, b := range buf {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// ebmlFloat reads the contents of a float element
+func ebmlFloat(r io.ReaderAt, e ebmlElement) float64 {
+	// the size comes from the file, so is checked before anything is
+	// allocated for it
+	if e.size != 4 && e.size != 8 {
+		return 0
+	}
+	buf := make([]byte, e.size)
+	if _, err := r.ReadAt(buf, e.offset); err != nil {
+		return 0
+	}
+	if e.size == 4 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf)))
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(buf))
+}
+
+func probeMatroska(r io.ReaderAt, size int64) (videoInfo, error) {
+	var info videoInfo
+	top, err := ebmlElements(r, 0, size)
+	if err != nil && len(top) < 2 {
+		return info, err
+	}
+	for _, segment := range top {
+		if segment.id != ebmlSegment {
+			continue
+		}
+		// the clusters holding the frames are skipped over without being
+		// read, and a truncated file still has its headers
+		children, _ := ebmlElements(r, segment.offset, segment.offset+segment.size)
+		for _, child := range children {
+			switch child.id {
+			case ebmlInfo:
+				scale := uint64(defaultTimecodeScale)
+				var duration float64
+				fields, _ := ebmlElements(r, child.offset, child.offset+child.size)
+				for _, f := range fields {
+					switch f.id {
+					case ebmlTimecodeScale:
+						scale = ebmlUint(r, f)
+					case ebmlDuration:
+						duration = ebmlFloat(r, f)
+					}
+				}
+				info.Duration = time.Duration(duration * float64(scale))
+			case ebmlTracks:
+				if info.Width != 0 {
+					continue
+				}
+				entries, _ := ebmlElements(r, child.offset, child.offset+child.size)
+				for _, entry := range entries {
+					if entry.id == ebmlTrackEntry && info.Width == 0 {
+						info.Width, info.Height = matroskaTrackSize(r, entry)
+					}
+				}
+			}
+		}
+		return info, nil
+	}
+	return info, errors.New("no Segment element")
+}
+
+// matroskaTrackSize returns the displayed size of a video TrackEntry, or 0x0
+// for other tracks
+func matroskaTrackSize(r io.ReaderAt, entry ebmlElement) (int, int) {
+	fields, _ := ebmlElements(r, entry.offset, entry.offset+entry.size)
+	var video *ebmlElement
+	for i, f := range fields {
+		switch f.id {
+		case ebmlTrackType:
+			if ebmlUint(r, f) != matroskaVideoTrack {
+				return 0, 0
+			}
+		case ebmlVideo:
+			video = &fields[i]
+		}
+	}
+	if video == nil {
+		return 0, 0
+	}
+	var width, height, displayWidth, displayHeight int
+	sizes, _ := ebmlElements(r, video.offset, video.offset+video.size)
+	for _, s := range sizes {
+		switch s.id {
+		case ebmlPixelWidth:
+			width = int(ebmlUint(r, s))
+		case ebmlPixelHeight:
+			height = int(ebmlUint(r, s))
+		case ebmlDisplayWidth:
+			displayWidth = int(ebmlUint(r, s))
+		case ebmlDisplayHeight:
+			displayHeight = int(ebmlUint(r, s))
+		}
+	}
+	if displayWidth > 0 && displayHeight > 0 {
+		return displayWidth, displayHeight
+	}
+	return width, height
+}
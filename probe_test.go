@@ -0,0 +1,95 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProbeVideo(t *testing.T) {
+	tests := []struct {
+		file          string
+		duration      time.Duration
+		width, height int
+	}{
+		// version 0 mvhd and tkhd with an identity matrix
+		{"landscape.mp4", 30 * time.Second, 1920, 1080},
+		// float64 duration in a WebM
+		{"landscape.webm", 75 * time.Second, 1280, 720},
+		// float32 duration, a display size that differs from the pixel size,
+		// and a Segment of unknown size
+		{"square.mkv", 20 * time.Second, 1080, 1080},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			info := probeFixture(t, test.file)
+			if info.Duration != test.duration {
+				t.Errorf("duration %s, want %s", info.Duration, test.duration)
+			}
+			if info.Width != test.width || info.Height != test.height {
+				t.Errorf("size %dx%d, want %dx%d", info.Width, info.Height, test.width, test.height)
+			}
+		})
+	}
+}
+
+func TestProbeVideoUnknownContainer(t *testing.T) {
+	data := []byte("RIFF\x00\x00\x00\x00AVI LIST")
+	if _, err := probeVideo(bytes.NewReader(data), int64(len(data))); err != errUnknownContainer {
+		t.Errorf("got %v, want errUnknownContainer", err)
+	}
+}
+
+// failingReaderAt fails the test if anything is read from it
+type failingReaderAt struct {
+	t *testing.T
+}
+
+func (r failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.t.Fatalf("read %d bytes at %d", len(p), off)
+	return 0, nil
+}
+
+func TestEbmlFloatInvalidSize(t *testing.T) {
+	// a corrupt file can claim any size for the Duration element, which
+	// mustn't be allocated before it is rejected
+	for _, size := range []int64{0, 2, 16, 1 << 40} {
+		if f := ebmlFloat(failingReaderAt{t}, ebmlElement{id: ebmlDuration, size: size}); f != 0 {
+			t.Errorf("size %d: got %v, want 0", size, f)
+		}
+	}
+}
+
+// probeFixture probes the file of testdata
+func probeFixture(t *testing.T, name string) videoInfo {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := probeVideo(f, fi.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
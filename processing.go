@@ -0,0 +1,68 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"google.golang.org/api/youtube/v3"
+)
+
+// waitForProcessing waits until Youtube has finished processing the video,
+// printing its progress. An error is returned if processing fails, the video
+// is rejected, the timeout is reached or ctx is cancelled.
+func waitForProcessing(ctx context.Context, u *uploader.Uploader, filename, videoID string) (*youtube.Video, error) {
+	fmt.Fprintf(output, "Waiting for video %s to be processed...\n", videoID)
+	video, err := u.WaitForProcessing(ctx, videoID, uploader.ProcessingOptions{
+		Interval: *pollInterval,
+		Timeout:  *procTimeout,
+		Status: func(video *youtube.Video) {
+			ev := progressEvent{File: filename, Phase: "processing", VideoID: videoID}
+			if pd := video.ProcessingDetails; pd != nil {
+				if pp := pd.ProcessingProgress; pp != nil && pp.PartsTotal > 0 {
+					fmt.Fprintf(output, "Processing: %d / %d parts, %s left\n", pp.PartsProcessed, pp.PartsTotal,
+						time.Duration(pp.TimeLeftMs)*time.Millisecond)
+					ev.Percent = float64(pp.PartsProcessed) * 100 / float64(pp.PartsTotal)
+					ev.EtaSeconds = float64(pp.TimeLeftMs) / 1000
+				}
+			}
+			emitProgress(ev)
+		},
+	})
+	if err == nil {
+		fmt.Fprintln(output, colorize(output, styleSuccess, fmt.Sprintf("Video %s processed!", videoID)))
+	}
+	return video, err
+}
+
+// deleteFailedVideo deletes the video whose processing failed for
+// -deleteOnProcessingFailure. Its status is fetched once more first, so that
+// only a video which has definitely failed is deleted.
+func deleteFailedVideo(ctx context.Context, service *youtube.Service, videoID string) error {
+	response, err := service.Videos.List([]string{"processingDetails", "status"}).Id(videoID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error confirming the processing failure: %w", err)
+	}
+	if len(response.Items) == 0 {
+		return fmt.Errorf("video %s not found", videoID)
+	}
+	if video := response.Items[0]; !uploader.IsTerminalFailure(video) {
+		return fmt.Errorf("not deleting video %s, as its upload status is now '%s'", videoID, video.Status.UploadStatus)
+	}
+	return service.Videos.Delete(videoID).Context(ctx).Do()
+}
@@ -0,0 +1,145 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// profileDir returns the directory holding the per-profile tokens and
+// client secrets, e.g. ~/.config/youtubeuploader
+func profileDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find config directory for profiles: %s", err)
+	}
+	return filepath.Join(dir, "youtubeuploader"), nil
+}
+
+// validateProfile checks that name can be used as a file name
+func validateProfile(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid profile name '%s'", name)
+	}
+	return nil
+}
+
+// legacy locations of the credentials, in the current directory
+const (
+	legacySecretsFile = "client_secrets.json"
+	legacyTokenFile   = "request.token"
+)
+
+// tokenCacheFile returns the token cache to use. An explicit -cache always
+// wins, otherwise the token is kept in the config directory, under tokens/
+// for a named profile. A token in the legacy location is moved to the config
+// directory.
+func tokenCacheFile() (string, error) {
+	if *cache != "" {
+		return *cache, nil
+	}
+	dir, err := profileDir()
+	if err != nil {
+		if *profile != "" {
+			return "", err
+		}
+		return legacyTokenFile, nil
+	}
+
+	var path string
+	if *profile != "" {
+		path = filepath.Join(dir, "tokens", *profile+".json")
+	} else {
+		path = filepath.Join(dir, legacyTokenFile)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("cannot create token directory: %s", err)
+	}
+
+	if *profile == "" {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if _, err := os.Stat(legacyTokenFile); err == nil {
+				if err := os.Rename(legacyTokenFile, path); err != nil {
+					warnf("Cannot move token cache '%s' to '%s', using it in place: %s", legacyTokenFile, path, err)
+					path = legacyTokenFile
+				} else {
+					fmt.Fprintf(promptOutput, "Moved token cache '%s' to '%s'\n", legacyTokenFile, path)
+				}
+			}
+		}
+	}
+
+	checkTokenPermissions(path)
+	return path, nil
+}
+
+// checkTokenPermissions warns if the token file can be read by other users
+func checkTokenPermissions(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	fileInfo, err := os.Stat(path)
+	if err == nil && fileInfo.Mode().Perm()&0077 != 0 {
+		warnf("Token cache '%s' is readable by other users. Run 'chmod 600 %s'", path, path)
+	}
+}
+
+// secretsFile returns the client secrets file to use. Without -secrets, a
+// profile may have its own secrets in secrets/<profile>.json in the config
+// directory, then client_secrets.json is looked for in the config directory
+// and finally in the current directory.
+func secretsFile() string {
+	if *clientSecretsFile != "" {
+		return *clientSecretsFile
+	}
+	dir, err := profileDir()
+	if err != nil {
+		return legacySecretsFile
+	}
+	var candidates []string
+	if *profile != "" {
+		candidates = append(candidates, filepath.Join(dir, "secrets", *profile+".json"))
+	}
+	candidates = append(candidates, filepath.Join(dir, legacySecretsFile))
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return legacySecretsFile
+}
+
+// listProfiles returns the names of the profiles with a cached token
+func listProfiles() ([]string, error) {
+	dir, err := profileDir()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "tokens", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(m), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
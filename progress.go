@@ -15,32 +15,358 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+var (
+	// progressOut receives the progress indicator. It is nil when progress
+	// isn't shown.
+	progressOut io.Writer
+
+	// progressTTY is set when progressOut is a terminal, so the progress can
+	// be redrawn in place rather than printed a line at a time
+	progressTTY bool
+
+	// progressJSON receives progress events as JSON lines for -progress
+	// json. It is nil otherwise, and progressOut is then nil.
+	progressJSON *json.Encoder
+	progressMu   sync.Mutex
 )
 
-func Progress(quitChan chanChan, transport *limitTransport, filesize int64) {
-	ticker := time.Tick(time.Second)
-	var erase int
+// setProgressOutput chooses how progress is shown from -progress, and where
+// from -progressTo or -progressFile. JSON progress goes to stderr unless
+// -progressTo is given.
+func setProgressOutput() error {
+	if *progressFormat != "text" && *progressFormat != "json" {
+		return fmt.Errorf("invalid value for -progress '%s', must be 'text' or 'json'", *progressFormat)
+	}
+	var f *os.File
+	switch *progressTo {
+	case "stdout":
+		f = os.Stdout
+		if *progressFormat == "json" && !isFlagSet("progressTo") {
+			f = os.Stderr
+		}
+		if f == os.Stdout && jsonOutput() && *progressFile == "" {
+			return nil
+		}
+	case "stderr":
+		f = os.Stderr
+	case "none":
+		return nil
+	default:
+		return fmt.Errorf("invalid value for -progressTo '%s', must be 'stdout', 'stderr' or 'none'", *progressTo)
+	}
+	if *quiet {
+		return nil
+	}
+	if *progressFile != "" {
+		// this may be a named pipe, which blocks until the other end opens it
+		var err error
+		f, err = os.OpenFile(*progressFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening -progressFile: %s", err)
+		}
+	}
+	if *progressFormat == "json" {
+		progressJSON = json.NewEncoder(f)
+		return nil
+	}
+	progressOut = f
+	progressTTY = isTerminal(f)
+	return nil
+}
+
+// progressEvent is a line of -progress json output
+type progressEvent struct {
+	File string `json:"file"`
+	// Phase is 'uploading', 'finalizing' once all of the file has been sent,
+	// 'processing' with -waitForProcessing, and finally 'done' or 'error'
+	Phase      string  `json:"phase"`
+	BytesSent  int64   `json:"bytesSent"`
+	TotalBytes int64   `json:"totalBytes,omitempty"`
+	Percent    float64 `json:"percent,omitempty"`
+	RateBps    int64   `json:"rateBps"`
+	EtaSeconds float64 `json:"etaSeconds,omitempty"`
+	VideoID    string  `json:"videoId,omitempty"`
+	Checksum   string  `json:"checksum,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// emitProgress writes ev for -progress json, if it was given
+func emitProgress(ev progressEvent) {
+	if progressJSON == nil {
+		return
+	}
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	progressJSON.Encode(ev)
+}
+
+// emitResult writes the final -progress json event for an upload
+func emitResult(file string, result *uploadResult, err error) {
+	ev := progressEvent{File: file, Phase: "done", VideoID: result.VideoID, Checksum: result.Checksum}
+	if result.Stats != nil {
+		ev.BytesSent = result.Stats.PayloadBytes
+	}
+	if err == nil && result.FileSize > 0 {
+		ev.TotalBytes, ev.Percent = result.FileSize, 100
+	}
+	if err != nil {
+		ev.Phase = "error"
+		ev.Error = err.Error()
+	}
+	emitProgress(ev)
+}
+
+// ProgressJSON writes a -progress json event for the upload of worker every
+// -progressInterval
+func ProgressJSON(quitChan chanChan, worker *uploadWorker, filesize int64) {
+	ticker := progressTick()
+	for {
+		select {
+		case <-ticker:
+			p := worker.progress()
+			if !p.Started {
+				continue
+			}
+			filename, _ := worker.current()
+			ev := progressEvent{
+				File:      filename,
+				Phase:     "uploading",
+				BytesSent: p.Sent,
+				RateBps:   p.Rate,
+			}
+			if p.AllSent {
+				ev.Phase = "finalizing"
+			}
+			if filesize > 0 {
+				ev.TotalBytes = filesize
+				ev.Percent = float64(ev.BytesSent) * 100 / float64(filesize)
+				if avg := etaRate(p); avg > 0 && ev.BytesSent < filesize {
+					ev.EtaSeconds = float64(filesize-ev.BytesSent) / float64(avg)
+				}
+			}
+			emitProgress(ev)
+		case ch := <-quitChan:
+			close(ch)
+			return
+		}
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressTick returns a ticker for -progressInterval. By default progress
+// is redrawn every second on a terminal or written as JSON every second, and
+// printed every 30 seconds otherwise so that logs aren't flooded.
+func progressTick() <-chan time.Time {
+	interval := *progressEvery
+	if interval <= 0 {
+		interval = time.Second
+		if !progressTTY && progressJSON == nil {
+			interval = 30 * time.Second
+		}
+	}
+	return time.Tick(interval)
+}
+
+// progressLine prints progress status lines, overwriting the previous one on
+// a terminal
+type progressLine struct {
+	// erase is the length of the previous line
+	erase int
+}
+
+func (p *progressLine) print(status string) {
+	if !progressTTY {
+		fmt.Fprintln(progressOut, status)
+		return
+	}
+	// a line that wraps can't be overwritten with \r, so it is cut short of
+	// the last column
+	if width := progressWidth(); width > 1 {
+		status = truncateRunes(status, width-1)
+	}
+	// the rest of a longer previous line is blanked out with spaces rather
+	// than an escape sequence, which older Windows consoles don't support
+	n := utf8.RuneCountInString(status)
+	pad := ""
+	if p.erase > n {
+		pad = strings.Repeat(" ", p.erase-n)
+	}
+	fmt.Fprintf(progressOut, "\r%s%s", status, pad)
+	p.erase = n
+}
+
+// progressWidth returns the width of the terminal the progress is shown on,
+// from the terminal itself or from COLUMNS, or 0 if it isn't known
+func progressWidth() int {
+	if f, ok := progressOut.(*os.File); ok {
+		if width := consoleWidth(f); width > 0 {
+			return width
+		}
+	}
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return width
+}
+
+// truncateRunes cuts s to at most n characters
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j]
+		}
+		i++
+	}
+	return s
+}
+
+// end finishes the progress line on a terminal
+func (p *progressLine) end() {
+	if progressTTY && p.erase > 0 {
+		fmt.Fprintln(progressOut)
+	}
+}
+
+func Progress(quitChan chanChan, worker *uploadWorker, filesize int64) {
+	ticker := progressTick()
+	var line progressLine
 	for {
 		select {
 		case <-ticker:
-			if transport.reader != nil {
-				s := transport.reader.Monitor.Status()
-				curRate := float32(s.CurRate)
+			if p := worker.progress(); p.Started {
+				// percentage and ETA are based on the bytes Youtube has
+				// acknowledged, so that retried chunks aren't counted twice
+				done := p.Sent
+				curRate, rateUnit := formatRate(p.Rate)
 				var status string
-				if curRate >= 125000 {
-					status = fmt.Sprintf("Progress: %8.2f Mbps, %d / %d (%s) ETA %8s", curRate/125000, s.Bytes, filesize, s.Progress, s.TimeRem)
+				if filesize > 0 {
+					var eta time.Duration
+					if avg := etaRate(p); avg > 0 && done < filesize {
+						eta = time.Duration(float64(filesize-done)/float64(avg)) * time.Second
+					}
+					status = fmt.Sprintf("Progress: %8.2f %s, %s (%.1f%%) ETA %8s", curRate, rateUnit, progressBytes(done, filesize),
+						float64(done)*100/float64(filesize), eta.Round(time.Second))
+				} else {
+					// total size is unknown e.g. reading from stdin
+					status = fmt.Sprintf("Progress: %8.2f %s, %s", curRate, rateUnit, progressBytes(done, 0))
+				}
+				status += limitStatus(worker.transport.Limit())
+				line.print(status)
+			}
+		case ch := <-quitChan:
+			line.end()
+			close(ch)
+			return
+		}
+	}
+}
+
+// limitStatus describes the rate limit in force for the progress line, if
+// there is one
+func limitStatus(limit *uploader.Limit) string {
+	kbps := limit.Get()
+	if kbps == 0 {
+		return ""
+	}
+	rate, unit := formatRate(int64(kbps) * 125)
+	return fmt.Sprintf(" (limit %.2f %s)", rate, unit)
+}
+
+// progressBytes describes done bytes of total for the progress line, e.g.
+// '1.50 GiB / 28.00 GiB', or just the bytes done if the total is unknown.
+// With -progressRaw they are plain numbers of bytes, as they used to be.
+func progressBytes(done, total int64) string {
+	if *progressRaw {
+		if total > 0 {
+			return fmt.Sprintf("%d / %d", done, total)
+		}
+		return fmt.Sprintf("%d bytes", done)
+	}
+	if total > 0 {
+		return formatBytes(done) + " / " + formatBytes(total)
+	}
+	return formatBytes(done)
+}
+
+// formatBytes formats a byte count in binary units with two decimals, e.g.
+// '1.50 GiB'
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, suffix := range []string{"KiB", "MiB", "GiB"} {
+		value /= unit
+		if value < unit {
+			return fmt.Sprintf("%.2f %s", value, suffix)
+		}
+	}
+	return fmt.Sprintf("%.2f TiB", value/unit)
+}
+
+// formatRate converts a rate in bytes per second to kbps or Mbps
+func formatRate(bytesPerSec int64) (float32, string) {
+	rate := float32(bytesPerSec)
+	if rate >= 125000 {
+		return rate / 125000, "Mbps"
+	}
+	return rate / 125, "kbps"
+}
+
+// ProgressAll shows the combined progress of concurrent uploads on one
+// line: the total rate, then the percentage done of each file
+func ProgressAll(quitChan chanChan, workers []*uploadWorker) {
+	ticker := progressTick()
+	var line progressLine
+	for {
+		select {
+		case <-ticker:
+			var total int64
+			var files []string
+			for _, w := range workers {
+				filename, filesize := w.current()
+				p := w.progress()
+				if filename == "" || !p.Started {
+					continue
+				}
+				total += p.Rate
+				done := p.Sent
+				if filesize > 0 {
+					files = append(files, fmt.Sprintf("%s %.1f%%", filepath.Base(filename), float64(done)*100/float64(filesize)))
 				} else {
-					status = fmt.Sprintf("Progress: %8.2f kbps, %d / %d (%s) ETA %8s", curRate/125, s.Bytes, filesize, s.Progress, s.TimeRem)
+					files = append(files, fmt.Sprintf("%s %s", filepath.Base(filename), progressBytes(done, 0)))
 				}
-				fmt.Printf("\r%s\r%s", strings.Repeat(" ", erase), status)
-				erase = len(status)
 			}
+			if len(files) == 0 {
+				continue
+			}
+			rate, rateUnit := formatRate(total)
+			status := fmt.Sprintf("Progress: %8.2f %s%s, %s", rate, rateUnit, limitStatus(workers[0].transport.Limit()), strings.Join(files, " | "))
+			line.print(status)
 		case ch := <-quitChan:
-			// final newline
-			fmt.Println()
+			line.end()
 			close(ch)
 			return
 		}
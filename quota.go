@@ -0,0 +1,75 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dailyLimitReasons are the API error reasons that mean a daily limit has
+// been reached, either the channel's uploads or the API project's quota.
+// Both reset at midnight Pacific time.
+var dailyLimitReasons = map[string]bool{
+	"quotaExceeded":       true,
+	"dailyLimitExceeded":  true,
+	"uploadLimitExceeded": true,
+}
+
+// isDailyLimitError reports whether err is from the API refusing a request
+// because a daily limit has been reached
+func isDailyLimitError(err error) bool {
+	for _, reason := range apiErrorReasons(err) {
+		if dailyLimitReasons[reason] {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaReset returns the next midnight Pacific time after now, when Youtube's
+// daily limits reset
+func quotaReset(now time.Time) time.Time {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		// no time zone database, so ignore daylight saving time
+		loc = time.FixedZone("PST", -8*60*60)
+	}
+	pacific := now.In(loc)
+	return time.Date(pacific.Year(), pacific.Month(), pacific.Day()+1, 0, 0, 0, 0, loc)
+}
+
+// describeQuotaReset says when the daily limits reset, in local time
+func describeQuotaReset() string {
+	reset := quotaReset(time.Now())
+	wait := strings.TrimSuffix(time.Until(reset).Round(time.Minute).String(), "0s")
+	return fmt.Sprintf("at %s local time, in %s", reset.Local().Format("2006-01-02 15:04"), wait)
+}
+
+// waitForQuotaReset sleeps until a minute after the daily limits reset for
+// -retryAfterQuota, returning early with an error if ctx is done
+func waitForQuotaReset(ctx context.Context, filename string) error {
+	// a little later, in case the clocks differ
+	reset := quotaReset(time.Now()).Add(time.Minute)
+	fmt.Fprintf(output, "Daily limit reached uploading '%s', waiting until %s to try again\n", filename, reset.Local().Format("2006-01-02 15:04"))
+	select {
+	case <-time.After(time.Until(reset)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
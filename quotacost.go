@@ -0,0 +1,385 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaCosts is the quota cost in units of each Youtube API method, from
+// https://developers.google.com/youtube/v3/determine_quota_cost. Methods that
+// aren't listed cost defaultQuotaCost.
+var quotaCosts = map[string]int{
+	"videos.insert":        1600,
+	"videos.list":          1,
+	"videos.update":        50,
+	"videos.delete":        50,
+	"videos.rate":          50,
+	"videos.getRating":     1,
+	"thumbnails.set":       50,
+	"captions.insert":      400,
+	"captions.list":        50,
+	"captions.update":      450,
+	"captions.delete":      50,
+	"captions.download":    200,
+	"playlists.insert":     50,
+	"playlists.list":       1,
+	"playlists.update":     50,
+	"playlists.delete":     50,
+	"playlistItems.insert": 50,
+	"playlistItems.list":   1,
+	"playlistItems.update": 50,
+	"playlistItems.delete": 50,
+	"channels.list":        1,
+	"videoCategories.list": 1,
+	"i18nLanguages.list":   1,
+	"i18nRegions.list":     1,
+	"search.list":          100,
+}
+
+// defaultQuotaCost is the cost of a method missing from quotaCosts
+const defaultQuotaCost = 1
+
+// quotaFile keeps the quota used each day, in the config directory
+const quotaFile = "quota.json"
+
+// quotaDays is how many days of quota use are kept
+const quotaDays = 30
+
+// quotaCost returns the cost of calls calls of method
+func quotaCost(method string, calls int) int {
+	cost, ok := quotaCosts[method]
+	if !ok {
+		cost = defaultQuotaCost
+	}
+	return cost * calls
+}
+
+// apiMethod returns the Youtube API method r calls, e.g. 'videos.insert',
+// or "" if it isn't an API call. The chunks of a resumable upload are part
+// of the videos.insert call that started it.
+func apiMethod(r *http.Request) string {
+	const prefix = "/youtube/v3/"
+	i := strings.Index(r.URL.Path, prefix)
+	if i < 0 || r.URL.Query().Get("upload_id") != "" {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path[i+len(prefix):], "/"), "/")
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "set", "rate", "getRating":
+			return parts[0] + "." + parts[1]
+		}
+		if r.Method == "GET" {
+			// captions/{id}
+			return parts[0] + ".download"
+		}
+	}
+	switch r.Method {
+	case "GET":
+		return parts[0] + ".list"
+	case "POST":
+		return parts[0] + ".insert"
+	case "PUT":
+		return parts[0] + ".update"
+	case "DELETE":
+		return parts[0] + ".delete"
+	}
+	return parts[0]
+}
+
+// quotaCalls counts the API calls of each method
+type quotaCalls map[string]int
+
+// units returns the estimated cost of the calls
+func (c quotaCalls) units() int {
+	var total int
+	for method, calls := range c {
+		total += quotaCost(method, calls)
+	}
+	return total
+}
+
+// describe lists the calls by method, the most expensive first
+func (c quotaCalls) describe() string {
+	methods := make([]string, 0, len(c))
+	for method := range c {
+		methods = append(methods, method)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		ci, cj := quotaCost(methods[i], c[methods[i]]), quotaCost(methods[j], c[methods[j]])
+		if ci != cj {
+			return ci > cj
+		}
+		return methods[i] < methods[j]
+	})
+	parts := make([]string, len(methods))
+	for i, method := range methods {
+		parts[i] = fmt.Sprintf("%s %dx%d", method, c[method], quotaCost(method, 1))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// quotaUsage counts the API calls made by this run
+var quotaUsage = struct {
+	sync.Mutex
+	calls quotaCalls
+}{calls: make(quotaCalls)}
+
+// recordAPICall counts the API call made by r, if it is one
+func recordAPICall(r *http.Request) {
+	method := apiMethod(r)
+	if method == "" {
+		return
+	}
+	quotaUsage.Lock()
+	quotaUsage.calls[method]++
+	quotaUsage.Unlock()
+}
+
+// quotaTransport counts the API calls sent through it. It is outside the
+// retries, so that each call is only counted once.
+type quotaTransport struct {
+	base http.RoundTripper
+}
+
+func (t *quotaTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	recordAPICall(r)
+	return t.base.RoundTrip(r)
+}
+
+// takeQuotaUsage returns the calls counted since it was last called
+func takeQuotaUsage() quotaCalls {
+	quotaUsage.Lock()
+	defer quotaUsage.Unlock()
+	calls := quotaUsage.calls
+	quotaUsage.calls = make(quotaCalls)
+	return calls
+}
+
+// currentQuotaUsage returns the calls counted so far, without resetting them
+func currentQuotaUsage() quotaCalls {
+	quotaUsage.Lock()
+	defer quotaUsage.Unlock()
+	calls := make(quotaCalls, len(quotaUsage.calls))
+	for method, n := range quotaUsage.calls {
+		calls[method] = n
+	}
+	return calls
+}
+
+// printQuotaUsage prints the estimated quota cost of the calls made so far
+func printQuotaUsage(w io.Writer) {
+	calls := currentQuotaUsage()
+	if len(calls) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Estimated quota cost: %d units (%s)\n", calls.units(), calls.describe())
+}
+
+// quotaDay is the quota used on one day, in Pacific time like the quota
+type quotaDay struct {
+	Date  string     `json:"date"`
+	Units int        `json:"units"`
+	Calls quotaCalls `json:"calls"`
+}
+
+// pacificDate returns the day of now in Pacific time, in which the quota is
+// counted
+func pacificDate(now time.Time) string {
+	reset := quotaReset(now)
+	return reset.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// quotaPath returns the file keeping the daily quota use
+func quotaPath() (string, error) {
+	dir, err := profileDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, quotaFile), nil
+}
+
+// loadQuotaDays reads the quota used on recent days, oldest first
+func loadQuotaDays() ([]quotaDay, error) {
+	path, err := quotaPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading quota use: %s", err)
+	}
+	var days []quotaDay
+	if err := json.Unmarshal(data, &days); err != nil {
+		return nil, fmt.Errorf("error reading quota use from '%s': %s", path, err)
+	}
+	return days, nil
+}
+
+// quotaUsedToday returns the quota recorded for today
+func quotaUsedToday() quotaCalls {
+	days, err := loadQuotaDays()
+	if err != nil {
+		warnf("%s", err)
+	}
+	today := pacificDate(time.Now())
+	for _, d := range days {
+		if d.Date == today {
+			return d.Calls
+		}
+	}
+	return nil
+}
+
+// saveQuotaUsage adds the calls made since it was last called to today's
+// total in the config directory. Failures only get a warning, as the total
+// is just an estimate.
+func saveQuotaUsage() {
+	calls := takeQuotaUsage()
+	if len(calls) == 0 {
+		return
+	}
+	days, err := loadQuotaDays()
+	if err != nil {
+		warnf("%s", err)
+		return
+	}
+	today := pacificDate(time.Now())
+	if len(days) == 0 || days[len(days)-1].Date != today {
+		days = append(days, quotaDay{Date: today, Calls: make(quotaCalls)})
+	}
+	day := &days[len(days)-1]
+	if day.Calls == nil {
+		day.Calls = make(quotaCalls)
+	}
+	for method, n := range calls {
+		day.Calls[method] += n
+	}
+	day.Units = day.Calls.units()
+	if len(days) > quotaDays {
+		days = days[len(days)-quotaDays:]
+	}
+
+	path, err := quotaPath()
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(days, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(path+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		warnf("Error saving quota use: %s", err)
+	}
+}
+
+// estimateQuota returns the quota the uploads of jobs are expected to use
+func estimateQuota(jobs []uploadJob) quotaCalls {
+	calls := make(quotaCalls)
+	for _, job := range jobs {
+		calls["videos.insert"]++
+		if job.thumbnailFile() != "" {
+			calls["thumbnails.set"]++
+		}
+		if n := len(job.captions()); n > 0 {
+			calls["captions.insert"] += n
+		}
+		if n := len(job.playlistIDs()) + len(job.playlistTitles()); n > 0 {
+			calls["playlistItems.insert"] += n
+		}
+	}
+	return calls
+}
+
+// checkQuotaBudget warns if the estimated quota of the uploads of jobs,
+// with what has been used today, is over -quotaBudget
+func checkQuotaBudget(jobs []uploadJob) {
+	estimate := estimateQuota(jobs)
+	if *dryRun {
+		fmt.Fprintf(output, "Estimated quota cost of the uploads: %d units (%s)\n", estimate.units(), estimate.describe())
+	}
+	if *quotaBudget <= 0 {
+		return
+	}
+	used := quotaUsedToday().units() + currentQuotaUsage().units()
+	if used+estimate.units() > *quotaBudget {
+		warnf("Warning: uploading %d file(s) is estimated to cost %d quota units, which with the %d used today is over the -quotaBudget of %d",
+			len(jobs), estimate.units(), used, *quotaBudget)
+	}
+}
+
+// printQuotaReport prints the quota used today, by method, and on the
+// previous days for -quotaReport
+func printQuotaReport(w io.Writer) error {
+	days, err := loadQuotaDays()
+	if err != nil {
+		return err
+	}
+	today := pacificDate(time.Now())
+	var used quotaCalls
+	for _, d := range days {
+		if d.Date == today {
+			used = d.Calls
+		}
+	}
+	fmt.Fprintf(w, "Quota used today (%s Pacific time): %d units\n", today, used.units())
+	if len(used) > 0 {
+		fmt.Fprintf(w, "  %s\n", used.describe())
+	}
+	if *quotaBudget > 0 {
+		left := *quotaBudget - used.units()
+		if left < 0 {
+			left = 0
+		}
+		fmt.Fprintf(w, "Budget: %d units, %d left\n", *quotaBudget, left)
+	}
+	fmt.Fprintf(w, "Resets %s\n", describeQuotaReset())
+	var earlier []quotaDay
+	for _, d := range days {
+		if d.Date != today {
+			earlier = append(earlier, d)
+		}
+	}
+	if len(earlier) > 7 {
+		earlier = earlier[len(earlier)-7:]
+	}
+	if len(earlier) > 0 {
+		fmt.Fprintf(w, "Earlier days:\n")
+		for _, d := range earlier {
+			fmt.Fprintf(w, "  %s: %d units\n", d.Date, d.Units)
+		}
+	}
+	return nil
+}
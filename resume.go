@@ -0,0 +1,134 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+const uploadStateSuffix = ".upload-state.json"
+
+// uploadState records the progress of a resumable upload session so that an
+// interrupted upload can be continued by a later invocation with -resume
+type uploadState struct {
+	SessionURI string    `json:"sessionURI"`
+	Offset     int64     `json:"offset"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modTime"`
+	MediaType  string    `json:"mediaType,omitempty"`
+
+	mu        sync.Mutex
+	path      string
+	saveError bool
+	done      bool
+}
+
+// newUploadState returns an empty upload state for the local file filename
+func newUploadState(filename string) (*uploadState, error) {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadState{
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+		path:    filename + uploadStateSuffix,
+	}, nil
+}
+
+// loadUploadState reads the saved upload state for filename. An error is
+// returned if there is no saved state or if the file has changed since the
+// state was saved.
+func loadUploadState(filename string) (*uploadState, error) {
+	state, err := newUploadState(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(state.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upload state: %s", err)
+	}
+
+	saved := &uploadState{path: state.path}
+	err = json.Unmarshal(data, saved)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing upload state '%s': %s", state.path, err)
+	}
+	if saved.SessionURI == "" {
+		return nil, fmt.Errorf("upload state '%s' has no upload session", state.path)
+	}
+	if saved.Size != state.Size || !saved.ModTime.Equal(state.ModTime) {
+		return nil, fmt.Errorf("'%s' has changed since the upload state was saved", filename)
+	}
+
+	return saved, nil
+}
+
+// save writes the upload state to disk. Failures are reported once and then
+// ignored, as the state file is only needed to resume after an interruption.
+func (s *uploadState) save() {
+	data, err := json.Marshal(s)
+	if err == nil {
+		tmp := s.path + ".tmp"
+		err = ioutil.WriteFile(tmp, data, 0600)
+		if err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil && !s.saveError {
+		fmt.Fprintf(output, "\nError saving upload state: %s\n", err)
+		s.saveError = true
+	}
+}
+
+// remove deletes the saved upload state
+func (s *uploadState) remove() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	err := os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(output, "Error removing upload state: %s\n", err)
+	}
+}
+
+// update records the upload session URI and committed offset of the upload
+func (s *uploadState) update(session uploader.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return
+	}
+	s.SessionURI = session.URI
+	s.Offset = session.Offset
+	if session.MediaType != "" {
+		s.MediaType = session.MediaType
+	}
+	s.save()
+}
+
+// session returns the saved upload session, for resuming it
+func (s *uploadState) session() *uploader.Session {
+	return &uploader.Session{URI: s.SessionURI, Offset: s.Offset, MediaType: s.MediaType}
+}
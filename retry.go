@@ -0,0 +1,28 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+// retryCall calls call, retrying it like the uploads' requests when it fails
+// because Youtube wants requests slowed down, within -maxTotalRetryTime. It
+// is for calls with bodies that can't be read again, e.g. thumbnails.
+func retryCall(ctx context.Context, call func() error) error {
+	return uploader.RetryCall(ctx, *maxRetryTime, warningsOf(ctx).warnf, call)
+}
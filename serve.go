@@ -0,0 +1,585 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"golang.org/x/oauth2"
+)
+
+// defaultServeQueue is the name of the -serve job queue in the config
+// directory
+const defaultServeQueue = "jobs.json"
+
+// states of a -serve job
+const (
+	jobQueued    = "queued"
+	jobUploading = "uploading"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// serveJob is a job submitted to -serve. Request is the job as posted, in the
+// manifest line format.
+type serveJob struct {
+	ID       string          `json:"id"`
+	State    string          `json:"state"`
+	Created  time.Time       `json:"created"`
+	Request  json.RawMessage `json:"request"`
+	Result   *uploadResult   `json:"result,omitempty"`
+	Code     string          `json:"code,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Progress *uploadStatus   `json:"progress,omitempty"`
+}
+
+// jobQueue is the -serve queue, which is saved to path each time it changes
+// so that a restart carries on with the jobs that weren't finished
+type jobQueue struct {
+	mu   sync.Mutex
+	path string
+	jobs []*serveJob
+	// running holds the cancel function and worker of each job being
+	// uploaded
+	running map[string]runningJob
+	// wake is signalled when a job is queued
+	wake chan struct{}
+}
+
+type runningJob struct {
+	cancel context.CancelFunc
+	worker *uploadWorker
+}
+
+// serveQueuePath returns the -serveQueue file, by default in the config
+// directory
+func serveQueuePath() (string, error) {
+	if *serveQueue != "" {
+		return *serveQueue, nil
+	}
+	dir, err := profileDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, defaultServeQueue), nil
+}
+
+// loadJobQueue reads the queue saved at path. Jobs that were being uploaded
+// when the server stopped are queued again.
+func loadJobQueue(path string) (*jobQueue, error) {
+	q := &jobQueue{
+		path:    path,
+		running: make(map[string]runningJob),
+		wake:    make(chan struct{}, 1),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading job queue: %s", err)
+	}
+	if err := json.Unmarshal(data, &q.jobs); err != nil {
+		return nil, fmt.Errorf("error reading job queue '%s': %s", path, err)
+	}
+	var pending int
+	for _, j := range q.jobs {
+		if j.State == jobUploading {
+			j.State = jobQueued
+		}
+		if j.State == jobQueued {
+			pending++
+		}
+	}
+	if pending > 0 {
+		fmt.Fprintf(output, "%d job(s) left in the queue '%s'\n", pending, path)
+	}
+	return q, nil
+}
+
+// save writes the queue to its file, replacing it atomically. The caller
+// must hold the lock.
+func (q *jobQueue) save() {
+	data, err := json.MarshalIndent(q.jobs, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(q.path), 0700)
+	}
+	if err == nil {
+		tmp := q.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, q.path)
+		}
+	}
+	if err != nil {
+		warnf("Error saving the job queue to '%s': %v", q.path, err)
+	}
+}
+
+// find returns the job with the given ID. The caller must hold the lock.
+func (q *jobQueue) find(id string) *serveJob {
+	for _, j := range q.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// view returns a copy of j to send to a client, with its progress if it is
+// being uploaded. The caller must hold the lock.
+func (q *jobQueue) view(j *serveJob) serveJob {
+	v := *j
+	if r, ok := q.running[j.ID]; ok {
+		if s := currentStatus([]*uploadWorker{r.worker}); len(s) > 0 {
+			v.Progress = &s[0]
+		}
+	}
+	return v
+}
+
+// add queues a job for the manifest line data
+func (q *jobQueue) add(data []byte) (serveJob, error) {
+	job, err := manifestJob(data)
+	if err != nil {
+		return serveJob{}, err
+	}
+	if job.filename == "-" {
+		return serveJob{}, errors.New("stdin can't be uploaded by a job")
+	}
+	if !isURL(job.filename) {
+		if _, err := os.Stat(job.filename); err != nil {
+			return serveJob{}, err
+		}
+	}
+	if err := checkJobSources(job); err != nil {
+		return serveJob{}, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return serveJob{}, err
+	}
+	j := &serveJob{
+		ID:      hex.EncodeToString(id),
+		State:   jobQueued,
+		Created: time.Now(),
+		Request: json.RawMessage(data),
+	}
+	q.mu.Lock()
+	q.jobs = append(q.jobs, j)
+	q.save()
+	v := q.view(j)
+	q.mu.Unlock()
+	q.signal()
+	return v, nil
+}
+
+// signal wakes an idle worker
+func (q *jobQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// claim marks the oldest queued job as being uploaded by worker and
+// returns it, or nil if there is none. If more are queued, another worker
+// is woken for them.
+func (q *jobQueue) claim(ctx context.Context, worker *uploadWorker) (*serveJob, context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var claimed *serveJob
+	var jobCtx context.Context
+	for _, j := range q.jobs {
+		if j.State != jobQueued {
+			continue
+		}
+		if claimed != nil {
+			q.signal()
+			break
+		}
+		j.State = jobUploading
+		q.save()
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithCancel(ctx)
+		q.running[j.ID] = runningJob{cancel, worker}
+		claimed = j
+	}
+	return claimed, jobCtx
+}
+
+// finish records the outcome of a job. A job that was stopped because the
+// server is stopping is queued again.
+func (q *jobQueue) finish(ctx context.Context, j *serveJob, result *uploadResult, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r := q.running[j.ID]
+	delete(q.running, j.ID)
+	r.cancel()
+	switch {
+	case j.State == jobCancelled:
+	case err != nil && ctx.Err() != nil && result.VideoID == "":
+		j.State = jobQueued
+	case err != nil:
+		j.State = jobFailed
+		j.Code, j.Error = errorCode(err), err.Error()
+	default:
+		j.State = jobDone
+	}
+	j.Result = result
+	q.save()
+}
+
+// cancel cancels a job that hasn't finished
+func (q *jobQueue) cancel(id string) (serveJob, int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.find(id)
+	if j == nil {
+		return serveJob{}, http.StatusNotFound, fmt.Errorf("job %s not found", id)
+	}
+	if j.State != jobQueued && j.State != jobUploading {
+		return q.view(j), http.StatusConflict, fmt.Errorf("job %s is already %s", id, j.State)
+	}
+	if r, ok := q.running[id]; ok {
+		r.cancel()
+	}
+	j.State = jobCancelled
+	q.save()
+	return q.view(j), http.StatusOK, nil
+}
+
+// serveJobs accepts upload jobs over HTTP on -serve until interrupted,
+// uploading them -concurrency at a time with the same pipeline as -filename
+// and -manifest
+func serveJobs(ctx context.Context, ts oauth2.TokenSource, lr uploader.Window, limit *uploader.Limit) error {
+	path, err := serveQueuePath()
+	if err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	queue, err := loadJobQueue(path)
+	if err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if *serveDir != "" {
+		if fi, err := os.Stat(*serveDir); err != nil || !fi.IsDir() {
+			return withCode(errCodeUsage, fmt.Errorf("-serveDir '%s' isn't a directory", *serveDir))
+		}
+	}
+	listener, err := net.Listen("tcp", *serveAddr)
+	if err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("error listening on %s: %s", *serveAddr, err))
+	}
+	token := os.ExpandEnv(*serveToken)
+	if err := checkServeToken(listener.Addr(), token); err != nil {
+		listener.Close()
+		return withCode(errCodeUsage, err)
+	}
+
+	workers := *concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var reportMu sync.Mutex
+	var pool []*uploadWorker
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		worker := newUploadWorker(lr, limit)
+		pool = append(pool, worker)
+		if err := worker.connect(ts); err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, jobCtx := queue.claim(ctx, worker)
+				if j == nil {
+					select {
+					case <-queue.wake:
+						continue
+					case <-ctx.Done():
+						return
+					}
+				}
+				result, err := runServeJob(jobCtx, worker, j)
+				queue.finish(ctx, j, result, err)
+
+				reportMu.Lock()
+				if err != nil {
+					reportError(result.File, result.VideoID, err)
+				} else {
+					writeResult(result)
+				}
+				reportMu.Unlock()
+			}
+		}()
+	}
+
+	server := &http.Server{Handler: queue.handler(token)}
+	go server.Serve(listener)
+	stopStatus := startStatusServer(pool)
+	stopPause := watchPauseSignals(pool)
+	fmt.Fprintf(output, "Accepting upload jobs on http://%s/jobs\n", listener.Addr())
+
+	<-ctx.Done()
+	server.Close()
+	wg.Wait()
+	stopPause()
+	stopStatus()
+	return nil
+}
+
+// runServeJob uploads a job taken from the queue
+func runServeJob(ctx context.Context, w *uploadWorker, j *serveJob) (*uploadResult, error) {
+	job, err := manifestJob(j.Request)
+	if err != nil {
+		return &uploadResult{File: job.filename}, withCode(errCodeUsage, err)
+	}
+	// the job may have been queued before a restart with other flags
+	if err := checkJobSources(job); err != nil {
+		return &uploadResult{File: job.filename}, withCode(errCodeUsage, err)
+	}
+	job.index, job.total = 1, 1
+	// whoever posted the job could otherwise read the server's environment
+	// through its title in GET /jobs
+	job.noEnv = true
+	fmt.Fprintf(output, "Starting job %s\n", j.ID)
+	return runJob(ctx, w, job)
+}
+
+// handler serves the job API: POST /jobs queues a job, GET /jobs lists them,
+// and GET and DELETE /jobs/<id> show and cancel one. If token isn't empty,
+// requests must have it as a bearer token, otherwise they must pass
+// checkLocalRequest.
+func (q *jobQueue) handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			j, err := q.add(data)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %s", err))
+				return
+			}
+			w.Header().Set("Location", "/jobs/"+j.ID)
+			writeJSON(w, http.StatusCreated, j)
+		case http.MethodGet:
+			q.mu.Lock()
+			jobs := []serveJob{}
+			for _, j := range q.jobs {
+				jobs = append(jobs, q.view(j))
+			}
+			q.mu.Unlock()
+			sort.SliceStable(jobs, func(i, k int) bool { return jobs[i].Created.Before(jobs[k].Created) })
+			writeJSON(w, http.StatusOK, jobs)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		switch r.Method {
+		case http.MethodGet:
+			q.mu.Lock()
+			j := q.find(id)
+			var v serveJob
+			if j != nil {
+				v = q.view(j)
+			}
+			q.mu.Unlock()
+			if j == nil {
+				writeJSONError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+				return
+			}
+			writeJSON(w, http.StatusOK, v)
+		case http.MethodDelete:
+			v, status, err := q.cancel(id)
+			if err != nil {
+				writeJSONError(w, status, err)
+				return
+			}
+			writeJSON(w, status, v)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+	if token == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := checkLocalRequest(r); err != nil {
+				writeJSONError(w, http.StatusForbidden, err)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// checkServeToken refuses to accept jobs without a token on any address but
+// a loopback one, as anyone who can connect could then upload to the channel
+// and read the sources jobs may name
+func checkServeToken(addr net.Addr, token string) error {
+	if token != "" {
+		return nil
+	}
+	if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("-serveToken is needed to accept jobs on %s, as anyone who can connect to it could upload to the channel. Without a token, listen on a loopback address such as localhost:7000", addr)
+}
+
+// checkLocalRequest checks a request to a server without a token, which
+// only listens on a loopback address but can still be reached by web pages
+// the user visits. The Host and any Origin must be loopback ones, which
+// defeats DNS rebinding, and a POST must be JSON, which a page can only send
+// with a CORS preflight that is never answered.
+func checkLocalRequest(r *http.Request) error {
+	if !isLoopbackHost(r.Host) {
+		return fmt.Errorf("host '%s' isn't a loopback address; use -serveToken to accept jobs by another name", r.Host)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !isLoopbackHost(u.Host) {
+			return fmt.Errorf("requests from '%s' aren't accepted", origin)
+		}
+	}
+	if r.Method == http.MethodPost {
+		if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+			return errors.New("jobs must be posted with Content-Type: application/json")
+		}
+	}
+	return nil
+}
+
+// isLoopbackHost reports whether host, with or without a port, is localhost
+// or a loopback IP address
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkJobSources checks that a -serve job only reads the sources jobs are
+// allowed to: http(s), s3:// and gs:// URLs, and files in -serveDir. This
+// keeps whoever can post jobs from uploading any file the server can read,
+// unless -serveAnySource is given.
+func checkJobSources(job uploadJob) error {
+	if *serveAnySource {
+		return nil
+	}
+	sources := []string{job.filename}
+	if job.videoMeta.Thumbnail != "" {
+		sources = append(sources, job.videoMeta.Thumbnail)
+	}
+	for _, c := range job.videoMeta.Captions {
+		sources = append(sources, c.File)
+	}
+	for _, source := range sources {
+		if err := checkJobSource(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkJobSource(source string) error {
+	if isCloudURL(source) {
+		return nil
+	}
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return nil
+	}
+	refused := fmt.Errorf("'%s' can't be read by a job, which may only name http(s), s3:// and gs:// URLs and files in -serveDir, unless -serveAnySource is given", source)
+	if isURL(source) || *serveDir == "" {
+		return refused
+	}
+	path, err := localPath(source)
+	if err != nil {
+		return err
+	}
+	// symbolic links are followed so that they can't point out of the
+	// directory
+	dir, err := filepath.Abs(*serveDir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading -serveDir: %s", err)
+	}
+	if path, err = filepath.Abs(path); err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return refused
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
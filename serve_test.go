@@ -0,0 +1,156 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckServeToken(t *testing.T) {
+	for _, test := range []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{"127.0.0.1:7000", "", true},
+		{"[::1]:7000", "", true},
+		{"0.0.0.0:7000", "", false},
+		{"[::]:7000", "", false},
+		{"192.168.1.10:7000", "", false},
+		{"0.0.0.0:7000", "secret", true},
+	} {
+		addr, err := net.ResolveTCPAddr("tcp", test.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkServeToken(addr, test.token); (err == nil) != test.ok {
+			t.Errorf("%s with token %q: got %v", test.addr, test.token, err)
+		}
+	}
+}
+
+func TestCheckJobSources(t *testing.T) {
+	dir := t.TempDir()
+	inside := filepath.Join(dir, "in.mp4")
+	outside := filepath.Join(t.TempDir(), "out.mp4")
+	for _, f := range []string{inside, outside} {
+		if err := ioutil.WriteFile(f, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dir, "link.mp4")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Skip("can't make symbolic links:", err)
+	}
+
+	job := func(file, thumbnail string) uploadJob {
+		return uploadJob{filename: file, videoMeta: VideoMeta{Thumbnail: thumbnail}}
+	}
+	tests := []struct {
+		name string
+		job  uploadJob
+		ok   bool
+	}{
+		{"https", job("https://example.com/a.mp4", ""), true},
+		{"s3", job("s3://bucket/a.mp4", ""), true},
+		{"gs", job("gs://bucket/a.mp4", ""), true},
+		{"sftp", job("sftp://nas/videos/a.mp4", ""), false},
+		{"file in -serveDir", job(inside, ""), true},
+		{"file URL in -serveDir", job("file://"+filepath.ToSlash(inside), ""), true},
+		{"file outside", job(outside, ""), false},
+		{"climbing out", job(filepath.Join(dir, "..", filepath.Base(filepath.Dir(outside)), "out.mp4"), ""), false},
+		{"link out", job(link, ""), false},
+		{"thumbnail outside", job(inside, outside), false},
+		{"caption outside", uploadJob{filename: inside, videoMeta: VideoMeta{Captions: []Caption{{Language: "en", File: outside}}}}, false},
+	}
+	setFlags(t, map[string]string{"serveDir": dir})
+	for _, test := range tests {
+		if err := checkJobSources(test.job); (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+
+	// without -serveDir, no local file may be named
+	setFlags(t, map[string]string{"serveDir": ""})
+	if err := checkJobSources(job(inside, "")); err == nil {
+		t.Error("local file allowed without -serveDir")
+	}
+	if err := checkJobSources(job("https://example.com/a.mp4", "")); err != nil {
+		t.Errorf("URL without -serveDir: %v", err)
+	}
+
+	// -serveAnySource allows anything
+	setFlags(t, map[string]string{"serveAnySource": "true"})
+	for _, test := range tests {
+		if err := checkJobSources(test.job); err != nil {
+			t.Errorf("%s with -serveAnySource: %v", test.name, err)
+		}
+	}
+}
+
+func TestCheckLocalRequest(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		method      string
+		host        string
+		origin      string
+		contentType string
+		ok          bool
+	}{
+		{"list", "GET", "localhost:7000", "", "", true},
+		{"post", "POST", "127.0.0.1:7000", "", "application/json", true},
+		{"post with charset", "POST", "[::1]:7000", "", "application/json; charset=utf-8", true},
+		{"local origin", "POST", "localhost:7000", "http://localhost:7000", "application/json", true},
+		{"text post", "POST", "localhost:7000", "", "text/plain", false},
+		{"form post", "POST", "localhost:7000", "", "application/x-www-form-urlencoded", false},
+		{"no content type", "POST", "localhost:7000", "", "", false},
+		{"foreign origin", "POST", "localhost:7000", "https://evil.example", "application/json", false},
+		{"foreign origin list", "GET", "localhost:7000", "https://evil.example", "", false},
+		{"rebound host", "GET", "evil.example:7000", "", "", false},
+	} {
+		r := httptest.NewRequest(test.method, "http://"+test.host+"/jobs", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		if err := checkLocalRequest(r); (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+func TestServeJobNoEnv(t *testing.T) {
+	t.Setenv("YOUTUBEUPLOADER_TEST_SECRET", "hunter2")
+	job, err := manifestJob([]byte(`{"file": "a.mp4", "title": "{{.Env.YOUTUBEUPLOADER_TEST_SECRET}}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := job.video(context.Background(), 0)
+	if err != nil || video.Snippet.Title != "hunter2" {
+		t.Fatalf("without noEnv: got %v, %v", video, err)
+	}
+	job.noEnv = true
+	if video, err := job.video(context.Background(), 0); err == nil {
+		t.Errorf("with noEnv, the title was expanded to %q", video.Snippet.Title)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const googleTokenURL = "https://oauth2.googleapis.com/token"
+
+// credentialsFile is the format of service account keys and of the
+// Application Default Credentials written by gcloud
+type credentialsFile struct {
+	Type string `json:"type"`
+
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURL     string `json:"token_uri"`
+
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// adcFile returns the Application Default Credentials file:
+// GOOGLE_APPLICATION_CREDENTIALS, or the one written by
+// 'gcloud auth application-default login'
+func adcFile() (string, error) {
+	if f := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); f != "" {
+		return f, nil
+	}
+	var dir string
+	if runtime.GOOS == "windows" {
+		dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	f := filepath.Join(dir, "application_default_credentials.json")
+	if _, err := os.Stat(f); err != nil {
+		return "", errors.New("no Application Default Credentials found. Set GOOGLE_APPLICATION_CREDENTIALS or run 'gcloud auth application-default login'")
+	}
+	return f, nil
+}
+
+// credentialsTokenSource returns a token source for -serviceAccountFile, or
+// for the Application Default Credentials with -useADC. No interaction is
+// needed, so the token isn't cached.
+func credentialsTokenSource(ctx context.Context, scopes []string) (oauth2.TokenSource, error) {
+	file := *serviceAcct
+	if file == "" {
+		var err error
+		file, err = adcFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials: %s", err)
+	}
+	var creds credentialsFile
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("error reading credentials '%s': %s", file, err)
+	}
+
+	if creds.Type == "service_account" && *impersonate == "" {
+		warnf("Service accounts have no Youtube channel of their own. Use -impersonate to upload as a user of the Workspace domain")
+	}
+	if creds.Type == "authorized_user" && *impersonate != "" {
+		return nil, fmt.Errorf("-impersonate needs a service account, '%s' is a user's credentials", file)
+	}
+	ts, err := credentialsSource(ctx, file, creds, scopes, *impersonate)
+	if err != nil {
+		return nil, err
+	}
+
+	// get a token now, as the errors for missing delegation are unhelpful
+	// once the upload has started
+	if _, err := ts.Token(); err != nil {
+		if strings.Contains(err.Error(), "unauthorized_client") || strings.Contains(err.Error(), "access_denied") {
+			return nil, fmt.Errorf("%s\nThe service account %s isn't authorised to act as %s. Allow domain-wide delegation for its client ID with the scopes %s in the Workspace admin console",
+				err, creds.ClientEmail, *impersonate, strings.Join(scopes, ","))
+		}
+		return nil, err
+	}
+	return ts, nil
+}
+
+// credentialsSource returns a token source for the credentials creds read
+// from file, acting as subject if it is a service account and subject isn't
+// empty
+func credentialsSource(ctx context.Context, file string, creds credentialsFile, scopes []string, subject string) (oauth2.TokenSource, error) {
+	switch creds.Type {
+	case "service_account":
+		config := &jwt.Config{
+			Email:        creds.ClientEmail,
+			PrivateKey:   []byte(creds.PrivateKey),
+			PrivateKeyID: creds.PrivateKeyID,
+			Scopes:       scopes,
+			TokenURL:     creds.TokenURL,
+			Subject:      subject,
+		}
+		if config.TokenURL == "" {
+			config.TokenURL = googleTokenURL
+		}
+		return newRefreshingTokenSource(nil, func() (*oauth2.Token, error) {
+			return config.TokenSource(ctx).Token()
+		}), nil
+	case "authorized_user":
+		config := &oauth2.Config{
+			ClientID:     creds.ClientID,
+			ClientSecret: creds.ClientSecret,
+			Endpoint:     oauth2.Endpoint{TokenURL: googleTokenURL},
+			Scopes:       scopes,
+		}
+		return newRefreshingTokenSource(nil, refreshFunc(ctx, config, &oauth2.Token{RefreshToken: creds.RefreshToken})), nil
+	}
+	return nil, fmt.Errorf("credentials '%s' have unsupported type '%s'", file, creds.Type)
+}
@@ -0,0 +1,328 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// isSFTPURL reports whether filename is an sftp:// URL of a file on a host
+// reached over SSH
+func isSFTPURL(filename string) bool {
+	u, err := url.Parse(filename)
+	return err == nil && u.Scheme == "sftp" && u.Host != "" && u.Path != ""
+}
+
+// sftpPasswords holds the passwords typed for each user@host, so that
+// reconnecting and opening the same host again doesn't ask again
+var sftpPasswords = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// sftpConn is an SFTP session on an SSH connection
+type sftpConn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+func (c *sftpConn) Close() error {
+	c.sftp.Close()
+	return c.ssh.Close()
+}
+
+// dialSFTP connects to the host of an sftp:// URL, authenticating with
+// ssh-agent, -sftpIdentity or the default keys in ~/.ssh, and finally a
+// password typed at the terminal. The host key is checked against
+// ~/.ssh/known_hosts unless -sftpInsecureIgnoreHostKey is given.
+func dialSFTP(u *url.URL) (*sftpConn, error) {
+	username := u.User.Username()
+	if username == "" {
+		if current, err := user.Current(); err == nil {
+			username = current.Username
+		}
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	hostKeys, err := sftpHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	var auth []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			defer conn.Close()
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	signers, err := sftpIdentities()
+	if err != nil {
+		return nil, err
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	key := username + "@" + host
+	password := func() (string, error) {
+		if p, ok := u.User.Password(); ok {
+			return p, nil
+		}
+		sftpPasswords.Lock()
+		defer sftpPasswords.Unlock()
+		if p, ok := sftpPasswords.m[key]; ok {
+			return p, nil
+		}
+		p, err := readSecret(fmt.Sprintf("Password for %s: ", key))
+		if err != nil {
+			return "", err
+		}
+		sftpPasswords.m[key] = p
+		return p, nil
+	}
+	auth = append(auth, ssh.PasswordCallback(password))
+
+	client, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return nil, fmt.Errorf("error connecting to %s: the host isn't in known_hosts. Connect with ssh once to check and add its key", host)
+			}
+			return nil, fmt.Errorf("error connecting to %s: the host key doesn't match the one in known_hosts", host)
+		}
+		return nil, fmt.Errorf("error connecting to %s: %s", host, err)
+	}
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error starting SFTP on %s: %s", host, err)
+	}
+	return &sftpConn{client, sc}, nil
+}
+
+// sftpHostKeyCallback checks host keys against ~/.ssh/known_hosts
+func sftpHostKeyCallback() (ssh.HostKeyCallback, error) {
+	if *sftpInsecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join(home, ".ssh", "known_hosts")
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s. Host keys can't be checked without it", file, err)
+	}
+	return callback, nil
+}
+
+// sftpIdentities loads the private key of -sftpIdentity, asking for its
+// passphrase if it has one, or else the unencrypted default keys in ~/.ssh
+func sftpIdentities() ([]ssh.Signer, error) {
+	if *sftpIdentity != "" {
+		data, err := ioutil.ReadFile(*sftpIdentity)
+		if err != nil {
+			return nil, fmt.Errorf("error reading -sftpIdentity: %s", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			var passphrase string
+			passphrase, err = readSecret(fmt.Sprintf("Passphrase for %s: ", *sftpIdentity))
+			if err != nil {
+				return nil, err
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading -sftpIdentity '%s': %s", *sftpIdentity, err)
+		}
+		return []ssh.Signer{signer}, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := ioutil.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		// keys with a passphrase are left to ssh-agent
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	return signers, nil
+}
+
+// readSecret asks for a password on the terminal without echoing it
+func readSecret(prompt string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", errors.New("a password is needed, but stdin isn't a terminal to ask for it on. Use ssh-agent or -sftpIdentity")
+	}
+	fmt.Fprint(promptOutput, prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(promptOutput)
+	if err != nil {
+		return "", fmt.Errorf("error reading the password: %s", err)
+	}
+	return string(secret), nil
+}
+
+// openSFTP opens a file named by an sftp:// URL
+func openSFTP(source string) (io.ReadCloser, int64, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid SFTP URL '%s': %s", source, err)
+	}
+	// a password in the URL isn't repeated in messages
+	r := &sftpReader{source: u.Redacted(), url: u}
+	if err := r.open(); err != nil {
+		return nil, 0, err
+	}
+	fi, err := r.file.Stat()
+	if err != nil {
+		r.Close()
+		return nil, 0, fmt.Errorf("error opening %s: %s", r.source, err)
+	}
+	if fi.IsDir() {
+		r.Close()
+		return nil, 0, fmt.Errorf("error opening %s: it is a directory", r.source)
+	}
+	r.size = fi.Size()
+	return r, r.size, nil
+}
+
+// checkSFTPAccess connects to the host of an sftp:// URL and checks that the
+// file exists, so that problems with the login are found before anything is
+// sent to Youtube
+func checkSFTPAccess(source string) error {
+	r, _, err := openSFTP(source)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+// sftpReader reads a file over SFTP, reconnecting and continuing where it
+// left off if the connection fails
+type sftpReader struct {
+	source string
+	url    *url.URL
+	conn   *sftpConn
+	file   *sftp.File
+	offset int64
+	size   int64
+	// reconnects is the number of times the download was continued
+	reconnects int
+	// warnings are those of the upload the source is read for
+	warnings *warningList
+}
+
+// open connects and opens the file at the current offset
+func (r *sftpReader) open() error {
+	conn, err := dialSFTP(r.url)
+	if err != nil {
+		return err
+	}
+	file, err := conn.sftp.Open(r.url.Path)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error opening %s: %s", r.source, err)
+	}
+	if r.offset > 0 {
+		if _, err := file.Seek(r.offset, io.SeekStart); err != nil {
+			file.Close()
+			conn.Close()
+			return fmt.Errorf("error continuing %s at byte %d: %s", r.source, r.offset, err)
+		}
+	}
+	r.conn, r.file = conn, file
+	return nil
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	if rerr := r.reconnect(err); rerr != nil {
+		return n, rerr
+	}
+	return n, nil
+}
+
+// reconnect continues the download at the current offset after err
+func (r *sftpReader) reconnect(err error) error {
+	r.Close()
+	for attempt := 1; ; attempt++ {
+		if attempt > *sourceRetries {
+			return fmt.Errorf("error reading %s at byte %d, giving up after %d reconnects: %s", r.source, r.offset, *sourceRetries, err)
+		}
+		r.warnings.warnf("Error reading %s at byte %d, reconnecting: %s", r.source, r.offset, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+		if err = r.open(); err == nil {
+			r.reconnects++
+			return nil
+		}
+	}
+}
+
+func (r *sftpReader) Close() error {
+	if r.file != nil {
+		r.file.Close()
+	}
+	return r.conn.Close()
+}
+
+// warnInsecureHostKey warns loudly on stderr, whatever the output mode, that
+// -sftpInsecureIgnoreHostKey is in use
+func warnInsecureHostKey() {
+	line := strings.Repeat("!", 72)
+	fmt.Fprintf(os.Stderr, "%s\nWARNING: -sftpInsecureIgnoreHostKey is set, so SFTP host keys are NOT checked.\nAnyone able to intercept the connection can read the password and\nreplace the video being uploaded.\n%s\n", line, line)
+}
@@ -0,0 +1,93 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// maxShortsDuration is the longest video -shorts accepts
+const maxShortsDuration = 60 * time.Second
+
+// shortsHashtag marks a video as a Short for -shortsTag
+const shortsHashtag = "#Shorts"
+
+// checkShorts checks for -shorts that the video opened from filename is
+// short enough and not landscape, reading its container's headers. Only
+// local files can be probed; for other sources and files that can't be
+// parsed there is a warning.
+func checkShorts(ctx context.Context, filename string, reader io.Reader) error {
+	if !*shorts {
+		return nil
+	}
+	file, ok := reader.(*os.File)
+	if !ok {
+		warningsOf(ctx).warnf("Can't check that '%s' suits a Short, as only local files are probed", filename)
+		return nil
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error stat'ing %s: %s", filename, err)
+	}
+	info, err := probeVideo(file, fi.Size())
+	if err != nil {
+		warningsOf(ctx).warnf("Can't check that '%s' suits a Short: %s", filename, err)
+		return nil
+	}
+
+	var problems []string
+	if info.Duration > maxShortsDuration {
+		problems = append(problems, fmt.Sprintf("it is %s long, over %s", info.Duration.Round(time.Second/10), maxShortsDuration))
+	}
+	if info.Width > info.Height {
+		problems = append(problems, fmt.Sprintf("it is %dx%d, which is landscape rather than vertical or square", info.Width, info.Height))
+	}
+	if info.Width == 0 {
+		warningsOf(ctx).warnf("Can't check the aspect ratio of '%s' for a Short: no video track found", filename)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("'%s' won't be a Short: %s", filename, strings.Join(problems, ", and "))
+	}
+	return nil
+}
+
+// addShortsTag adds #Shorts to the end of the title for -shortsTag, or to
+// the description if the title has no room, unless either already has it
+func addShortsTag(snippet *youtube.VideoSnippet) {
+	if !*shortsTag {
+		return
+	}
+	lower := strings.ToLower(shortsHashtag)
+	if strings.Contains(strings.ToLower(snippet.Title), lower) || strings.Contains(strings.ToLower(snippet.Description), lower) {
+		return
+	}
+	if utf8.RuneCountInString(snippet.Title)+1+len(shortsHashtag) <= maxTitleChars {
+		snippet.Title = strings.TrimSpace(snippet.Title + " " + shortsHashtag)
+		return
+	}
+	if snippet.Description == "" {
+		snippet.Description = shortsHashtag
+	} else {
+		snippet.Description += "\n\n" + shortsHashtag
+	}
+}
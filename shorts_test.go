@@ -0,0 +1,72 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckShorts(t *testing.T) {
+	defer func(old bool) { *shorts = old }(*shorts)
+	*shorts = true
+
+	tests := []struct {
+		file string
+		// problem is part of the error, "" if the file suits a Short
+		problem string
+	}{
+		{"portrait.mp4", ""},
+		// recorded on a phone held upright: a landscape picture rotated 90
+		// degrees by the track matrix, after an audio track
+		{"rotated.mov", ""},
+		{"square.mkv", ""},
+		{"landscape.mp4", "1920x1080, which is landscape"},
+		{"landscape.webm", "1m15s long, over 1m0s, and it is 1280x720"},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			f, err := os.Open("testdata/" + test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			err = checkShorts(context.Background(), test.file, f)
+			switch {
+			case test.problem == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.problem != "" && err == nil:
+				t.Errorf("no error, want one about %q", test.problem)
+			case test.problem != "" && !strings.Contains(err.Error(), test.problem):
+				t.Errorf("error %q doesn't mention %q", err, test.problem)
+			}
+		})
+	}
+}
+
+func TestProbeRotated(t *testing.T) {
+	// version 1 mvhd and tkhd, with 64-bit times
+	info := probeFixture(t, "rotated.mov")
+	if info.Duration != 15*time.Second || info.Width != 1080 || info.Height != 1920 {
+		t.Errorf("got %s %dx%d, want 15s 1080x1920", info.Duration, info.Width, info.Height)
+	}
+	info = probeFixture(t, "portrait.mp4")
+	if info.Duration != 15*time.Second || info.Width != 1080 || info.Height != 1920 {
+		t.Errorf("got %s %dx%d, want 15s 1080x1920", info.Duration, info.Width, info.Height)
+	}
+}
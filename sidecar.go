@@ -0,0 +1,83 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// file extensions looked for by -sidecar, in order of preference
+var (
+	sidecarMetaExts      = []string{".json", ".yaml", ".yml"}
+	sidecarThumbnailExts = []string{".jpg", ".jpeg", ".png"}
+	sidecarCaptionExts   = []string{".srt", ".vtt", ".sbv"}
+)
+
+// findSidecar returns the first file next to filename with the same base
+// name and one of the extensions, or "" if there is none
+func findSidecar(filename string, exts []string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	for _, ext := range exts {
+		path := base + ext
+		if path == filename {
+			continue
+		}
+		if fileInfo, err := os.Stat(path); err == nil && fileInfo.Mode().IsRegular() {
+			return path
+		}
+	}
+	return ""
+}
+
+// findSidecars applies the metadata, thumbnail and caption files found next
+// to a local video file for -sidecar. Metadata from the flags takes
+// precedence: -metaJSON, -thumbnail and -caption disable the respective
+// sidecar.
+func (job *uploadJob) findSidecars() error {
+	if job.filename == "-" || isURL(job.filename) {
+		return nil
+	}
+
+	if *metaJSON == "" {
+		if path := findSidecar(job.filename, sidecarMetaExts); path != "" {
+			upload, videoMeta, templates, err := loadVideo(path)
+			if err != nil {
+				return err
+			}
+			job.upload, job.videoMeta, job.templates = upload, videoMeta, templates
+			job.sidecars = append(job.sidecars, path)
+		}
+	}
+
+	if *thumbnail == "" && job.videoMeta.Thumbnail == "" {
+		if path := findSidecar(job.filename, sidecarThumbnailExts); path != "" {
+			job.videoMeta.Thumbnail = path
+			job.sidecars = append(job.sidecars, path)
+		}
+	}
+
+	if len(captions) == 0 && len(job.videoMeta.Captions) == 0 {
+		if path := findSidecar(job.filename, sidecarCaptionExts); path != "" {
+			job.videoMeta.Captions = []Caption{{Language: job.upload.Snippet.DefaultLanguage, File: path}}
+			if job.videoMeta.Captions[0].Language == "" {
+				job.videoMeta.Captions[0].Language = *language
+			}
+			job.sidecars = append(job.sidecars, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+var (
+	errInterrupted = errors.New("upload interrupted")
+	errTimedOut    = errors.New("upload timed out")
+)
+
+// interrupted is set to 1 by the first SIGINT or SIGTERM, so that nothing
+// more is started after the upload in progress stops
+var interrupted int32
+
+// interruptContext returns a context that is cancelled by the first SIGINT
+// or SIGTERM, so that the upload in progress can be stopped cleanly. A second
+// signal exits immediately.
+func interruptContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigChan:
+		case <-ctx.Done():
+			signal.Stop(sigChan)
+			return
+		}
+		fmt.Fprintln(promptOutput, "\nInterrupted, stopping. Interrupt again to quit immediately")
+		atomic.StoreInt32(&interrupted, 1)
+		cancel()
+		<-sigChan
+		os.Exit(130)
+	}()
+	return ctx, cancel
+}
+
+// watchRateFile changes limit to the value in path each time SIGHUP is
+// received, until ctx is done
+func watchRateFile(ctx context.Context, path string, limit *uploader.Limit) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sigChan)
+		for {
+			select {
+			case <-sigChan:
+			case <-ctx.Done():
+				return
+			}
+			kbps, err := readRateFile(path)
+			if err != nil {
+				warnf("Error reading -ratelimitFile: %v", err)
+				continue
+			}
+			old := limit.Set(kbps)
+			fmt.Fprintf(output, "\n%s: rate limit changed from %s to %s\n", time.Now().Format(time.RFC3339), describeLimit(old), describeLimit(kbps))
+		}
+	}()
+}
+
+// readRateFile reads a rate limit from path, in kbps or with a unit like
+// -ratelimit
+func readRateFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	kbps, err := parseRate(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("'%s' must contain a rate limit, e.g. 2500 (kbps) or 20Mbps: %v", path, err)
+	}
+	return kbps, nil
+}
+
+// stopReason describes why ctx was cancelled: interrupted by a signal, or
+// timed out by -timeout
+func stopReason(ctx context.Context) (string, error) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "timed out", withCode(errCodeTimeout, errTimedOut)
+	}
+	return "interrupted", withCode(errCodeUpload, errInterrupted)
+}
+
+// resumeCommand returns the command line to continue an interrupted upload
+func resumeCommand() string {
+	args := []string{os.Args[0]}
+	hasResume := false
+	for _, arg := range os.Args[1:] {
+		if arg == "-resume" || arg == "--resume" || strings.HasPrefix(arg, "-resume=") {
+			hasResume = true
+		}
+		if arg == "" || strings.ContainsAny(arg, " \t\"'$\\") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		args = append(args, arg)
+	}
+	if !hasResume {
+		args = append(args[:1], append([]string{"-resume"}, args[1:]...)...)
+	}
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,154 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+// etaSeedPeriod is how long into a transfer the -speedtest rate is used for
+// the ETA, before the measured average has settled
+const etaSeedPeriod = 30 * time.Second
+
+// errSpeedtestDeclined is returned for an upload that was stopped after
+// -speedtest because the user didn't want to go ahead
+var errSpeedtestDeclined = errors.New("upload cancelled after the speed test")
+
+// speedTest is -speedtest. Rather than sending data that is thrown away, the
+// rate is measured over the first -speedtestSize bytes of the first upload,
+// which is then paused while the user decides whether to carry on.
+var speedTest struct {
+	once sync.Once
+	// rate is the measured rate in bytes per second, 0 until it is known
+	rate int64
+}
+
+// startSpeedtest measures the rate of the upload of worker, of a file of
+// filesize bytes, once -speedtestSize bytes have been sent, prints how long
+// the whole file would take and asks whether to go ahead. Only the first
+// upload is measured. cancel is called if the user says no, and the returned
+// function reports whether they did; it must be called once the upload is
+// over, which stops the measurement if it hasn't finished.
+func startSpeedtest(worker *uploadWorker, filesize int64, cancel func()) func() bool {
+	first := false
+	speedTest.once.Do(func() { first = true })
+	if !*speedtest || !first {
+		return func() bool { return false }
+	}
+
+	var declined int32
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		var start time.Time
+		var startBytes int64
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			sent := worker.progress().Sent
+			if start.IsZero() {
+				// the time to set up the upload isn't counted
+				if sent > 0 {
+					start, startBytes = time.Now(), sent
+				}
+				continue
+			}
+			if sent-startBytes < int64(speedtestSize) {
+				continue
+			}
+			elapsed := time.Since(start)
+			rate := int64(float64(sent-startBytes) / elapsed.Seconds())
+			atomic.StoreInt64(&speedTest.rate, rate)
+			if !confirmSpeed(worker, rate, sent, filesize) {
+				atomic.StoreInt32(&declined, 1)
+				cancel()
+			}
+			return
+		}
+	}()
+	return func() bool {
+		close(done)
+		<-finished
+		return atomic.LoadInt32(&declined) != 0
+	}
+}
+
+// confirmSpeed prints the projected duration of the rest of the upload on
+// worker at the measured rate, and at -ratelimit if that is lower, and
+// asks whether to go ahead. The uploads are paused while waiting for the
+// answer. With -yes, or without a terminal to answer on, the upload carries
+// on.
+func confirmSpeed(worker *uploadWorker, rate, sent, filesize int64) bool {
+	measured := int(rate * 8 / 1000)
+	if measured == 0 {
+		// describeLimit calls 0 unlimited
+		measured = 1
+	}
+	fmt.Fprintf(promptOutput, "\nSpeed test: %s over the first %d bytes\n", describeLimit(measured), sent)
+	if filesize > sent {
+		left := filesize - sent
+		fmt.Fprintf(promptOutput, "The other %d bytes of the file would take %s\n", left, projectDuration(left, rate))
+		if kbps := worker.transport.Limit().Get(); kbps > 0 && int64(kbps)*125 < rate {
+			fmt.Fprintf(promptOutput, "At the -ratelimit of %s they would take %s\n", describeLimit(kbps), projectDuration(left, int64(kbps)*125))
+		}
+	}
+	if *assumeYes || !isTerminal(os.Stdin) {
+		return true
+	}
+
+	uploadPause.Pause()
+	defer uploadPause.Resume()
+	fmt.Fprintf(promptOutput, "Carry on uploading? [Y/n] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "n", "no":
+		return false
+	}
+	return true
+}
+
+// projectDuration returns how long n bytes take at rate bytes per second
+func projectDuration(n, rate int64) time.Duration {
+	return (time.Duration(float64(n)/float64(rate)) * time.Second).Round(time.Second)
+}
+
+// etaRate returns the rate to estimate the time left of a transfer with, in
+// bytes per second. The average is wild for the first seconds, so the
+// -speedtest rate is used until it has settled.
+func etaRate(p uploader.Progress) int64 {
+	if measured := atomic.LoadInt64(&speedTest.rate); measured > 0 && p.Duration < etaSeedPeriod {
+		return measured
+	}
+	return p.AvgRate
+}
@@ -0,0 +1,117 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+// transferStats are the statistics of the transfer of one or more files,
+// for comparing the performance of uploads
+type transferStats struct {
+	// BytesSent includes chunks which were sent again after failing
+	BytesSent int64 `json:"bytesSent"`
+	// PayloadBytes is the number of bytes of the video acknowledged by
+	// Youtube
+	PayloadBytes int64   `json:"payloadBytes"`
+	PeakRate     float64 `json:"peakBytesPerSecond"`
+	Chunks       int     `json:"chunks"`
+	Retries      int     `json:"retries"`
+	Stalls       int     `json:"stalls"`
+	// TransferSeconds is the time spent sending the video and APIWaitSeconds
+	// the time spent waiting for responses from the API
+	TransferSeconds float64 `json:"transferSeconds"`
+	APIWaitSeconds  float64 `json:"apiWaitSeconds"`
+}
+
+// add accumulates other into s
+func (s *transferStats) add(other *transferStats) {
+	if other == nil {
+		return
+	}
+	s.BytesSent += other.BytesSent
+	s.PayloadBytes += other.PayloadBytes
+	if other.PeakRate > s.PeakRate {
+		s.PeakRate = other.PeakRate
+	}
+	s.Chunks += other.Chunks
+	s.Retries += other.Retries
+	s.Stalls += other.Stalls
+	s.TransferSeconds += other.TransferSeconds
+	s.APIWaitSeconds += other.APIWaitSeconds
+}
+
+// record updates the statistics after a request. Time until the request
+// body was sent counts as transferring, and the rest as waiting for the API.
+func (w *uploadWorker) record(s uploader.Sent) {
+	end := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !s.Media {
+		w.stats.APIWaitSeconds += end.Sub(s.Start).Seconds()
+		return
+	}
+	bodyDone := s.BodyDone
+	if bodyDone.IsZero() {
+		bodyDone = end
+	}
+	w.stats.BytesSent += s.BodySent
+	w.stats.TransferSeconds += bodyDone.Sub(s.Start).Seconds()
+	w.stats.APIWaitSeconds += end.Sub(bodyDone).Seconds()
+
+	switch {
+	case s.Stalled:
+		w.stats.Stalls++
+		w.stats.Retries++
+	case s.Err != nil || s.Response.StatusCode >= 500:
+		w.stats.Retries++
+	default:
+		w.stats.Chunks++
+	}
+}
+
+// takeStats returns the statistics of the upload of the current file
+func (w *uploadWorker) takeStats() *transferStats {
+	p := w.progress()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.PayloadBytes = p.Sent - p.Offset
+	stats.PeakRate = float64(p.PeakRate)
+	return &stats
+}
+
+// printStats prints the statistics of a run which took elapsed
+func printStats(w io.Writer, stats *transferStats, elapsed time.Duration) {
+	var avg float64
+	if elapsed > 0 {
+		avg = float64(stats.PayloadBytes) / elapsed.Seconds()
+	}
+	avgRate, avgUnit := formatRate(int64(avg))
+	peakRate, peakUnit := formatRate(int64(stats.PeakRate))
+	fmt.Fprintf(w, "Sent %d bytes (%d bytes of video) in %s, average %.2f %s, peak %.2f %s\n",
+		stats.BytesSent, stats.PayloadBytes, elapsed.Round(time.Second), avgRate, avgUnit, peakRate, peakUnit)
+	fmt.Fprintf(w, "%d chunk(s), %d retries, %d stall(s); %s transferring, %s waiting for Youtube\n",
+		stats.Chunks, stats.Retries, stats.Stalls,
+		secondsDuration(stats.TransferSeconds), secondsDuration(stats.APIWaitSeconds))
+}
+
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(100 * time.Millisecond)
+}
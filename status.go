@@ -0,0 +1,135 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// bytesSentTotal is the number of bytes of video sent in this run, including
+// chunks that were sent again
+var bytesSentTotal int64
+
+// uploadStatus is the state of one upload in progress, served on /status
+type uploadStatus struct {
+	File      string    `json:"file"`
+	Title     string    `json:"title,omitempty"`
+	BytesSent int64     `json:"bytesSent"`
+	Total     int64     `json:"total,omitempty"`
+	Percent   float64   `json:"percent,omitempty"`
+	Rate      int64     `json:"bytesPerSecond"`
+	ETA       float64   `json:"etaSeconds,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	RateLimit int       `json:"rateLimitKbps,omitempty"`
+
+	// worker is the index of the worker, for labelling metrics
+	worker int
+}
+
+// currentStatus returns the uploads in progress on workers
+func currentStatus(workers []*uploadWorker) []uploadStatus {
+	statuses := []uploadStatus{}
+	for i, w := range workers {
+		p := w.progress()
+		w.mu.Lock()
+		s := uploadStatus{
+			File:      w.filename,
+			Title:     w.title,
+			BytesSent: p.Sent,
+			Total:     w.size,
+			StartedAt: w.started,
+			worker:    i,
+		}
+		w.mu.Unlock()
+		if s.File == "" {
+			continue
+		}
+		if *redactPaths {
+			s.File = filepath.Base(s.File)
+		}
+		if p.Started {
+			s.Rate = p.Rate
+			if avg := etaRate(p); s.Total > 0 && avg > 0 && s.BytesSent < s.Total {
+				s.ETA = float64(s.Total-s.BytesSent) / float64(avg)
+			}
+		}
+		if s.Total > 0 {
+			s.Percent = float64(s.BytesSent) * 100 / float64(s.Total)
+		}
+		s.RateLimit = w.transport.Limit().Get()
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// startStatusServer serves the status of the uploads of workers on
+// -statusAddr while they are in progress. It returns a function that stops
+// the server. Failing to listen is only a warning, as the uploads can go
+// ahead without it.
+func startStatusServer(workers []*uploadWorker) func() {
+	if *statusAddr == "" {
+		return func() {}
+	}
+	listener, err := net.Listen("tcp", *statusAddr)
+	if err != nil {
+		warnf("Error starting status server: %v", err)
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Uploads []uploadStatus `json:"uploads"`
+		}{currentStatus(workers)})
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, currentStatus(workers))
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	fmt.Fprintf(output, "Serving upload status on http://%s/status\n", listener.Addr())
+	return func() { server.Close() }
+}
+
+// writeMetrics writes the upload metrics in the Prometheus text format.
+// Uploads are labelled by worker rather than file, so that file names are
+// never exposed and the number of series stays bounded.
+func writeMetrics(w http.ResponseWriter, statuses []uploadStatus) {
+	fmt.Fprintln(w, "# HELP youtubeuploader_sent_bytes_total Bytes of video sent, including chunks sent again.")
+	fmt.Fprintln(w, "# TYPE youtubeuploader_sent_bytes_total counter")
+	fmt.Fprintf(w, "youtubeuploader_sent_bytes_total %d\n", atomic.LoadInt64(&bytesSentTotal))
+
+	fmt.Fprintln(w, "# HELP youtubeuploader_upload_rate_bytes Current upload rate in bytes per second.")
+	fmt.Fprintln(w, "# TYPE youtubeuploader_upload_rate_bytes gauge")
+	for _, s := range statuses {
+		fmt.Fprintf(w, "youtubeuploader_upload_rate_bytes{worker=\"%d\"} %d\n", s.worker, s.Rate)
+	}
+
+	fmt.Fprintln(w, "# HELP youtubeuploader_upload_progress_ratio Fraction of the file uploaded, if its size is known.")
+	fmt.Fprintln(w, "# TYPE youtubeuploader_upload_progress_ratio gauge")
+	for _, s := range statuses {
+		if s.Total > 0 {
+			fmt.Fprintf(w, "youtubeuploader_upload_progress_ratio{worker=\"%d\"} %g\n", s.worker, s.Percent/100)
+		}
+	}
+}
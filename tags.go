@@ -0,0 +1,59 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// parseTags splits the -tags flag into tags. Tags are comma separated, and
+// may be quoted CSV-style to include commas e.g. '"Let's Play, Episode 1",games'
+func parseTags(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	r := csv.NewReader(strings.NewReader(value))
+	r.TrimLeadingSpace = true
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tags '%s': %s", value, err)
+	}
+	var tags []string
+	for _, record := range records {
+		tags = append(tags, record...)
+	}
+	return cleanTags(tags), nil
+}
+
+// cleanTags trims whitespace from each tag, dropping empty tags and tags
+// that differ from an earlier one only by case
+func cleanTags(tags []string) []string {
+	var cleaned []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, tag)
+	}
+	return cleaned
+}
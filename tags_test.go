@@ -0,0 +1,97 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"a,b,c", []string{"a", "b", "c"}},
+		// quoted tags may contain commas
+		{`"a, b",c , d`, []string{"a, b", "c", "d"}},
+		{`"Let's Play, Episode 1",games`, []string{"Let's Play, Episode 1", "games"}},
+		// whitespace around tags is trimmed, and empty tags dropped
+		{" a , b ,, c,", []string{"a", "b", "c"}},
+		{`" a ",b`, []string{"a", "b"}},
+		// duplicates are dropped regardless of case, keeping the first
+		{"Go,go,GO,golang", []string{"Go", "golang"}},
+		{`"a, b",A, B`, []string{"a, b", "A", "B"}},
+		// a stray quote inside a tag is kept
+		{`12" vinyl,records`, []string{`12" vinyl`, "records"}},
+	}
+	for _, test := range tests {
+		got, err := parseTags(test.in)
+		if err != nil {
+			t.Errorf("parseTags(%q): %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseTags(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestTagsLength(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want int
+	}{
+		{nil, 0},
+		{[]string{"a"}, 1},
+		// separated by commas
+		{[]string{"ab", "cd"}, 5},
+		// tags with spaces are quoted
+		{[]string{"a b", "c"}, 7},
+		{[]string{"héllo"}, 5},
+	}
+	for _, test := range tests {
+		if got := tagsLength(test.tags); got != test.want {
+			t.Errorf("tagsLength(%q) = %d, want %d", test.tags, got, test.want)
+		}
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	video := func(tags ...string) *youtube.Video {
+		v := newVideo()
+		v.Snippet.Title = "title"
+		v.Snippet.Tags = tags
+		return v
+	}
+	if err := validateVideo(video("a", "b"), nil); err != nil {
+		t.Errorf("valid tags: %v", err)
+	}
+	// one tag over the limit, by the quotes around it
+	long := strings.Repeat("x", 249) + " " + strings.Repeat("x", 249)
+	err := validateVideo(video(long), nil)
+	if err == nil || !strings.Contains(err.Error(), "501 characters in total") {
+		t.Errorf("got %v, want the total to be over the limit", err)
+	}
+	err = validateVideo(video("a<b"), nil)
+	if err == nil || !strings.Contains(err.Error(), "'<' or '>'") {
+		t.Errorf("got %v, want '<' to be rejected", err)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// templateData is available to title and description templates
+type templateData struct {
+	// Filename is the base name of the file without its extension
+	Filename string
+	FullPath string
+	Date     string
+	Time     string
+	FileSize int64
+	Env      map[string]string
+	// Index is the 1-based position of the file among those being uploaded,
+	// in -orderBy order, and Total is how many there are
+	Index fileIndex
+	Total int
+}
+
+// fileIndex is the position of a file, zero-padded to -indexWidth digits
+type fileIndex int
+
+func (i fileIndex) String() string {
+	return fmt.Sprintf("%0*d", *indexWidth, int(i))
+}
+
+// videoTemplates holds the parsed title and description templates. A nil
+// template means the field is used as is.
+type videoTemplates struct {
+	title       *template.Template
+	description *template.Template
+}
+
+// parseVideoTemplates parses the title and description of snippet as
+// templates, so that errors are reported before any upload starts
+func parseVideoTemplates(snippet *youtube.VideoSnippet) (*videoTemplates, error) {
+	t := &videoTemplates{}
+	if *noTemplate {
+		return t, nil
+	}
+
+	var err error
+	t.title, err = parseTemplate("title", snippet.Title)
+	if err != nil {
+		return nil, err
+	}
+	t.description, err = parseTemplate("description", snippet.Description)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseTemplate(field, text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	t, err := template.New(field).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template in %s: %s", field, err)
+	}
+	return t, nil
+}
+
+// newTemplateData returns the template data for a file
+func newTemplateData(filename string, filesize int64) templateData {
+	base := filepath.Base(filename)
+	now := time.Now()
+	data := templateData{
+		Filename: strings.TrimSuffix(base, filepath.Ext(base)),
+		FullPath: filename,
+		Date:     now.Format(*templateDate),
+		Time:     now.Format(*templateTime),
+		FileSize: filesize,
+		Env:      make(map[string]string),
+	}
+	if abs, err := filepath.Abs(filename); err == nil && !isURL(filename) && filename != "-" {
+		data.FullPath = abs
+	}
+	for _, e := range os.Environ() {
+		if i := strings.Index(e, "="); i > 0 {
+			data.Env[e[:i]] = e[i+1:]
+		}
+	}
+	return data
+}
+
+// apply executes the templates, setting the title and description of snippet
+func (t *videoTemplates) apply(snippet *youtube.VideoSnippet, data templateData) error {
+	var err error
+	if t.title != nil {
+		snippet.Title, err = execTemplate(t.title, data)
+		if err != nil {
+			return err
+		}
+	}
+	if t.description != nil {
+		snippet.Description, err = execTemplate(t.description, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execTemplate(t *template.Template, data templateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error expanding template in %s: %s", t.Name(), err)
+	}
+	return buf.String(), nil
+}
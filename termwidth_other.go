@@ -0,0 +1,30 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "os"
+
+// consoleWidth returns 0, as the width of terminals isn't known on this
+// platform. COLUMNS is used instead if it is set.
+func consoleWidth(f *os.File) int {
+	return 0
+}
+
+// enableColor reports whether ANSI colours can be written to the terminal f
+func enableColor(f *os.File) bool {
+	return true
+}
@@ -0,0 +1,60 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetConsoleScreenBufferInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleScreenBufferInfo")
+	procSetConsoleMode             = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+)
+
+// ENABLE_VIRTUAL_TERMINAL_PROCESSING
+const enableVirtualTerminalProcessing = 0x4
+
+// consoleWidth returns the number of columns of the console window f is
+// attached to, or 0 if it isn't known
+func consoleWidth(f *os.File) int {
+	// CONSOLE_SCREEN_BUFFER_INFO
+	var info struct {
+		size, cursorPosition                             struct{ x, y int16 }
+		attributes                                       uint16
+		windowLeft, windowTop, windowRight, windowBottom int16
+		maximumWindowSize                                struct{ x, y int16 }
+	}
+	r, _, _ := procGetConsoleScreenBufferInfo.Call(f.Fd(), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 0
+	}
+	return int(info.windowRight-info.windowLeft) + 1
+}
+
+// enableColor turns on ANSI escape sequences in the console f, reporting
+// whether it could. Consoles before Windows 10 don't support them.
+func enableColor(f *os.File) bool {
+	var mode uint32
+	if err := syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode); err != nil {
+		return false
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+	r, _, _ := procSetConsoleMode.Call(f.Fd(), uintptr(mode|enableVirtualTerminalProcessing))
+	return r != 0
+}
@@ -0,0 +1,291 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+)
+
+// requestTiming is how long one request to Youtube took for -timing
+type requestTiming struct {
+	File string `json:"file,omitempty"`
+	// Request is "chunk" for the video data, or the API method
+	Request string `json:"request"`
+	Bytes   int64  `json:"bytes"`
+	// TransferSeconds is the time taken to send the request body and
+	// LatencySeconds the time from then until the response arrived
+	TransferSeconds float64 `json:"transferSeconds"`
+	LatencySeconds  float64 `json:"latencySeconds"`
+	Status          int     `json:"status,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	// Connection is set when the request opened a new connection
+	Connection *connTiming `json:"connection,omitempty"`
+}
+
+// connTiming is how long it took to set up a connection
+type connTiming struct {
+	Host           string  `json:"host"`
+	DNSSeconds     float64 `json:"dnsSeconds"`
+	ConnectSeconds float64 `json:"connectSeconds"`
+	TLSSeconds     float64 `json:"tlsSeconds"`
+}
+
+// percentiles summarise a set of durations, in seconds
+type percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// timingReport is the -timing output of the upload of a file in JSON output
+// mode
+type timingReport struct {
+	Requests    []requestTiming         `json:"requests"`
+	Percentiles map[string]*percentiles `json:"percentiles,omitempty"`
+}
+
+// timingLog collects the timing of every request to Youtube for -timing
+var timingLog struct {
+	sync.Mutex
+	requests []requestTiming
+}
+
+// traceConnection adds a trace to r which times the setup of a new
+// connection, returning the request to send and a function returning the
+// timing once the response has arrived, or nil if a connection was reused
+func traceConnection(r *http.Request) (*http.Request, func() *connTiming) {
+	var mu sync.Mutex
+	var dnsStart, connectStart, tlsStart time.Time
+	var conn connTiming
+	reused := true
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			conn.DNSSeconds = time.Since(dnsStart).Seconds()
+			mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			connectStart = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			mu.Lock()
+			conn.ConnectSeconds = time.Since(connectStart).Seconds()
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			conn.TLSSeconds = time.Since(tlsStart).Seconds()
+			mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			reused = info.Reused
+			mu.Unlock()
+		},
+	}
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+	return r, func() *connTiming {
+		mu.Lock()
+		defer mu.Unlock()
+		if reused {
+			return nil
+		}
+		c := conn
+		c.Host = r.URL.Host
+		return &c
+	}
+}
+
+// recordTiming adds the timing of the request s to the -timing log
+func (w *uploadWorker) recordTiming(s uploader.Sent, conn *connTiming) {
+	end := time.Now()
+	r := s.Request
+	entry := requestTiming{Request: apiMethod(r), Connection: conn}
+	if entry.Request == "" {
+		entry.Request = r.URL.Host + r.URL.Path
+	}
+	// the small bodies of API calls are sent at once, so their time is
+	// counted as waiting for the response, as in the transfer statistics
+	bodyDone := s.Start
+	if s.Media {
+		bodyDone = end
+		entry.Request = "chunk"
+		entry.Bytes = s.BodySent
+		if !s.BodyDone.IsZero() {
+			bodyDone = s.BodyDone
+		}
+	} else if r.ContentLength > 0 {
+		entry.Bytes = r.ContentLength
+	}
+	entry.TransferSeconds = bodyDone.Sub(s.Start).Seconds()
+	entry.LatencySeconds = end.Sub(bodyDone).Seconds()
+	if s.Err != nil {
+		entry.Error = s.Err.Error()
+	} else {
+		entry.Status = s.Response.StatusCode
+	}
+	entry.File, _ = w.current()
+
+	timingLog.Lock()
+	timingLog.requests = append(timingLog.requests, entry)
+	timingLog.Unlock()
+}
+
+// timingFor returns the -timing report of the requests made uploading file,
+// or nil without -timing
+func timingFor(file string) *timingReport {
+	if !*timing {
+		return nil
+	}
+	report := &timingReport{Requests: []requestTiming{}}
+	timingLog.Lock()
+	for _, r := range timingLog.requests {
+		if r.File == file {
+			report.Requests = append(report.Requests, r)
+		}
+	}
+	timingLog.Unlock()
+	report.Percentiles = timingPercentiles(report.Requests)
+	return report
+}
+
+// timingPercentiles summarises the time taken to send the chunks, and the
+// time waiting for the responses to them and to the other requests
+func timingPercentiles(requests []requestTiming) map[string]*percentiles {
+	var transfer, latency, api []float64
+	for _, r := range requests {
+		if r.Error != "" {
+			continue
+		}
+		if r.Request == "chunk" {
+			transfer = append(transfer, r.TransferSeconds)
+			latency = append(latency, r.LatencySeconds)
+		} else {
+			api = append(api, r.LatencySeconds)
+		}
+	}
+	p := make(map[string]*percentiles)
+	if len(transfer) > 0 {
+		p["chunkTransfer"] = newPercentiles(transfer)
+		p["chunkLatency"] = newPercentiles(latency)
+	}
+	if len(api) > 0 {
+		p["apiLatency"] = newPercentiles(api)
+	}
+	return p
+}
+
+func newPercentiles(values []float64) *percentiles {
+	sort.Float64s(values)
+	at := func(p float64) float64 {
+		// nearest rank
+		i := int(p*float64(len(values))+0.999999) - 1
+		if i < 0 {
+			i = 0
+		}
+		return values[i]
+	}
+	return &percentiles{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: values[len(values)-1]}
+}
+
+func (p *percentiles) String() string {
+	return fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s",
+		timingDuration(p.P50), timingDuration(p.P90), timingDuration(p.P99), timingDuration(p.Max))
+}
+
+func timingDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
+
+// printTiming prints the table of -timing, with the connections that were
+// set up and the percentiles
+func printTiming(w io.Writer) {
+	if !*timing {
+		return
+	}
+	timingLog.Lock()
+	requests := append([]requestTiming{}, timingLog.requests...)
+	timingLog.Unlock()
+	if len(requests) == 0 {
+		return
+	}
+
+	files := make(map[string]bool)
+	for _, r := range requests {
+		files[r.File] = true
+	}
+	fmt.Fprintf(w, "\nRequest timing:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "  #\tRequest\tBytes\tTransfer\tLatency\tStatus")
+	if len(files) > 1 {
+		fmt.Fprintf(tw, "\tFile")
+	}
+	fmt.Fprintf(tw, "\n")
+	var conns []string
+	for i, r := range requests {
+		status := fmt.Sprint(r.Status)
+		if r.Error != "" {
+			status = "error"
+		}
+		fmt.Fprintf(tw, "  %d\t%s\t%d\t%s\t%s\t%s", i+1, r.Request, r.Bytes,
+			timingDuration(r.TransferSeconds), timingDuration(r.LatencySeconds), status)
+		if len(files) > 1 {
+			fmt.Fprintf(tw, "\t%s", r.File)
+		}
+		fmt.Fprintf(tw, "\n")
+		if c := r.Connection; c != nil {
+			conns = append(conns, fmt.Sprintf("  #%d %s: DNS %s, connect %s, TLS %s", i+1, c.Host,
+				timingDuration(c.DNSSeconds), timingDuration(c.ConnectSeconds), timingDuration(c.TLSSeconds)))
+		}
+	}
+	tw.Flush()
+	if len(conns) > 0 {
+		fmt.Fprintf(w, "New connections:\n%s\n", strings.Join(conns, "\n"))
+	}
+	p := timingPercentiles(requests)
+	for _, k := range []struct{ key, name string }{
+		{"chunkTransfer", "Chunk transfer"},
+		{"chunkLatency", "Chunk response latency"},
+		{"apiLatency", "API response latency"},
+	} {
+		if p[k.key] != nil {
+			fmt.Fprintf(w, "%s: %s\n", k.name, p[k.key])
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// fallbackTitle is used when no title is given and none can be derived
+// from the file name, e.g. when reading from stdin
+const fallbackTitle = "Video Title"
+
+// titleFromFilename derives a video title from the name of the source file
+// or the last path segment of a URL: the extension is removed, underscores
+// and dashes become spaces, and the result is shortened to Youtube's limit at
+// a word boundary.
+func titleFromFilename(filename string) string {
+	base := filepath.Base(filename)
+	if isURL(filename) {
+		if u, err := url.Parse(filename); err == nil {
+			base = path.Base(u.Path)
+		}
+	}
+	if filename == "-" || base == "/" || base == "." {
+		return fallbackTitle
+	}
+	base = strings.TrimSuffix(base, path.Ext(base))
+
+	base = strings.NewReplacer("_", " ", "-", " ", "<", "", ">", "").Replace(base)
+	words := strings.Fields(base)
+	if *titleCase {
+		for i, w := range words {
+			r, n := utf8.DecodeRuneInString(w)
+			words[i] = string(unicode.ToUpper(r)) + w[n:]
+		}
+	}
+
+	var title string
+	for _, w := range words {
+		next := w
+		if title != "" {
+			next = title + " " + w
+		}
+		if utf8.RuneCountInString(next) > maxTitleChars {
+			if title == "" {
+				// a single word longer than the limit
+				title = string([]rune(w)[:maxTitleChars])
+			}
+			break
+		}
+		title = next
+	}
+
+	if title == "" {
+		return fallbackTitle
+	}
+	return title
+}
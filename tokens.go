@@ -0,0 +1,120 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// refreshingTokenSource hands out access tokens for the requests to Youtube.
+// The oauth2 package only refreshes a token 10 seconds before it expires,
+// which a chunk of a slow upload can easily outlast, so the token is
+// refreshed once less than -tokenRefreshMargin of it is left. A token that
+// Youtube rejects can also be refreshed early, by invalidate.
+type refreshingTokenSource struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+	// fetch gets a new token from the authorisation server, without caching
+	fetch  func() (*oauth2.Token, error)
+	margin time.Duration
+}
+
+func newRefreshingTokenSource(token *oauth2.Token, fetch func() (*oauth2.Token, error)) *refreshingTokenSource {
+	return &refreshingTokenSource{token: token, fetch: fetch, margin: *tokenMargin}
+}
+
+// Token returns the current token, refreshing it first if it is close to
+// expiring
+func (s *refreshingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && s.token.AccessToken != "" &&
+		(s.token.Expiry.IsZero() || time.Until(s.token.Expiry) > s.margin) {
+		return s.token, nil
+	}
+	token, err := s.fetch()
+	if err != nil {
+		if s.token != nil && s.token.Valid() {
+			// the old token still works for now
+			warnf("Error refreshing the access token early: %v", err)
+			return s.token, nil
+		}
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// invalidate discards the token whose Authorization header was rejected, so
+// that the next call to Token gets a new one. A token that was already
+// replaced, e.g. by another request that was rejected at the same time, is
+// left alone.
+func (s *refreshingTokenSource) invalidate(authorization string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && authorization == s.token.Type()+" "+s.token.AccessToken {
+		s.token = nil
+	}
+}
+
+// reauthTransport sits between the oauth2 transport and limitTransport. When
+// Youtube rejects a request with 401, e.g. because the token expired during a
+// long chunk, it gets a new token and sends the request once more, so that
+// the upload carries on rather than failing.
+type reauthTransport struct {
+	source *refreshingTokenSource
+	base   http.RoundTripper
+}
+
+func (t *reauthTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if r.Body != nil && r.GetBody == nil {
+		// the body can't be sent again
+		return resp, nil
+	}
+
+	auth := r.Header.Get("Authorization")
+	t.source.invalidate(auth)
+	token, terr := t.source.Token()
+	if terr != nil {
+		warnf("Error refreshing the access token after a 401 response: %v", terr)
+		return resp, nil
+	}
+	if !strings.HasPrefix(auth, token.Type()+" ") || auth == token.Type()+" "+token.AccessToken {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	r2 := r.Clone(r.Context())
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("error sending the request again with a new access token: %s", err)
+		}
+		r2.Body = body
+	}
+	token.SetAuthHeader(r2)
+	fmt.Fprintf(output, "%s: 401 from %s, sending it again with a new access token\n", time.Now().Format(time.RFC3339), r.URL.Path)
+	return t.base.RoundTrip(r2)
+}
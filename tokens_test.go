@@ -0,0 +1,126 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenFetcher hands out numbered tokens, each expiring after lifetime
+type tokenFetcher struct {
+	fetches  int32
+	lifetime time.Duration
+	err      error
+}
+
+func (f *tokenFetcher) fetch() (*oauth2.Token, error) {
+	n := atomic.AddInt32(&f.fetches, 1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &oauth2.Token{
+		AccessToken: "token" + string(rune('0'+n)),
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(f.lifetime),
+	}, nil
+}
+
+func TestRefreshingTokenSource(t *testing.T) {
+	f := &tokenFetcher{lifetime: time.Hour}
+	s := &refreshingTokenSource{fetch: f.fetch, margin: 5 * time.Minute}
+
+	// a token with more than the margin left is kept
+	s.token = &oauth2.Token{AccessToken: "old", Expiry: time.Now().Add(10 * time.Minute)}
+	if tok, err := s.Token(); err != nil || tok.AccessToken != "old" || f.fetches != 0 {
+		t.Fatalf("got %v, %v after %d fetches, want the old token", tok, err, f.fetches)
+	}
+
+	// within the margin it is refreshed, although it is still valid
+	s.token = &oauth2.Token{AccessToken: "old", Expiry: time.Now().Add(time.Minute)}
+	tok, err := s.Token()
+	if err != nil || tok.AccessToken != "token1" {
+		t.Fatalf("got %v, %v, want token1", tok, err)
+	}
+	if tok, _ := s.Token(); tok.AccessToken != "token1" || f.fetches != 1 {
+		t.Errorf("the new token wasn't kept: %v after %d fetches", tok, f.fetches)
+	}
+
+	// if refreshing fails, a token that hasn't expired yet is still used
+	f.err = errors.New("offline")
+	s.token = &oauth2.Token{AccessToken: "old", Expiry: time.Now().Add(time.Minute)}
+	if tok, err := s.Token(); err != nil || tok.AccessToken != "old" {
+		t.Errorf("got %v, %v, want the old token", tok, err)
+	}
+	s.token = &oauth2.Token{AccessToken: "old", Expiry: time.Now().Add(-time.Minute)}
+	if _, err := s.Token(); err == nil {
+		t.Error("no error with an expired token and refreshing failing")
+	}
+}
+
+func TestReauthTransport(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		requests = append(requests, auth)
+		// only token2 is accepted, as if token1 expired during the request
+		if auth != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	f := &tokenFetcher{lifetime: time.Hour}
+	source := &refreshingTokenSource{fetch: f.fetch, margin: time.Minute}
+	client := newOAuthClient(source, http.DefaultTransport)
+
+	res, err := client.Post(srv.URL+"/youtube/v3/videos", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want 200", res.StatusCode)
+	}
+	if len(requests) != 2 || requests[0] != "Bearer token1" || requests[1] != "Bearer token2" {
+		t.Errorf("requests %q, want one with token1 and one retry with token2", requests)
+	}
+
+	// a request that is rejected with the new token too is only sent again
+	// once
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer reject.Close()
+	requests = nil
+	res, err = client.Get(reject.URL + "/youtube/v3/videos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized || len(requests) != 2 {
+		t.Errorf("status %d after %d requests, want 401 after 2", res.StatusCode, len(requests))
+	}
+}
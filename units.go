@@ -0,0 +1,221 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"google.golang.org/api/googleapi"
+)
+
+// parseByteSize parses a size in bytes with an optional binary suffix
+// e.g. '262144', '256K', '8M', '1G'
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	upper := strings.ToUpper(s)
+	for _, suffix := range []string{"IB", "B"} {
+		if strings.HasSuffix(upper, suffix) && len(upper) > len(suffix) {
+			upper = upper[:len(upper)-len(suffix)]
+			break
+		}
+	}
+	if upper != "" {
+		switch upper[len(upper)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			upper = upper[:len(upper)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// chunkSizeFlag is the upload chunk size. The resumable upload protocol
+// requires chunks to be a multiple of 256KiB, so values are rounded up.
+type chunkSizeFlag int
+
+func (c *chunkSizeFlag) String() string {
+	return strconv.Itoa(int(*c))
+}
+
+func (c *chunkSizeFlag) Set(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	// zero means upload in a single request
+	if size != 0 && size < googleapi.MinUploadChunkSize {
+		return fmt.Errorf("chunk size must be at least %d bytes (256K)", googleapi.MinUploadChunkSize)
+	}
+	if rem := size % googleapi.MinUploadChunkSize; rem != 0 {
+		size += googleapi.MinUploadChunkSize - rem
+	}
+	*c = chunkSizeFlag(size)
+	return nil
+}
+
+// byteSizeFlag is a size in bytes e.g. '512K', '8M'
+type byteSizeFlag int64
+
+func (b *byteSizeFlag) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSizeFlag) Set(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return fmt.Errorf("size must be more than 0")
+	}
+	*b = byteSizeFlag(size)
+	return nil
+}
+
+// parseRate parses a rate limit, returning it in kbit/s. A bare number is in
+// kbit/s. Otherwise the unit is in bits, e.g. '20Mbps', '800k', '2 Mbit/s',
+// or bytes, e.g. '2.5MB/s', '300KBps', '1 MiB/s', told apart by the case of
+// the b. The k, M and G prefixes are decimal, and binary with an i.
+func parseRate(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate limit '%s'", s)
+	}
+	bitsPerSec := 1000.0
+	if unit := strings.TrimSpace(s[i:]); unit != "" {
+		var ok bool
+		if bitsPerSec, ok = rateUnit(unit); !ok {
+			return 0, fmt.Errorf("invalid rate limit '%s': unknown unit '%s'", s, unit)
+		}
+	}
+	kbps := math.Round(n * bitsPerSec / 1000)
+	if n > 0 && kbps == 0 {
+		return 0, fmt.Errorf("rate limit '%s' is less than 1 kbps", s)
+	}
+	if kbps > math.MaxInt32 {
+		return 0, fmt.Errorf("rate limit '%s' is too large", s)
+	}
+	return int(kbps), nil
+}
+
+// rateUnit returns the number of bits per second in one of unit
+func rateUnit(unit string) (float64, bool) {
+	multiplier, binary := 1.0, 1.0
+	switch unicode.ToLower(rune(unit[0])) {
+	case 'k':
+		multiplier, binary = 1e3, 1<<10
+	case 'm':
+		multiplier, binary = 1e6, 1<<20
+	case 'g':
+		multiplier, binary = 1e9, 1<<30
+	}
+	if multiplier != 1 {
+		unit = unit[1:]
+		if strings.HasPrefix(unit, "i") || strings.HasPrefix(unit, "I") {
+			multiplier = binary
+			unit = unit[1:]
+		}
+		// a bare prefix is bits, e.g. 800k
+		if unit == "" {
+			return multiplier, true
+		}
+	}
+
+	lower := strings.ToLower(unit)
+	switch {
+	case strings.HasSuffix(lower, "/s"):
+		unit = unit[:len(unit)-2]
+	case strings.HasSuffix(lower, "ps"):
+		unit = unit[:len(unit)-2]
+	}
+	switch strings.ToLower(unit) {
+	case "bit", "bits":
+		return multiplier, true
+	case "byte", "bytes":
+		return multiplier * 8, true
+	}
+	switch unit {
+	case "b":
+		return multiplier, true
+	case "B":
+		return multiplier * 8, true
+	}
+	return 0, false
+}
+
+// rateFlag is a rate limit in kbit/s, which may be given in other units
+type rateFlag int
+
+func (r *rateFlag) String() string {
+	return strconv.Itoa(int(*r))
+}
+
+func (r *rateFlag) Set(value string) error {
+	kbps, err := parseRate(value)
+	if err != nil {
+		return err
+	}
+	*r = rateFlag(kbps)
+	return nil
+}
+
+// describeLimit describes a rate limit in kbit/s in both bits and bytes per
+// second, e.g. '20 Mbps (2.5 MB/s)'
+func describeLimit(kbps int) string {
+	if kbps == 0 {
+		return "unlimited"
+	}
+	bits, bitUnit := float64(kbps), "kbps"
+	if bits >= 1e6 {
+		bits, bitUnit = bits/1e6, "Gbps"
+	} else if bits >= 1e3 {
+		bits, bitUnit = bits/1e3, "Mbps"
+	}
+	bytes, byteUnit := float64(kbps)*125, "B/s"
+	if bytes >= 1e9 {
+		bytes, byteUnit = bytes/1e9, "GB/s"
+	} else if bytes >= 1e6 {
+		bytes, byteUnit = bytes/1e6, "MB/s"
+	} else if bytes >= 1e3 {
+		bytes, byteUnit = bytes/1e3, "kB/s"
+	}
+	return fmt.Sprintf("%s %s (%s %s)", formatAmount(bits), bitUnit, formatAmount(bytes), byteUnit)
+}
+
+// formatAmount formats n with up to 3 decimal places and no trailing zeros
+func formatAmount(n float64) string {
+	return strconv.FormatFloat(math.Round(n*1000)/1000, 'f', -1, 64)
+}
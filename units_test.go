@@ -0,0 +1,138 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"262144", 262144},
+		{"256K", 256 << 10},
+		{"256k", 256 << 10},
+		{"256KB", 256 << 10},
+		{"256KiB", 256 << 10},
+		{"8M", 8 << 20},
+		{"8mb", 8 << 20},
+		{"1G", 1 << 30},
+		{"1.5M", 3 << 19},
+		{" 2M ", 2 << 20},
+		{"0", 0},
+	}
+	for _, test := range tests {
+		got, err := parseByteSize(test.in)
+		if err != nil || got != test.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", test.in, got, err, test.want)
+		}
+	}
+	for _, in := range []string{"", "M", "-1M", "8X", "eight"} {
+		if got, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestChunkSizeFlag(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		// err is set if the value is rejected
+		err bool
+	}{
+		{"256K", 256 << 10, false},
+		{"8M", 8 << 20, false},
+		// rounded up to a multiple of 256KiB
+		{"262145", 512 << 10, false},
+		{"1000K", 1024 << 10, false},
+		{"1.1M", 5 << 18, false},
+		// zero uploads in a single request
+		{"0", 0, false},
+		{"100K", 0, true},
+		{"262143", 0, true},
+		{"big", 0, true},
+	}
+	for _, test := range tests {
+		var c chunkSizeFlag
+		err := c.Set(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("Set(%q): error %v", test.in, err)
+			continue
+		}
+		if !test.err && int(c) != test.want {
+			t.Errorf("Set(%q) = %d, want %d", test.in, c, test.want)
+		}
+		if !test.err && int(c)%(256<<10) != 0 {
+			t.Errorf("Set(%q) = %d, not a multiple of 256KiB", test.in, c)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int // kbit/s
+	}{
+		// a bare number is kbit/s, as it always was
+		{"2500", 2500},
+		{"0", 0},
+		// bits
+		{"800k", 800},
+		{"800K", 800},
+		{"20Mbps", 20000},
+		{"20mbps", 20000},
+		{"20 Mbit/s", 20000},
+		{"20Mb/s", 20000},
+		{"1.5Gbps", 1500000},
+		{"1Mibps", 1049},
+		// bytes
+		{"2.5MB/s", 20000},
+		{"2.5MBps", 20000},
+		{"300KBps", 2400},
+		{"300kB/s", 2400},
+		{"1 MiB/s", 8389},
+		{"1MiBps", 8389},
+		{"125 bytes/s", 1},
+		{"0.5GB/s", 4000000},
+	}
+	for _, test := range tests {
+		got, err := parseRate(test.in)
+		if err != nil || got != test.want {
+			t.Errorf("parseRate(%q) = %d, %v, want %d", test.in, got, err, test.want)
+		}
+	}
+	// less than 1 kbit/s is an error rather than unlimited
+	for _, in := range []string{"", "fast", "20Xbps", "20 MBq", "-5M", "99999999Gbps", "100bps"} {
+		if got, err := parseRate(in); err == nil {
+			t.Errorf("parseRate(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestDescribeLimit(t *testing.T) {
+	tests := map[int]string{
+		0:       "unlimited",
+		800:     "800 kbps (100 kB/s)",
+		20000:   "20 Mbps (2.5 MB/s)",
+		2400:    "2.4 Mbps (300 kB/s)",
+		1500000: "1.5 Gbps (187.5 MB/s)",
+	}
+	for kbps, want := range tests {
+		if got := describeLimit(kbps); got != want {
+			t.Errorf("describeLimit(%d) = %q, want %q", kbps, got, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+var updateParts = []string{"snippet", "status", "recordingDetails"}
+
+// videoParts returns the parts to send for video. Localizations and
+// monetization details are only included when they are set.
+func videoParts(video *youtube.Video) []string {
+	parts := append([]string{}, updateParts...)
+	if len(video.Localizations) > 0 {
+		parts = append(parts, "localizations")
+	}
+	if video.MonetizationDetails != nil {
+		parts = append(parts, "monetizationDetails")
+	}
+	return parts
+}
+
+// updateVideo applies changes to the metadata of an existing video. The
+// update API replaces whole parts, so the current video is fetched first and
+// only the fields set in changes are modified.
+func updateVideo(service *youtube.Service, videoID string, changes *youtube.Video) (*youtube.Video, error) {
+	parts := videoParts(changes)
+	response, err := service.Videos.List(parts).Id(videoID).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving video '%s': %w", videoID, err)
+	}
+	if len(response.Items) == 0 {
+		return nil, fmt.Errorf("video '%s' not found", videoID)
+	}
+	video := response.Items[0]
+
+	if video.Snippet == nil {
+		video.Snippet = &youtube.VideoSnippet{}
+	}
+	if video.Status == nil {
+		video.Status = &youtube.VideoStatus{}
+	}
+	if video.RecordingDetails == nil {
+		video.RecordingDetails = &youtube.VideoRecordingDetails{}
+	}
+	mergeVideo(video, changes)
+
+	// read-only parts and fields must not be sent back
+	update := &youtube.Video{
+		Id:               video.Id,
+		Snippet:          video.Snippet,
+		Status:           video.Status,
+		RecordingDetails: video.RecordingDetails,
+		Localizations:    video.Localizations,
+		// only the access policy can be set, so the rest is left out
+		MonetizationDetails: video.MonetizationDetails,
+	}
+	update.Snippet.Thumbnails = nil
+	update.Status.UploadStatus = ""
+	update.Status.FailureReason = ""
+	update.Status.RejectionReason = ""
+
+	video, err = service.Videos.Update(parts, update).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error updating video '%s': %w", videoID, checkMonetizationError(update, err))
+	}
+	return video, nil
+}
+
+// mergeVideo copies the fields that are set in changes onto video
+func mergeVideo(video, changes *youtube.Video) {
+	if s := changes.Snippet; s != nil {
+		if s.Title != "" {
+			video.Snippet.Title = s.Title
+		}
+		if s.Description != "" {
+			video.Snippet.Description = s.Description
+		}
+		if s.Tags != nil {
+			video.Snippet.Tags = s.Tags
+		}
+		if s.CategoryId != "" {
+			video.Snippet.CategoryId = s.CategoryId
+		}
+		if s.DefaultLanguage != "" {
+			video.Snippet.DefaultLanguage = s.DefaultLanguage
+		}
+		if s.DefaultAudioLanguage != "" {
+			video.Snippet.DefaultAudioLanguage = s.DefaultAudioLanguage
+		}
+	}
+
+	if s := changes.Status; s != nil {
+		if s.PrivacyStatus != "" {
+			video.Status.PrivacyStatus = s.PrivacyStatus
+		}
+		if s.License != "" {
+			video.Status.License = s.License
+		}
+		if s.PublishAt != "" {
+			video.Status.PublishAt = s.PublishAt
+		}
+		setBool(&video.Status.Embeddable, &video.Status.ForceSendFields, "Embeddable", forcedBool(s.Embeddable, s.ForceSendFields, "Embeddable"))
+		setBool(&video.Status.PublicStatsViewable, &video.Status.ForceSendFields, "PublicStatsViewable", forcedBool(s.PublicStatsViewable, s.ForceSendFields, "PublicStatsViewable"))
+		setBool(&video.Status.SelfDeclaredMadeForKids, &video.Status.ForceSendFields, "SelfDeclaredMadeForKids", forcedBool(s.SelfDeclaredMadeForKids, s.ForceSendFields, "SelfDeclaredMadeForKids"))
+	}
+
+	if m := changes.MonetizationDetails; m != nil && m.Access != nil {
+		video.MonetizationDetails = &youtube.VideoMonetizationDetails{Access: &youtube.AccessPolicy{}}
+		setBool(&video.MonetizationDetails.Access.Allowed, &video.MonetizationDetails.Access.ForceSendFields, "Allowed", forcedBool(m.Access.Allowed, m.Access.ForceSendFields, "Allowed"))
+		video.MonetizationDetails.Access.Exception = m.Access.Exception
+	}
+
+	for lang, l := range changes.Localizations {
+		if video.Localizations == nil {
+			video.Localizations = make(map[string]youtube.VideoLocalization)
+		}
+		video.Localizations[lang] = l
+	}
+
+	if r := changes.RecordingDetails; r != nil {
+		if r.Location != nil {
+			video.RecordingDetails.Location = r.Location
+		}
+		if r.LocationDescription != "" {
+			video.RecordingDetails.LocationDescription = r.LocationDescription
+		}
+		if r.RecordingDate != "" {
+			video.RecordingDetails.RecordingDate = r.RecordingDate
+		}
+	}
+}
@@ -0,0 +1,498 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"google.golang.org/api/youtube/v3"
+)
+
+// uploadJob describes a single file to be uploaded
+type uploadJob struct {
+	filename  string
+	upload    *youtube.Video
+	videoMeta VideoMeta
+	templates *videoTemplates
+	// sidecars lists the files found by -sidecar
+	sidecars []string
+	// manifest and manifestLine are the -manifest file and line the job
+	// came from, if it did
+	manifest     string
+	manifestLine int
+	// index is the 1-based position of the job and total the number of
+	// jobs, for templates
+	index, total int
+	// noEnv leaves {{.Env}} empty in templates, for -serve jobs
+	noEnv bool
+}
+
+// video returns the video metadata for the job. The title and description
+// may be templates that differ for each file, and the title defaults to one
+// derived from the file name.
+func (job uploadJob) video(ctx context.Context, filesize int64) (*youtube.Video, error) {
+	upload := *job.upload
+	snippet := *job.upload.Snippet
+	upload.Snippet = &snippet
+	if job.templates != nil {
+		data := newTemplateData(job.filename, filesize)
+		data.Index, data.Total = fileIndex(job.index), job.total
+		if job.noEnv {
+			data.Env = map[string]string{}
+		}
+		err := job.templates.apply(upload.Snippet, data)
+		if err != nil {
+			return nil, err
+		}
+		if *truncate {
+			truncateVideo(&upload, warningsOf(ctx))
+		}
+		if err := validateVideo(&upload, nil); err != nil {
+			return nil, err
+		}
+	}
+	if applyFileMeta(job, &upload) {
+		if *truncate {
+			truncateVideo(&upload, warningsOf(ctx))
+		}
+		if err := validateVideo(&upload, nil); err != nil {
+			return nil, err
+		}
+	}
+	if upload.Snippet.Title == "" || *titleFromFile {
+		upload.Snippet.Title = titleFromFilename(job.filename)
+	}
+	addShortsTag(upload.Snippet)
+	return &upload, nil
+}
+
+// thumbnailFile returns the thumbnail for the job, if there is one
+func (job uploadJob) thumbnailFile() string {
+	if *thumbnail != "" {
+		return *thumbnail
+	}
+	return job.videoMeta.Thumbnail
+}
+
+// captions returns the captions to be inserted for the job
+func (job uploadJob) captions() []Caption {
+	captionList := append([]Caption{}, job.videoMeta.Captions...)
+	for _, c := range captions {
+		captionList = append(captionList, parseCaption(c, *language))
+	}
+	return captionList
+}
+
+// playlistIDs returns the IDs of the playlists the video is added to
+func (job uploadJob) playlistIDs() []string {
+	// PlaylistID is deprecated in favour of PlaylistIDs
+	var ids []string
+	if job.videoMeta.PlaylistID != "" {
+		ids = append(ids, job.videoMeta.PlaylistID)
+	}
+	ids = append(ids, job.videoMeta.PlaylistIDs...)
+	for _, pid := range strings.Split(*playlistID, ",") {
+		if pid = strings.TrimSpace(pid); pid != "" {
+			ids = append(ids, pid)
+		}
+	}
+	return ids
+}
+
+// playlistTitles returns the titles of the playlists the video is added to,
+// those from the meta JSON first
+func (job uploadJob) playlistTitles() []string {
+	return append(append([]string{}, job.videoMeta.PlaylistTitles...), playlists...)
+}
+
+// printVideoSummary prints the merged metadata that will be sent for video.
+// Any of the video's parts may be missing.
+func printVideoSummary(w io.Writer, video *youtube.Video) {
+	if s := video.Snippet; s != nil {
+		fmt.Fprintf(w, "  title: %s\n", s.Title)
+		if s.CategoryId != "" {
+			fmt.Fprintf(w, "  category: %s\n", s.CategoryId)
+		}
+		if len(s.Tags) > 0 {
+			fmt.Fprintf(w, "  tags: %s\n", strings.Join(s.Tags, ", "))
+		}
+		if s.DefaultLanguage != "" {
+			fmt.Fprintf(w, "  language: %s\n", s.DefaultLanguage)
+		}
+	}
+	if s := video.Status; s != nil {
+		fmt.Fprintf(w, "  privacy: %s\n", s.PrivacyStatus)
+		if s.PublishAt != "" {
+			fmt.Fprintf(w, "  publishAt: %s\n", s.PublishAt)
+		}
+		if s.License != "" {
+			fmt.Fprintf(w, "  license: %s\n", s.License)
+		}
+	}
+	if r := video.RecordingDetails; r != nil && r.RecordingDate != "" {
+		fmt.Fprintf(w, "  recordingDate: %s\n", r.RecordingDate)
+	}
+	if m := video.MonetizationDetails; m != nil && m.Access != nil {
+		fmt.Fprintf(w, "  monetization: allowed %t\n", m.Access.Allowed)
+		if len(m.Access.Exception) > 0 {
+			fmt.Fprintf(w, "  monetization excluded in: %s\n", strings.Join(m.Access.Exception, ", "))
+		}
+	}
+}
+
+// uploadFile uploads a single video along with its thumbnail and captions,
+// and adds it to any playlists. If the video was uploaded but a later step
+// failed, the result will contain the video ID and an error is returned.
+func uploadFile(ctx context.Context, w *uploadWorker, job uploadJob) (*uploadResult, error) {
+	service := w.service
+	filename := job.filename
+	videoMeta := job.videoMeta
+	result := &uploadResult{File: filename}
+	warnings := warningsOf(ctx)
+
+	reader, filesize, err := Open(filename)
+	if err != nil {
+		return result, withCode(errCodeSource, err)
+	}
+	defer reader.Close()
+	switch r := reader.(type) {
+	case *rangeReader:
+		r.warnings = warnings
+	case *sftpReader:
+		r.warnings = warnings
+	case *cloudReader:
+		r.warnings = warnings
+	}
+
+	// a source that isn't a video would only be rejected by Youtube after
+	// all of it was sent
+	reader, err = checkVideoSource(filename, reader)
+	if err != nil {
+		return result, withCode(errCodeSource, err)
+	}
+	if err := checkShorts(ctx, filename, reader); err != nil {
+		return result, withCode(errCodeSource, err)
+	}
+
+	upload, err := job.video(ctx, filesize)
+	if err != nil {
+		return result, withCode(errCodeUsage, err)
+	}
+
+	if filesize <= 0 && *filesizeHint > 0 {
+		filesize = *filesizeHint
+	}
+
+	// validate the thumbnail before the video upload starts so that a bad
+	// thumbnail doesn't waste a long transfer
+	var thumbData []byte
+	thumbFile := job.thumbnailFile()
+	if thumbFile != "" {
+		thumbData, err = LoadThumbnail(thumbFile)
+		if err != nil {
+			return result, withCode(errCodeThumbnail, err)
+		}
+	}
+
+	captionList := job.captions()
+	captionReaders := make([]io.ReadCloser, len(captionList))
+	for i, c := range captionList {
+		captionReaders[i], _, err = Open(c.File)
+		if err != nil {
+			return result, withCode(errCodeCaption, err)
+		}
+		defer captionReaders[i].Close()
+	}
+
+	// progress of uploads of local files is saved so that they can be resumed
+	var state *uploadState
+	var resuming bool
+	var offset int64
+	if file, ok := reader.(*os.File); ok {
+		if *resume {
+			state, err = loadUploadState(filename)
+			if err != nil {
+				warnings.warnf("Cannot resume upload, starting again: %s", err)
+			} else {
+				resuming = true
+				offset = state.Offset
+			}
+		}
+		if !resuming {
+			state, err = newUploadState(file.Name())
+			if err != nil {
+				return result, withCode(errCodeSource, err)
+			}
+		}
+	}
+
+	// -checksumPre hashes local files before they are sent, so that a change
+	// while they are being uploaded is noticed
+	var preChecksum string
+	if _, ok := reader.(*os.File); ok && *checksumPre {
+		fmt.Fprintf(output, "Computing the %s checksum of '%s'...\n", *checksumAlg, filename)
+		preChecksum, err = fileChecksum(filename)
+		if err != nil {
+			return result, withCode(errCodeSource, err)
+		}
+	}
+
+	// each file gets its own progress display
+	w.start(filename, upload.Snippet.Title, filesize)
+
+	var quitChan chanChan
+	if progressJSON != nil {
+		quitChan = make(chanChan)
+		go ProgressJSON(quitChan, w, filesize)
+	} else if progressOut != nil && *concurrency <= 1 {
+		quitChan = make(chanChan)
+		go func() {
+			Progress(quitChan, w, filesize)
+		}()
+	}
+
+	fmt.Fprintf(output, "Uploading file '%s' to channel '%s'...\n", filename, channelTitle)
+	if !*quiet {
+		printVideoSummary(output, upload)
+	}
+
+	// with -speedtest, the user can stop the upload once the rate is known
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	declined := startSpeedtest(w, filesize, cancel)
+
+	// the flag wins over the meta JSON, for one-off changes to otherwise
+	// templated uploads
+	notifySubscribers := *notify
+	if videoMeta.NotifySubscribers != nil && !isFlagSet("notifySubscribers") {
+		notifySubscribers = *videoMeta.NotifySubscribers
+	}
+	videoUpload := uploader.VideoUpload{
+		Video:             upload,
+		Parts:             videoParts(upload),
+		NotifySubscribers: &notifySubscribers,
+	}
+	if state != nil {
+		videoUpload.SessionChanged = state.update
+	}
+	var media io.Reader = reader
+	var sum *checksumReader
+	if resuming {
+		// the file is read from where Youtube got to, so it isn't hashed
+		videoUpload.Resume = state.session()
+	} else if h := newChecksum(); h != nil {
+		sum = &checksumReader{Reader: reader, filename: filename, h: h, expected: preChecksum}
+		media = sum
+	}
+
+	start := time.Now()
+	video, err := w.uploader.UploadVideo(ctx, media, filesize, videoUpload)
+	speedtestDeclined := declined()
+
+	if quitChan != nil {
+		quit := make(chan struct{})
+		quitChan <- quit
+		<-quit
+	}
+	progress := w.progress()
+	// a session that expired was started again from the start
+	offset = progress.Offset
+	result.Stats = w.takeStats()
+	if name, _ := w.current(); name != "" {
+		result.Timing = timingFor(name)
+	}
+	w.finish()
+
+	result.FileSize = filesize - offset
+	reconnects := 0
+	switch r := reader.(type) {
+	case *rangeReader:
+		reconnects = r.reconnects
+	case *cloudReader:
+		reconnects = r.reconnects
+	}
+	if reconnects > 0 {
+		result.SourceReconnects = reconnects
+		fmt.Fprintf(output, "Reconnected to the source %d time(s)\n", reconnects)
+	}
+	result.Duration = time.Since(start).Seconds()
+	if progress.Started {
+		result.AverageRate = float64(progress.AvgRate)
+	}
+
+	if err != nil && speedtestDeclined {
+		// the session is abandoned, so no video is created
+		if state != nil {
+			state.remove()
+		}
+		fmt.Fprintf(promptOutput, "Upload of '%s' cancelled after the speed test\n", filename)
+		return result, withCode(errCodeUpload, errSpeedtestDeclined)
+	}
+	if err != nil && ctx.Err() != nil {
+		reason, stopErr := stopReason(ctx)
+		fmt.Fprintf(promptOutput, "Upload of '%s' %s after %d of %d bytes in %s\n",
+			filename, reason, progress.Sent, filesize, time.Since(start).Round(time.Second))
+		if state != nil && state.SessionURI != "" {
+			fmt.Fprintf(promptOutput, "Upload state saved to '%s'. To continue the upload, run:\n  %s\n", state.path, resumeCommand())
+		}
+		return result, stopErr
+	}
+	if err != nil && progress.AllSent {
+		// the bytes got there, only the request finishing the upload failed
+		fmt.Fprintf(output, "Finishing the upload of '%s' failed: %v\n", filename, err)
+		if recovered := recoverUpload(ctx, w.uploader, state, upload.Snippet.Title, start); recovered != nil {
+			fmt.Fprintf(output, "Youtube created the video despite the error\n")
+			video, err = recovered, nil
+		} else {
+			err = fmt.Errorf("%w. All %d bytes were sent, so the video may still appear on the channel", err, filesize)
+		}
+	}
+	var changed sourceChangedError
+	if errors.As(err, &changed) {
+		return result, withCode(errCodeSource, err)
+	}
+	if err != nil {
+		err = checkPremiereError(checkMonetizationError(upload, err))
+		if state != nil && state.SessionURI != "" {
+			fmt.Fprintf(output, "Upload state saved to '%s'. Run again with -resume to continue the upload\n", state.path)
+		}
+		if video != nil {
+			return result, withCode(errCodeUpload, fmt.Errorf("Error making YouTube API call: %w, %v", err, video.HTTPStatusCode))
+		}
+		return result, withCode(errCodeUpload, fmt.Errorf("Error making YouTube API call: %w", err))
+	}
+	if state != nil {
+		state.remove()
+	}
+	fmt.Fprintln(output, colorize(output, styleSuccess, "Upload successful! Video ID: "+video.Id))
+	printVideoURLs(output, video.Id)
+	reportPremiere(ctx, output, video)
+	if sum != nil {
+		result.Checksum = sum.sum()
+	} else if resuming && newChecksum() != nil {
+		// only part of the file was sent this time, so hash all of it
+		result.Checksum, err = fileChecksum(filename)
+		if err != nil {
+			warnings.warnf("%s", err)
+		} else if preChecksum != "" && result.Checksum != preChecksum {
+			warnings.warnf("%s", sourceChangedError{filename, preChecksum, result.Checksum})
+		}
+	}
+	if result.Checksum != "" {
+		fmt.Fprintf(output, "Checksum: %s\n", result.Checksum)
+	}
+	writeIDFile(ctx, video.Id)
+	checkDuplicateStatus(ctx, service, video.Id)
+
+	result.setVideoURLs(video.Id)
+	if video.Snippet != nil {
+		result.Title = video.Snippet.Title
+	}
+	if video.Status != nil {
+		result.PrivacyStatus = video.Status.PrivacyStatus
+	}
+
+	if ctx.Err() != nil {
+		reason, stopErr := stopReason(ctx)
+		return result, withCode(errorCode(stopErr), fmt.Errorf("%s after uploading video ID %s", reason, video.Id))
+	}
+
+	// a thumbnail that can't be set is reported at the end, so that the
+	// captions, playlists and -waitForProcessing still happen
+	thumbFailed := false
+	if thumbData != nil {
+		if err := setThumbnail(ctx, service, video.Id, thumbFile, thumbData); err != nil {
+			warnings.warnf("%s", err)
+			thumbFailed = true
+		}
+	}
+
+	for i, c := range captionList {
+		if err := insertCaption(service, video.Id, c, captionReaders[i]); err != nil {
+			return result, withCode(errCodeCaption, err)
+		}
+	}
+
+	// new playlists have the video's privacy unless -playlistPrivacy is given
+	plx := &Playlistx{}
+	if upload.Status.PrivacyStatus != "" {
+		plx.PrivacyStatus = upload.Status.PrivacyStatus
+	}
+	if *listPrivacy != "" {
+		plx.PrivacyStatus = *listPrivacy
+	}
+
+	playlistIDs := job.playlistIDs()
+
+	// a failure to add the video to one playlist shouldn't stop it being
+	// added to the rest
+	var playlistErrors int
+	for _, pid := range playlistIDs {
+		plx.Id = pid
+		plx.Title = ""
+		err = plx.AddVideoToPlaylist(ctx, w.uploader, video.Id)
+		if err != nil {
+			warnings.warnf("Error adding video to playlist '%s': %s", pid, err)
+			playlistErrors++
+		}
+	}
+
+	// playlists named in the meta JSON have always been created if missing
+	for i, title := range job.playlistTitles() {
+		plx.Id = ""
+		plx.Title = title
+		plx.Create = i < len(videoMeta.PlaylistTitles) || *createLists
+		err = plx.AddVideoToPlaylist(ctx, w.uploader, video.Id)
+		if err != nil {
+			warnings.warnf("Error adding video to playlist '%s': %s", title, err)
+			playlistErrors++
+		}
+	}
+
+	if *waitProcessing {
+		if processed, err := waitForProcessing(ctx, w.uploader, filename, video.Id); err != nil {
+			if ctx.Err() != nil {
+				reason, stopErr := stopReason(ctx)
+				return result, withCode(errorCode(stopErr), fmt.Errorf("%s waiting for video ID %s to be processed", reason, video.Id))
+			}
+			if *deleteFailed && processed != nil && uploader.IsTerminalFailure(processed) {
+				if derr := deleteFailedVideo(ctx, service, video.Id); derr != nil {
+					warnings.warnf("Error deleting video %s after its processing failed: %s", video.Id, derr)
+				} else {
+					fmt.Fprintf(output, "Deleted video %s, as its processing failed\n", video.Id)
+					result.Deleted = true
+					err = fmt.Errorf("%s; the video was deleted", err)
+				}
+			}
+			return result, withCode(errCodeProcessing, err)
+		}
+	}
+
+	if thumbFailed {
+		return result, withCode(errCodeThumbnail, fmt.Errorf("The thumbnail of video ID %s could not be set", video.Id))
+	}
+	if playlistErrors > 0 {
+		return result, withCode(errCodePlaylist, fmt.Errorf("Video ID %s could not be added to %d playlist(s)", video.Id, playlistErrors))
+	}
+
+	return result, nil
+}
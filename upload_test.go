@@ -0,0 +1,278 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/youtube/v3"
+)
+
+// fakeYoutube is a fake of the Youtube API endpoints an upload uses. It
+// checks the metadata of the resumable session and the order and
+// Content-Range of the chunks, and keeps the bytes it was sent.
+type fakeYoutube struct {
+	t *testing.T
+	// size is the size of the file that is expected
+	size int64
+
+	// thumbnailStatus, if set, is the status setting the thumbnail fails
+	// with
+	thumbnailStatus int
+
+	mu            sync.Mutex
+	metadata      *youtube.Video
+	received      bytes.Buffer
+	ranges        []string
+	playlistItems []string
+}
+
+const fakeVideoID = "fakeVideoId"
+
+func (f *fakeYoutube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/upload/youtube/v3/videos" && query.Get("upload_id") == "":
+		if query.Get("uploadType") != "resumable" {
+			f.t.Errorf("session started with uploadType %q", query.Get("uploadType"))
+		}
+		if f.metadata != nil {
+			f.t.Error("a second upload session was started")
+		}
+		f.metadata = &youtube.Video{}
+		if err := json.NewDecoder(r.Body).Decode(f.metadata); err != nil {
+			f.t.Errorf("bad metadata: %s", err)
+		}
+		// the session URI is on the real API host, as Youtube's are, so that
+		// it has to go through -apiBaseURL as well
+		w.Header().Set("Location", "https://"+googleAPIHost+"/upload/youtube/v3/videos?uploadType=resumable&upload_id=fakesession")
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == "POST" && r.URL.Path == "/upload/youtube/v3/videos":
+		if query.Get("upload_id") != "fakesession" {
+			f.t.Errorf("chunk sent to session %q", query.Get("upload_id"))
+		}
+		contentRange := r.Header.Get("Content-Range")
+		f.ranges = append(f.ranges, contentRange)
+		var start, end int64
+		var total string
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+			f.t.Errorf("bad Content-Range %q", contentRange)
+			http.Error(w, "bad Content-Range", http.StatusBadRequest)
+			return
+		}
+		if start != int64(f.received.Len()) {
+			f.t.Errorf("chunk %q sent after %d bytes", contentRange, f.received.Len())
+		}
+		n, _ := f.received.ReadFrom(r.Body)
+		if n != end-start+1 {
+			f.t.Errorf("chunk %q had %d bytes", contentRange, n)
+		}
+		if total == "*" {
+			// the client asks for this rather than a 308
+			w.Header().Set("X-Http-Status-Code-Override", "308")
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", end))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if total != fmt.Sprint(f.size) || end+1 != f.size {
+			f.t.Errorf("last chunk %q, want it to end the %d bytes", contentRange, f.size)
+		}
+		video := *f.metadata
+		video.Id = fakeVideoID
+		video.Status.UploadStatus = "uploaded"
+		json.NewEncoder(w).Encode(&video)
+
+	case r.Method == "GET" && r.URL.Path == "/youtube/v3/videos":
+		if query.Get("id") != fakeVideoID {
+			f.t.Errorf("status of video %q asked for", query.Get("id"))
+		}
+		fmt.Fprintf(w, `{"items": [{"id": %q, "status": {"uploadStatus": "uploaded"}}]}`, fakeVideoID)
+
+	case r.Method == "POST" && r.URL.Path == "/upload/youtube/v3/thumbnails/set":
+		if f.thumbnailStatus != 0 {
+			http.Error(w, `{"error": {"code": 400, "message": "bad thumbnail"}}`, f.thumbnailStatus)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+
+	case r.Method == "POST" && r.URL.Path == "/youtube/v3/playlistItems":
+		var item youtube.PlaylistItem
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			f.t.Errorf("bad playlist item: %s", err)
+		}
+		f.playlistItems = append(f.playlistItems, item.Snippet.PlaylistId)
+		json.NewEncoder(w).Encode(&item)
+
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+// fakeVideo writes a file of size bytes that starts like an MP4
+func fakeVideo(t *testing.T, size int) string {
+	t.Helper()
+	header, err := ioutil.ReadFile(filepath.Join("testdata", "landscape.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	copy(data, header)
+	filename := filepath.Join(t.TempDir(), "video.mp4")
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// fakeUploadWorker returns a worker that uploads to fake, with flags set
+// as well as those given
+func fakeUploadWorker(t *testing.T, fake *fakeYoutube, flags map[string]string) *uploadWorker {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	flags["apiBaseURL"] = server.URL
+	setFlags(t, flags)
+	oldOutput, oldPrompt := output, promptOutput
+	output, promptOutput = ioutil.Discard, ioutil.Discard
+	t.Cleanup(func() { output, promptOutput = oldOutput, oldPrompt })
+
+	rt, err := apiBaseOverride(http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldTransport := http.DefaultTransport
+	http.DefaultTransport = rt
+	t.Cleanup(func() { http.DefaultTransport = oldTransport })
+	worker := newUploadWorker(uploader.Window{}, uploader.NewLimit(0, 0))
+	if err := worker.connect(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "fake"})); err != nil {
+		t.Fatal(err)
+	}
+	return worker
+}
+
+func TestUploadFile(t *testing.T) {
+	const size = 600000
+	filename := fakeVideo(t, size)
+	fake := &fakeYoutube{t: t, size: size}
+	worker := fakeUploadWorker(t, fake, map[string]string{
+		"chunksize": "256K",
+		"resume":    "true",
+	})
+
+	upload, videoMeta := loadMeta(t, `{"title": "Fake upload", "description": "Sent to a fake server", "tags": ["one", "two"], "categoryId": "22", "privacyStatus": "unlisted", "embeddable": false}`)
+	upload, templates, err := finishVideo(upload, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := uploadJob{filename: filename, upload: upload, videoMeta: videoMeta, templates: templates, index: 1, total: 1}
+
+	result, err := uploadFile(context.Background(), worker, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.VideoID != fakeVideoID {
+		t.Errorf("got video ID %q, want %q", result.VideoID, fakeVideoID)
+	}
+
+	if m := fake.metadata; m == nil {
+		t.Fatal("no upload session was started")
+	} else {
+		if m.Snippet.Title != "Fake upload" || m.Snippet.Description != "Sent to a fake server" || strings.Join(m.Snippet.Tags, ",") != "one,two" || m.Snippet.CategoryId != "22" {
+			t.Errorf("got snippet %+v", m.Snippet)
+		}
+		if m.Status.PrivacyStatus != "unlisted" || m.Status.Embeddable {
+			t.Errorf("got status %+v", m.Status)
+		}
+	}
+
+	wantRanges := []string{"bytes 0-262143/*", "bytes 262144-524287/*", "bytes 524288-599999/600000"}
+	if strings.Join(fake.ranges, ", ") != strings.Join(wantRanges, ", ") {
+		t.Errorf("got chunks %q, want %q", fake.ranges, wantRanges)
+	}
+	data, _ := ioutil.ReadFile(filename)
+	if !bytes.Equal(fake.received.Bytes(), data) {
+		t.Errorf("the server received %d bytes that differ from the file", fake.received.Len())
+	}
+	if result.FileSize != size {
+		t.Errorf("got file size %d, want %d", result.FileSize, size)
+	}
+
+	// the state saved for -resume is removed once the upload is done
+	if _, err := os.Stat(filename + uploadStateSuffix); !os.IsNotExist(err) {
+		t.Errorf("upload state left behind: %v", err)
+	}
+}
+
+// A thumbnail that can't be set is reported, but the video is still added
+// to its playlists
+func TestUploadFileThumbnailFailed(t *testing.T) {
+	const size = 300000
+	filename := fakeVideo(t, size)
+	thumbnail := filepath.Join(t.TempDir(), "thumb.png")
+	if err := ioutil.WriteFile(thumbnail, []byte("\x89PNG\r\n\x1a\nnot really"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeYoutube{t: t, size: size, thumbnailStatus: http.StatusBadRequest}
+	worker := fakeUploadWorker(t, fake, map[string]string{
+		"chunksize":  "256K",
+		"thumbnail":  thumbnail,
+		"playlistID": "PLone,PLtwo",
+	})
+
+	upload, templates, err := finishVideo(newVideo(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := uploadJob{filename: filename, upload: upload, templates: templates, index: 1, total: 1}
+	result, err := uploadFile(context.Background(), worker, job)
+	if errorCode(err) != errCodeThumbnail {
+		t.Errorf("got error %v, want one with code %s", err, errCodeThumbnail)
+	}
+	if result.VideoID != fakeVideoID {
+		t.Errorf("got video ID %q, want %q", result.VideoID, fakeVideoID)
+	}
+	if got := strings.Join(fake.playlistItems, ","); got != "PLone,PLtwo" {
+		t.Errorf("added to playlists %q, want PLone,PLtwo", got)
+	}
+}
+
+func TestAPIBaseURLInvalid(t *testing.T) {
+	for _, base := range []string{"ftp://example.com", "example.com:9000", "http://"} {
+		setFlags(t, map[string]string{"apiBaseURL": base})
+		if _, err := apiBaseOverride(http.DefaultTransport); err == nil {
+			t.Errorf("%q: no error", base)
+		}
+	}
+}
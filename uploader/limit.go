@@ -0,0 +1,143 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/porjo/go-flowrate/flowrate"
+)
+
+// Limit is a rate limit in kbit/s which can be changed while uploads are in
+// progress. Zero means no limit. It is a cap on the total rate of the
+// uploads sharing it, so it is divided between those in progress.
+type Limit struct {
+	mu   sync.Mutex
+	kbps int
+
+	// rampUp is the time taken to ramp up from rampStartFraction of the
+	// limit to all of it, starting when the limit is first used
+	rampUp    time.Duration
+	rampStart time.Time
+
+	// active is the number of uploads in progress under the limit
+	active int32
+}
+
+// rampStartFraction is the fraction of the rate limit that a ramp up starts
+// at
+const rampStartFraction = 0.1
+
+// NewLimit returns a limit of kbps kbit/s. If rampUp is set, the limit starts
+// at 10% of that once it is first used, increasing steadily to all of it over
+// rampUp.
+func NewLimit(kbps int, rampUp time.Duration) *Limit {
+	return &Limit{kbps: kbps, rampUp: rampUp}
+}
+
+// Get returns the limit in force now, which is less than the configured
+// limit while ramping up
+func (l *Limit) Get() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rampUp <= 0 || l.kbps == 0 {
+		return l.kbps
+	}
+	now := time.Now()
+	if l.rampStart.IsZero() {
+		l.rampStart = now
+	}
+	elapsed := now.Sub(l.rampStart)
+	if elapsed >= l.rampUp {
+		return l.kbps
+	}
+	fraction := rampStartFraction + (1-rampStartFraction)*elapsed.Seconds()/l.rampUp.Seconds()
+	if kbps := int(float64(l.kbps) * fraction); kbps > 0 {
+		return kbps
+	}
+	return 1
+}
+
+// Set changes the limit, returning the previous one
+func (l *Limit) Set(kbps int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.kbps
+	l.kbps = kbps
+	return old
+}
+
+// shared returns the limit in B/s for each of the active uploads
+func (l *Limit) shared() int64 {
+	// kbit/s to B/s = 1000/8 = 125
+	limit := int64(l.Get() * 125)
+	if n := atomic.LoadInt32(&l.active); n > 1 {
+		limit /= int64(n)
+	}
+	return limit
+}
+
+// Window is the time of day a rate limit applies, e.g. 10:00 to 14:00.
+// Uploads aren't limited outside it. The zero Window applies the limit all
+// day.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// limitChecker limits the rate a request body is read at
+type limitChecker struct {
+	Window
+	reader *flowrate.Reader
+	limit  *Limit
+	pause  *Pause
+	// done is closed when the request is cancelled, ending a pause
+	done <-chan struct{}
+}
+
+func (lc *limitChecker) Read(p []byte) (n int, err error) {
+	if err := lc.pause.Wait(lc.done); err != nil {
+		return 0, err
+	}
+
+	if lc.Start.IsZero() || lc.End.IsZero() {
+		lc.reader.SetLimit(lc.limit.shared())
+		return lc.reader.Read(p)
+	}
+
+	now := time.Now()
+
+	if now.Sub(lc.Start) >= time.Hour*24 {
+		lc.Start = lc.Start.AddDate(0, 0, 1)
+		lc.End = lc.End.AddDate(0, 0, 1)
+	}
+
+	if lc.Start.Before(now) && lc.End.After(now) {
+		lc.reader.SetLimit(lc.limit.shared())
+	} else {
+		lc.reader.SetLimit(0)
+	}
+
+	return lc.reader.Read(p)
+}
+
+func (lc *limitChecker) Close() error {
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pause stops the uploads given it in their Options reading from their
+// source while it is paused. The zero Pause isn't paused, and a nil *Pause
+// never is.
+type Pause struct {
+	mu     sync.Mutex
+	paused bool
+	// resumed is closed when the pause ends
+	resumed chan struct{}
+	// pauses is the number of times the uploads have been paused, so that a
+	// request can tell whether it was paused while in flight
+	pauses int
+}
+
+// Pause pauses the uploads, reporting false if they already were
+func (p *Pause) Pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		return false
+	}
+	p.paused = true
+	p.resumed = make(chan struct{})
+	p.pauses++
+	return true
+}
+
+// Resume resumes the uploads, reporting false if they weren't paused
+func (p *Pause) Resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return false
+	}
+	p.paused = false
+	close(p.resumed)
+	return true
+}
+
+// State reports whether the uploads are paused and how many times they have
+// been
+func (p *Pause) State() (bool, int) {
+	if p == nil {
+		return false, 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused, p.pauses
+}
+
+// Wait blocks while the uploads are paused, or until done is closed
+func (p *Pause) Wait(done <-chan struct{}) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	paused, resumed := p.paused, p.resumed
+	p.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-done:
+		return context.Canceled
+	}
+}
+
+// pausedError is returned for a request that failed while the uploads were
+// paused, e.g. because Youtube gave up waiting for the rest of the chunk. It
+// is a temporary net.Error so that the Google API client sends the chunk
+// again from the last byte Youtube acknowledged.
+type pausedError struct {
+	err error
+}
+
+func (e pausedError) Error() string {
+	return fmt.Sprintf("connection lost while paused: %v", e.err)
+}
+
+func (e pausedError) Unwrap() error   { return e.err }
+func (e pausedError) Timeout() bool   { return true }
+func (e pausedError) Temporary() bool { return true }
+
+// afterPause turns the error of a media request r that was paused while in
+// flight into a pausedError, once the uploads have been resumed, so that the
+// chunk is sent again rather than the upload failing. pauses is the number of
+// pauses when the request was sent.
+func (t *Transport) afterPause(r *http.Request, pauses int, err error) error {
+	if err == nil || r.Context().Err() != nil {
+		return err
+	}
+	if _, n := t.opts.Pause.State(); n == pauses {
+		return err
+	}
+	if t.opts.Pause.Wait(r.Context().Done()) != nil {
+		return err
+	}
+	t.mu.Lock()
+	committed := t.committed
+	t.mu.Unlock()
+	t.printf("\n%s: connection lost while paused, sending again from byte %d\n", time.Now().Format(time.RFC3339), committed)
+	return pausedError{err}
+}
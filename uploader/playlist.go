@@ -0,0 +1,49 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+// ErrPlaylistNotFound is returned by AddToPlaylist for a playlist that
+// doesn't exist
+var ErrPlaylistNotFound = errors.New("playlist not found")
+
+// AddToPlaylist adds the video with ID videoID to the end of the playlist
+// with ID playlistID
+func (u *Uploader) AddToPlaylist(ctx context.Context, playlistID, videoID string) error {
+	playlistItem := &youtube.PlaylistItem{
+		Snippet: &youtube.PlaylistItemSnippet{
+			PlaylistId: playlistID,
+			ResourceId: &youtube.ResourceId{
+				VideoId: videoID,
+				Kind:    "youtube#video",
+			},
+		},
+	}
+	_, err := u.service.PlaylistItems.Insert([]string{"snippet"}, playlistItem).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return fmt.Errorf("playlist ID '%s': %w", playlistID, ErrPlaylistNotFound)
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+const (
+	// defaultPollInterval is the first interval between processing status
+	// checks, and maxPollInterval caps the backoff between them
+	defaultPollInterval = 10 * time.Second
+	maxPollInterval     = 5 * time.Minute
+)
+
+// ProcessingOptions control how WaitForProcessing waits
+type ProcessingOptions struct {
+	// Interval is the time between the first status checks, which grows
+	// by half each time to save quota on long processing jobs, up to five
+	// minutes. It defaults to 10 seconds.
+	Interval time.Duration
+
+	// Timeout is how long to wait for in all. Zero means no limit other
+	// than ctx.
+	Timeout time.Duration
+
+	// Status, if set, is called with the video each time its status is
+	// checked, with its processingDetails and status parts
+	Status func(*youtube.Video)
+}
+
+// WaitForProcessing polls the status of the video with ID videoID until
+// Youtube has finished processing it, returning the video. An error is
+// returned if processing fails, the video is rejected, the timeout is reached
+// or ctx is cancelled, along with the video's last status if it is known.
+func (u *Uploader) WaitForProcessing(ctx context.Context, videoID string, opts ProcessingOptions) (*youtube.Video, error) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	var deadline time.Time
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+
+	for {
+		response, err := u.service.Videos.List([]string{"processingDetails", "status"}).Id(videoID).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving processing status: %w", err)
+		}
+		if len(response.Items) == 0 {
+			return nil, fmt.Errorf("video %s not found", videoID)
+		}
+		video := response.Items[0]
+		if opts.Status != nil {
+			opts.Status(video)
+		}
+
+		if video.Status.UploadStatus == "processed" {
+			return video, nil
+		}
+		if video.Status.UploadStatus == "deleted" || IsTerminalFailure(video) {
+			return video, ProcessingError(video)
+		}
+
+		if !deadline.IsZero() && time.Now().Add(interval).After(deadline) {
+			return video, fmt.Errorf("timed out waiting for video %s to be processed, status is '%s'", videoID, video.Status.UploadStatus)
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return video, ctx.Err()
+		}
+
+		// back off to save quota on long processing jobs
+		interval = interval * 3 / 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
+
+// IsTerminalFailure reports whether Youtube has given up on video: the
+// upload failed or was rejected, or processing failed or was terminated.
+// Videos which are still being uploaded or processed never count.
+func IsTerminalFailure(video *youtube.Video) bool {
+	switch video.Status.UploadStatus {
+	case "failed", "rejected":
+		return true
+	}
+	if pd := video.ProcessingDetails; pd != nil {
+		return pd.ProcessingStatus == "failed" || pd.ProcessingStatus == "terminated"
+	}
+	return false
+}
+
+// ProcessingError describes why processing of video failed
+func ProcessingError(video *youtube.Video) error {
+	msg := fmt.Sprintf("processing of video %s failed, upload status '%s'", video.Id, video.Status.UploadStatus)
+	if video.ProcessingDetails != nil && video.ProcessingDetails.ProcessingFailureReason != "" {
+		msg += fmt.Sprintf(", processing failure reason '%s'", video.ProcessingDetails.ProcessingFailureReason)
+	}
+	if video.Status.FailureReason != "" {
+		msg += fmt.Sprintf(", failure reason '%s'", video.Status.FailureReason)
+	}
+	if video.Status.RejectionReason != "" {
+		msg += fmt.Sprintf(", rejection reason '%s'", video.Status.RejectionReason)
+	}
+	return fmt.Errorf("%s", msg)
+}
@@ -0,0 +1,178 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+// maxResumeRetries is the number of times a failed chunk is retried when
+// resuming an upload
+const maxResumeRetries = 5
+
+// resumeIncomplete reports whether res is the resumable upload protocol's
+// "308 Resume Incomplete" response
+func resumeIncomplete(res *http.Response) bool {
+	return res.StatusCode == 308 || res.Header.Get("X-Http-Status-Code-Override") == "308"
+}
+
+// committedOffset returns the number of bytes the server has acknowledged,
+// taken from the Range header (e.g. 'bytes=0-1048575') of a 308 response
+func committedOffset(res *http.Response) int64 {
+	r := res.Header.Get("Range")
+	i := strings.LastIndex(r, "-")
+	if i < 0 {
+		return 0
+	}
+	end, err := strconv.ParseInt(r[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return end + 1
+}
+
+// QuerySession asks Youtube how many bytes of the upload of a video of size
+// bytes in the session at uri it has received. If the upload has completed,
+// the resulting video is returned.
+func (u *Uploader) QuerySession(ctx context.Context, uri string, size int64) (int64, *youtube.Video, error) {
+	req, err := http.NewRequest("PUT", uri, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = 0
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	req.Header.Set("X-GUploader-No-308", "yes")
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	return handleResponse(res, size)
+}
+
+// handleResponse interprets the response to a chunk upload or status query
+// of a video of size bytes, returning either the committed offset or the
+// completed video
+func handleResponse(res *http.Response, size int64) (int64, *youtube.Video, error) {
+	defer res.Body.Close()
+
+	if resumeIncomplete(res) {
+		return committedOffset(res), nil, nil
+	}
+	if err := googleapi.CheckResponse(res); err != nil {
+		return 0, nil, err
+	}
+
+	video := &youtube.Video{}
+	if err := json.NewDecoder(res.Body).Decode(video); err != nil {
+		return 0, nil, fmt.Errorf("error decoding upload response: %s", err)
+	}
+	return size, video, nil
+}
+
+// resume continues the upload session s, sending the rest of the size bytes
+// of src in chunks of Options.ChunkSize
+func (u *Uploader) resume(ctx context.Context, s Session, src io.ReaderAt, size int64) (*youtube.Video, error) {
+	offset, video, err := u.QuerySession(ctx, s.URI, size)
+	if err != nil {
+		return nil, fmt.Errorf("error querying upload session: %s", err)
+	}
+
+	chunk := int64(u.opts.ChunkSize)
+	if chunk <= 0 {
+		chunk = size
+	}
+
+	u.transport.printf("Resuming upload at byte %d of %d\n", offset, size)
+
+	var retries int
+	for video == nil {
+		u.transport.setOffset(offset)
+
+		n := chunk
+		if offset+n > size {
+			n = size - offset
+		}
+
+		req, err := http.NewRequest("PUT", s.URI, io.NewSectionReader(src, offset, n))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.ContentLength = n
+		if n > 0 {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+		} else {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		}
+		mediaType := s.MediaType
+		if mediaType == "" {
+			mediaType = "video/*"
+		}
+		req.Header.Set("Content-Type", mediaType)
+		req.Header.Set("X-GUploader-No-308", "yes")
+
+		var res *http.Response
+		res, err = u.client.Do(req)
+		if err == nil {
+			offset, video, err = handleResponse(res, size)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code < 500 {
+				return nil, err
+			}
+			var se stallError
+			if errors.As(err, &se) && se.permanent {
+				return nil, se
+			}
+			retries++
+			if retries > maxResumeRetries {
+				return nil, err
+			}
+			u.transport.printf("\nError uploading chunk, retrying: %s\n", err)
+			select {
+			case <-time.After(time.Duration(retries) * time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if offset, video, err = u.QuerySession(ctx, s.URI, size); err != nil {
+				return nil, fmt.Errorf("error querying upload session: %s", err)
+			}
+			continue
+		}
+		retries = 0
+		if u.opts.Progress != nil {
+			u.opts.Progress(u.transport.Progress())
+		}
+	}
+
+	return video, nil
+}
@@ -0,0 +1,145 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// backoffBase and backoffMax bound the backoff between retries when
+	// Youtube doesn't say how long to wait
+	backoffBase = time.Second
+	backoffMax  = time.Minute
+)
+
+// shouldBackOff reports whether a response with status code means Youtube
+// wants requests slowed down, so the request should be sent again later
+func shouldBackOff(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// retryDelay returns how long to wait before the retry following attempt,
+// from the Retry-After header in seconds or as an HTTP date, or else with
+// jittered exponential backoff. The reason for the delay is returned too.
+func retryDelay(header http.Header, attempt int) (time.Duration, string) {
+	if after := header.Get("Retry-After"); after != "" {
+		if secs, err := strconv.Atoi(after); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, "Retry-After"
+		}
+		if at, err := http.ParseTime(after); err == nil {
+			wait := time.Until(at)
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, "Retry-After"
+		}
+	}
+	d := backoffMax
+	if attempt < 6 {
+		d = backoffBase << uint(attempt)
+	}
+	// half of it fixed and half random, so that concurrent uploads spread out
+	return d/2 + time.Duration(rand.Int63n(int64(d/2))), "backoff"
+}
+
+// spendRetryTime takes wait from the time the current upload may spend
+// waiting to retry, Options.MaxRetryTime, reporting false if there isn't
+// enough left
+func (t *Transport) spendRetryTime(wait time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.opts.MaxRetryTime > 0 && t.retryWait+wait > t.opts.MaxRetryTime {
+		return false
+	}
+	t.retryWait += wait
+	return true
+}
+
+// RoundTrip sends r. If Youtube asks for requests to be slowed down, r is sent
+// again after waiting, as long as its body can be read again, which is true
+// of the chunks of a resumable upload and of API calls with JSON bodies.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	orig := r
+	replayable := r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			r = orig.Clone(orig.Context())
+			if orig.GetBody != nil {
+				body, err := orig.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+		res, err := t.send(r)
+		if err != nil || !replayable || !shouldBackOff(res.StatusCode) {
+			return res, err
+		}
+
+		wait, reason := retryDelay(res.Header, attempt)
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+		res.Body.Close()
+		if !t.spendRetryTime(wait) {
+			return nil, fmt.Errorf("%s, giving up as retrying would take longer than the maximum total retry time of %s", res.Status, t.opts.MaxRetryTime)
+		}
+		t.warnf("%s: %s from %s, retrying in %s (%s)", time.Now().Format(time.RFC3339), res.Status, r.URL.Path, wait.Round(time.Millisecond), reason)
+		select {
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+}
+
+// RetryCall calls call, retrying it like a Transport does when it fails
+// because Youtube wants requests slowed down, for no longer than maxWait in
+// all, or without a limit if it is zero. It is for calls with bodies that
+// can't be read again, so can't be retried by a Transport, e.g. thumbnails.
+// warnf, if not nil, is told of each retry.
+func RetryCall(ctx context.Context, maxWait time.Duration, warnf func(format string, args ...interface{}), call func() error) error {
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		err := call()
+		var apiErr *googleapi.Error
+		if !errors.As(err, &apiErr) || !shouldBackOff(apiErr.Code) {
+			return err
+		}
+		wait, reason := retryDelay(apiErr.Header, attempt)
+		if maxWait > 0 && waited+wait > maxWait {
+			return err
+		}
+		waited += wait
+		if warnf != nil {
+			warnf("%s: %d %s, retrying in %s (%s)", time.Now().Format(time.RFC3339), apiErr.Code, http.StatusText(apiErr.Code), wait.Round(time.Millisecond), reason)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
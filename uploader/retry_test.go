@@ -0,0 +1,216 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// fakeRoundTripper answers requests with the responses it is given, in
+// order, recording the bodies of the requests
+type fakeRoundTripper struct {
+	mu        sync.Mutex
+	responses []*http.Response
+	bodies    []string
+}
+
+func (f *fakeRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var body string
+	if r.Body != nil {
+		b, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		body = string(b)
+	}
+	f.bodies = append(f.bodies, body)
+	if len(f.responses) == 0 {
+		return nil, errors.New("no more responses")
+	}
+	res := f.responses[0]
+	f.responses = f.responses[1:]
+	res.Request = r
+	return res, nil
+}
+
+// response returns a response with status code and headers given as
+// alternating names and values
+func response(code int, header ...string) *http.Response {
+	res := &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		res.Header.Set(header[i], header[i+1])
+	}
+	return res
+}
+
+func TestRetryDelay(t *testing.T) {
+	// Retry-After in seconds
+	if d, reason := retryDelay(http.Header{"Retry-After": {"7"}}, 0); d != 7*time.Second || reason != "Retry-After" {
+		t.Errorf("Retry-After 7: got %s (%s)", d, reason)
+	}
+	// Retry-After as an HTTP date
+	at := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if d, reason := retryDelay(http.Header{"Retry-After": {at}}, 0); d < 28*time.Second || d > 30*time.Second || reason != "Retry-After" {
+		t.Errorf("Retry-After %s: got %s (%s)", at, d, reason)
+	}
+	// a date in the past means now
+	past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+	if d, _ := retryDelay(http.Header{"Retry-After": {past}}, 0); d != 0 {
+		t.Errorf("Retry-After %s: got %s, want 0", past, d)
+	}
+	// without it, or with one that can't be parsed, the backoff doubles from
+	// a second up to a minute, half of it random
+	for attempt, base := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute, time.Minute} {
+		header := http.Header{}
+		if attempt%2 == 1 {
+			header.Set("Retry-After", "soon")
+		}
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 20; i++ {
+			d, reason := retryDelay(header, attempt)
+			if d < base/2 || d >= base || reason != "backoff" {
+				t.Fatalf("attempt %d: got %s (%s), want [%s, %s)", attempt, d, reason, base/2, base)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("attempt %d: the backoff isn't jittered", attempt)
+		}
+	}
+}
+
+func TestRoundTripRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []*http.Response
+		// status is the final status, 0 for an error, and sent the number
+		// of times the request is sent
+		status, sent int
+	}{
+		{"429 Retry-After seconds", []*http.Response{response(429, "Retry-After", "0"), response(200)}, 200, 2},
+		{"429 Retry-After date", []*http.Response{response(429, "Retry-After", time.Now().Add(-time.Second).UTC().Format(http.TimeFormat)), response(200)}, 200, 2},
+		{"503 backoff", []*http.Response{response(503), response(200)}, 200, 2},
+		{"503 twice", []*http.Response{response(503, "Retry-After", "0"), response(503, "Retry-After", "0"), response(201)}, 201, 3},
+		// other errors are for the caller to deal with
+		{"500", []*http.Response{response(500), response(200)}, 500, 1},
+		{"403", []*http.Response{response(403)}, 403, 1},
+		// a wait longer than Options.MaxRetryTime fails at once
+		{"over maxTotalRetryTime", []*http.Response{response(429, "Retry-After", "3600"), response(200)}, 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeRoundTripper{responses: test.responses}
+			transport := NewTransport(fake, Options{MaxRetryTime: time.Minute})
+			body := `{"snippet":{"title":"x"}}`
+			req, _ := http.NewRequest("POST", "https://www.googleapis.com/youtube/v3/playlistItems", strings.NewReader(body))
+			res, err := transport.RoundTrip(req)
+			switch {
+			case test.status == 0 && err == nil:
+				t.Errorf("got %d, want an error", res.StatusCode)
+			case test.status != 0 && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.status != 0 && res.StatusCode != test.status:
+				t.Errorf("got %d, want %d", res.StatusCode, test.status)
+			}
+			if len(fake.bodies) != test.sent {
+				t.Errorf("sent %d times, want %d", len(fake.bodies), test.sent)
+			}
+			// each retry has the whole body
+			for i, b := range fake.bodies {
+				if b != body {
+					t.Errorf("request %d had body %q", i, b)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxTotalRetryTime(t *testing.T) {
+	transport := NewTransport(nil, Options{MaxRetryTime: 10 * time.Second})
+	// the waits for a file add up, until they would pass the limit
+	for _, wait := range []time.Duration{4 * time.Second, 4 * time.Second} {
+		if !transport.spendRetryTime(wait) {
+			t.Fatalf("wait of %s refused after %s", wait, transport.retryWait)
+		}
+	}
+	if transport.spendRetryTime(4 * time.Second) {
+		t.Error("waits of 12s allowed")
+	}
+	if !transport.spendRetryTime(2 * time.Second) {
+		t.Error("wait of 2s refused with 2s left")
+	}
+	// each file starts again
+	transport.reset(0, 0)
+	if !transport.spendRetryTime(10 * time.Second) {
+		t.Error("wait refused after reset")
+	}
+
+	// 0 is no limit
+	transport = NewTransport(nil, Options{})
+	if !transport.spendRetryTime(time.Hour) {
+		t.Error("wait refused without a limit")
+	}
+}
+
+func TestRetryCall(t *testing.T) {
+	var warnings int
+	warnf := func(string, ...interface{}) { warnings++ }
+	var calls int
+	err := RetryCall(context.Background(), time.Minute, warnf, func() error {
+		calls++
+		if calls < 3 {
+			return &googleapi.Error{Code: 429, Header: http.Header{"Retry-After": {"0"}}}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("got %v after %d calls, want success after 3", err, calls)
+	}
+	if warnings != 2 {
+		t.Errorf("got %d warnings, want one for each retry", warnings)
+	}
+
+	calls = 0
+	err = RetryCall(context.Background(), time.Minute, nil, func() error {
+		calls++
+		return &googleapi.Error{Code: 503, Header: http.Header{"Retry-After": {"3600"}}}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("got %v after %d calls, want the error after 1 as the wait is over the maximum", err, calls)
+	}
+
+	calls = 0
+	err = RetryCall(context.Background(), time.Minute, nil, func() error {
+		calls++
+		return &googleapi.Error{Code: 400}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("got %v after %d calls, want a 400 not to be retried", err, calls)
+	}
+}
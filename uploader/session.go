@@ -0,0 +1,241 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxSessionRestarts is the number of times an upload is started again in a
+// new session after its session expired
+const maxSessionRestarts = 3
+
+// keepaliveCheck is how often the keepalive checks whether the upload
+// session has been idle for Options.SessionKeepalive
+const keepaliveCheck = 10 * time.Second
+
+// Session is the resumable upload session of a video, for continuing its
+// upload after an interruption with VideoUpload.Resume
+type Session struct {
+	// URI is the session's URI, given by Youtube when the upload starts
+	URI string
+	// Offset is the number of bytes of the video Youtube has acknowledged
+	Offset int64
+	// MediaType is the Content-Type the video is sent with
+	MediaType string
+}
+
+// uploadSession is the resumable upload session of the video being uploaded
+// on a Transport, guarded by its mutex
+type uploadSession struct {
+	Session
+	// expired is set when Youtube answered a request to the session with
+	// 404 or 410, so that the upload can't continue in it
+	expired bool
+	// inFlight is the number of chunks being sent and lastActivity is when
+	// one was last started or finished
+	inFlight     int
+	lastActivity time.Time
+	// cancel stops the current attempt at the upload, when the keepalive
+	// finds that the session has expired
+	cancel context.CancelFunc
+	// changed, if set, is told of changes to the session's URI and offset
+	changed func(Session)
+}
+
+// observeSession tracks the upload session from the requests sent on the
+// transport: its URI from the response which starts it, how much of the
+// video Youtube has from the responses to the chunks, and whether it has
+// expired from the responses to the requests sent to it
+func (t *Transport) observeSession(r *http.Request, res *http.Response, err error) {
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	s := &t.session
+	changed := false
+	switch {
+	case strings.HasSuffix(r.URL.Path, videoUploadPath) && r.URL.Query().Get("uploadType") == "resumable" && res.StatusCode == http.StatusOK:
+		if location := res.Header.Get("Location"); location != "" {
+			s.URI = location
+			s.Offset = 0
+			s.expired = false
+			changed = true
+		}
+	case s.URI != "" && r.URL.String() == s.URI:
+		if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone {
+			s.expired = true
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			s.MediaType = contentType
+		}
+		if resumeIncomplete(res) {
+			s.Offset = committedOffset(res)
+			changed = true
+		}
+	}
+	session, notify := s.Session, s.changed
+	t.mu.Unlock()
+	if changed && notify != nil {
+		notify(session)
+	}
+}
+
+// setOffset records that the upload is carrying on from offset, when
+// resuming a session
+func (t *Transport) setOffset(offset int64) {
+	t.mu.Lock()
+	t.session.Offset = offset
+	session, notify := t.session.Session, t.session.changed
+	t.mu.Unlock()
+	if notify != nil {
+		notify(session)
+	}
+}
+
+// startChunk and endChunk record a chunk being sent, for the keepalive
+func (t *Transport) startChunk() {
+	t.mu.Lock()
+	t.session.inFlight++
+	t.session.lastActivity = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *Transport) endChunk() {
+	t.mu.Lock()
+	t.session.inFlight--
+	t.session.lastActivity = time.Now()
+	t.mu.Unlock()
+}
+
+// useSession sets the upload session, when resuming one from an earlier
+// upload, the function which cancels the current attempt at the upload and
+// the one told of changes to the session
+func (t *Transport) useSession(resume *Session, cancel context.CancelFunc, changed func(Session)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if resume != nil {
+		t.session.Session = *resume
+	}
+	t.session.cancel = cancel
+	t.session.changed = changed
+}
+
+// sessionExpired reports whether Youtube has forgotten the upload session
+func (t *Transport) sessionExpired() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.session.expired
+}
+
+// restartSession forgets the expired upload session, so that the video is
+// uploaded again from the start in a new one, with cancel stopping it
+func (t *Transport) restartSession(cancel context.CancelFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.session = uploadSession{cancel: cancel, changed: t.session.changed, lastActivity: time.Now()}
+	t.offset = 0
+	t.committed = 0
+	t.sent = 0
+	t.sentAll = false
+}
+
+// keepSessionAlive asks Youtube how much of the upload it has, with the
+// "Content-Range: bytes */size" status query of the resumable upload
+// protocol, whenever no chunk has been sent for Options.SessionKeepalive,
+// e.g. while the uploads are paused or waiting to retry. That keeps an idle
+// session from being expired, and notices early if it has been, in which case
+// the current attempt is cancelled so that a new session can be started. It
+// returns a function which stops it.
+func (t *Transport) keepSessionAlive(client *http.Client) func() {
+	keepalive := t.opts.SessionKeepalive
+	if keepalive <= 0 {
+		return func() {}
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		interval := keepaliveCheck
+		if keepalive < interval {
+			interval = keepalive
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			t.mu.Lock()
+			s := t.session
+			size := t.filesize
+			t.mu.Unlock()
+			paused, _ := t.opts.Pause.State()
+			if s.URI == "" || s.expired || (s.inFlight > 0 && !paused) || time.Since(s.lastActivity) < keepalive {
+				continue
+			}
+			t.pingSession(ctx, client, s.URI, size)
+			if t.sessionExpired() {
+				t.printf("\n%s: the upload session has expired\n", time.Now().Format(time.RFC3339))
+				if s.cancel != nil {
+					s.cancel()
+				}
+			}
+		}
+	}()
+	return func() {
+		stop()
+		<-finished
+	}
+}
+
+// pingSession sends the status query to the session at uri, of an upload of
+// size bytes. Failures other than the session having expired, which is
+// noticed by observeSession, are ignored, as the next chunk will run into
+// them anyway.
+func (t *Transport) pingSession(ctx context.Context, client *http.Client, uri string, size int64) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	req, err := http.NewRequest("PUT", uri, nil)
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = 0
+	total := "*"
+	if size > 0 {
+		total = fmt.Sprint(size)
+	}
+	req.Header.Set("Content-Range", "bytes */"+total)
+	req.Header.Set("X-GUploader-No-308", "yes")
+	res, err := client.Do(req)
+	if err == nil {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}
+	t.mu.Lock()
+	if t.session.URI == uri {
+		t.session.lastActivity = time.Now()
+	}
+	t.mu.Unlock()
+}
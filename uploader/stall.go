@@ -0,0 +1,122 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// stallError is returned for a request that was aborted because no data was
+// sent for Options.StallTimeout. It is a temporary net.Error so that the
+// Google API client retries the chunk.
+type stallError struct {
+	timeout   time.Duration
+	stalls    int
+	permanent bool
+}
+
+func (e stallError) Error() string {
+	if e.permanent {
+		return fmt.Sprintf("upload stalled %d times in a row, giving up", e.stalls)
+	}
+	return fmt.Sprintf("no data sent for %s", e.timeout)
+}
+
+func (e stallError) Timeout() bool   { return true }
+func (e stallError) Temporary() bool { return !e.permanent }
+
+// cancelBody cancels the request's context once the response is read
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// watchStall sends the media request r, aborting it if the upload makes no
+// progress for Options.StallTimeout
+func (t *Transport) watchStall(r *http.Request) (*http.Response, error) {
+	timeout := t.opts.StallTimeout
+	ctx, cancel := context.WithCancel(r.Context())
+	r = r.WithContext(ctx)
+
+	done := make(chan struct{})
+	stalled := make(chan int64, 1)
+	go func() {
+		interval := time.Second
+		if timeout < interval {
+			interval = timeout
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := t.progress()
+		lastMoved := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if paused, _ := t.opts.Pause.State(); paused {
+					// a pause isn't a stall
+					lastMoved = time.Now()
+				} else if p := t.progress(); p != last {
+					last = p
+					lastMoved = time.Now()
+				} else if time.Since(lastMoved) >= timeout {
+					stalled <- p
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	res, err := t.rt.RoundTrip(r)
+	close(done)
+
+	select {
+	case at := <-stalled:
+		if res != nil {
+			res.Body.Close()
+		}
+		cancel()
+		t.mu.Lock()
+		t.stalls++
+		stalls := t.stalls
+		t.mu.Unlock()
+		t.warnf("%s: upload stalled at byte %d, restarting (stall %d of %d)", time.Now().Format(time.RFC3339), at, stalls, t.opts.MaxStalls)
+		return nil, stallError{timeout: timeout, stalls: stalls, permanent: stalls >= t.opts.MaxStalls}
+	default:
+	}
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	t.mu.Lock()
+	t.stalls = 0
+	t.mu.Unlock()
+	res.Body = cancelBody{res.Body, cancel}
+	return res, nil
+}
@@ -0,0 +1,289 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/porjo/go-flowrate/flowrate"
+)
+
+// videoUploadPath is the path of media uploads of the Videos.Insert call
+const videoUploadPath = "/upload/youtube/v3/videos"
+
+// Transport sends the requests of an Uploader. It limits the rate of the
+// video data and tracks the progress of the upload, retries requests that
+// Youtube asks to be slowed down, restarts chunks that stall and keeps track
+// of the upload session. It uploads one video at a time.
+type Transport struct {
+	rt    http.RoundTripper
+	opts  Options
+	limit *Limit
+
+	// committed is the number of bytes acknowledged by Youtube and sent is
+	// the number of bytes of the in-flight request read so far. Bytes of a
+	// request that fails are discarded, as the chunk will be sent again.
+	mu        sync.Mutex
+	reader    *flowrate.Reader
+	filesize  int64
+	offset    int64
+	committed int64
+	sent      int64
+	// stalls is the number of consecutive requests aborted by
+	// Options.StallTimeout
+	stalls int
+	// retryWait is the time spent waiting to retry requests, limited by
+	// Options.MaxRetryTime
+	retryWait time.Duration
+	// sentAll is set once every byte of the video has been sent, even if the
+	// request that sent the last of them failed
+	sentAll bool
+
+	// session is the resumable upload session of the current video
+	session uploadSession
+}
+
+// NewTransport returns a Transport which sends requests with base, or
+// http.DefaultTransport if it is nil. It is for putting under other
+// transports, e.g. one adding OAuth credentials, which is then given to New
+// as Options.Transport. Only the options about sending requests are used:
+// RateLimit, Limit, LimitWindow, StallTimeout, MaxStalls, MaxRetryTime,
+// SessionKeepalive, Pause, Warnf, Printf and Observe.
+func NewTransport(base http.RoundTripper, opts Options) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if opts.StallTimeout > 0 && opts.MaxStalls <= 0 {
+		opts.MaxStalls = defaultMaxStalls
+	}
+	limit := opts.Limit
+	if limit == nil {
+		limit = NewLimit(opts.RateLimit, 0)
+	}
+	return &Transport{rt: base, opts: opts, limit: limit, session: uploadSession{lastActivity: time.Now()}}
+}
+
+// Sent is a request sent by a Transport with its outcome, for
+// Options.Observe
+type Sent struct {
+	Request  *http.Request
+	Response *http.Response
+	Err      error
+	// Media is set for a request carrying video data
+	Media bool
+	// Start is when the request was sent, and BodyDone when all of the body
+	// of a media request had been, or the zero time if it never was
+	Start    time.Time
+	BodyDone time.Time
+	// BodySent is the number of bytes of the body of a media request sent
+	BodySent int64
+	// Stalled is set for a media request that was aborted as it stalled
+	Stalled bool
+}
+
+// Progress returns the progress of the upload on the transport
+func (t *Transport) Progress() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := Progress{Sent: t.committed + t.sent, Size: t.filesize, Offset: t.offset, AllSent: t.sentAll}
+	if t.reader != nil {
+		s := t.reader.Monitor.Status()
+		p.Rate, p.AvgRate, p.PeakRate, p.Duration = s.CurRate, s.AvgRate, s.PeakRate, s.Duration
+		p.Started = true
+	}
+	return p
+}
+
+// Limit returns the rate limit of the uploads on the transport
+func (t *Transport) Limit() *Limit {
+	return t.limit
+}
+
+// reset prepares the transport for the upload of a new video of filesize
+// bytes, offset of which have already been uploaded
+func (t *Transport) reset(filesize, offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reader = nil
+	t.filesize = filesize
+	t.offset = offset
+	t.committed = offset
+	t.sent = 0
+	t.stalls = 0
+	t.retryWait = 0
+	t.sentAll = false
+	t.session = uploadSession{lastActivity: time.Now()}
+}
+
+// progress returns the number of bytes of the video uploaded so far
+func (t *Transport) progress() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.committed + t.sent
+}
+
+// countSent records n bytes of the in-flight request as sent
+func (t *Transport) countSent(n int) {
+	t.mu.Lock()
+	t.sent += int64(n)
+	t.mu.Unlock()
+}
+
+// commit updates the committed byte count from the response to a media
+// upload request
+func (t *Transport) commit(res *http.Response, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.filesize > 0 && t.committed+t.sent >= t.filesize {
+		t.sentAll = true
+	}
+	switch {
+	case err != nil:
+	case resumeIncomplete(res):
+		t.committed = committedOffset(res)
+	case res.StatusCode < 300:
+		t.committed += t.sent
+		if t.filesize > 0 {
+			t.committed = t.filesize
+		}
+	}
+	t.sent = 0
+}
+
+// warnf and printf pass on warnings and messages to the functions given in
+// Options, if any
+func (t *Transport) warnf(format string, args ...interface{}) {
+	if t.opts.Warnf != nil {
+		t.opts.Warnf(format, args...)
+	}
+}
+
+func (t *Transport) printf(format string, args ...interface{}) {
+	if t.opts.Printf != nil {
+		t.opts.Printf(format, args...)
+	}
+}
+
+// countingReader reports the bytes read from a request body to its transport
+type countingReader struct {
+	io.ReadCloser
+	t *Transport
+
+	// remaining is the length of the body not yet read, and done is when
+	// all of it was
+	remaining int64
+	done      time.Time
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.t.countSent(n)
+	c.remaining -= int64(n)
+	if c.done.IsZero() && (err == io.EOF || c.remaining == 0) {
+		c.done = time.Now()
+	}
+	return n, err
+}
+
+// IsMediaUpload reports whether r carries video data: either a multipart
+// upload of the whole file, or a chunk sent to a resumable upload session.
+// The request that starts a resumable session only carries the metadata, and
+// requests to other endpoints (thumbnails, captions, OAuth) never count.
+func IsMediaUpload(r *http.Request) bool {
+	if r.Body == nil || r.ContentLength == 0 || !strings.HasSuffix(r.URL.Path, videoUploadPath) {
+		return false
+	}
+	query := r.URL.Query()
+	return query.Get("uploadType") == "multipart" || query.Get("upload_id") != ""
+}
+
+// observe calls Options.Observe, if it is set, before r is sent, returning
+// the request to send and the function to call with the outcome
+func (t *Transport) observe(r *http.Request) (*http.Request, func(Sent)) {
+	if t.opts.Observe == nil {
+		return r, func(Sent) {}
+	}
+	r, done := t.opts.Observe(r)
+	if done == nil {
+		done = func(Sent) {}
+	}
+	return r, done
+}
+
+// send sends r, limiting the rate of and tracking the progress of media
+// uploads
+func (t *Transport) send(r *http.Request) (res *http.Response, err error) {
+	r, observed := t.observe(r)
+	start := time.Now()
+	if !IsMediaUpload(r) {
+		res, err = t.rt.RoundTrip(r)
+		t.observeSession(r, res, err)
+		observed(Sent{Request: r, Response: res, Err: err, Start: start})
+		return res, err
+	}
+
+	atomic.AddInt32(&t.limit.active, 1)
+	defer atomic.AddInt32(&t.limit.active, -1)
+
+	t.mu.Lock()
+	monitor := t.reader
+	t.mu.Unlock()
+
+	// the limit is set in limitChecker.Read
+	reader := flowrate.NewReader(r.Body, 0)
+	if monitor != nil {
+		// carry over stats to the new limiter
+		reader.Monitor = monitor.Monitor
+	} else {
+		reader.Monitor.SetTransferSize(t.filesize)
+	}
+	body := &countingReader{ReadCloser: &limitChecker{t.opts.LimitWindow, reader, t.limit, t.opts.Pause, r.Context().Done()}, t: t, remaining: r.ContentLength}
+	r.Body = body
+
+	t.mu.Lock()
+	t.reader = reader
+	t.sent = 0
+	t.mu.Unlock()
+
+	_, pauses := t.opts.Pause.State()
+	t.startChunk()
+	if t.opts.StallTimeout > 0 {
+		res, err = t.watchStall(r)
+	} else {
+		res, err = t.rt.RoundTrip(r)
+	}
+	t.endChunk()
+	var se stallError
+	observed(Sent{
+		Request:  r,
+		Response: res,
+		Err:      err,
+		Media:    true,
+		Start:    start,
+		BodyDone: body.done,
+		BodySent: r.ContentLength - body.remaining,
+		Stalled:  errors.As(err, &se),
+	})
+	t.commit(res, err)
+	err = t.afterPause(r, pauses, err)
+	t.observeSession(r, res, err)
+	return res, err
+}
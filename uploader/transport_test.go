@@ -0,0 +1,115 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newRequest returns a request with a body of size bytes, or none if size is
+// zero
+func newRequest(t *testing.T, method, url string, size int) *http.Request {
+	t.Helper()
+	var body io.Reader
+	if size > 0 {
+		body = strings.NewReader(strings.Repeat("x", size))
+	}
+	r, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestIsMediaUpload(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		method string
+		url    string
+		size   int
+		want   bool
+	}{
+		{"metadata starting a session", "POST", "https://youtube.googleapis.com/upload/youtube/v3/videos?part=snippet,status&uploadType=resumable", 200, false},
+		{"chunk", "PUT", "https://youtube.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&upload_id=xyz", 1000, true},
+		{"chunk by POST", "POST", "https://youtube.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&upload_id=xyz", 1000, true},
+		{"session status query", "PUT", "https://youtube.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&upload_id=xyz", 0, false},
+		{"multipart upload", "POST", "https://youtube.googleapis.com/upload/youtube/v3/videos?part=snippet&uploadType=multipart", 1000, true},
+		{"thumbnail", "POST", "https://youtube.googleapis.com/upload/youtube/v3/thumbnails/set?videoId=abc&uploadType=multipart", 1000, false},
+		{"caption", "POST", "https://youtube.googleapis.com/upload/youtube/v3/captions?part=snippet&uploadType=multipart", 1000, false},
+		{"metadata update", "PUT", "https://youtube.googleapis.com/youtube/v3/videos?part=snippet", 200, false},
+		{"playlist insert", "POST", "https://youtube.googleapis.com/youtube/v3/playlistItems?part=snippet", 200, false},
+		{"token refresh", "POST", "https://oauth2.googleapis.com/token", 200, false},
+	} {
+		if got := IsMediaUpload(newRequest(t, test.method, test.url, test.size)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// Only the bodies of chunks are throttled and counted as uploaded, not
+// metadata or OAuth requests. Progress.Started is set once the rate limiter
+// has been put on a request.
+func TestTransportProgressCountsChunks(t *testing.T) {
+	fake := &fakeRoundTripper{responses: []*http.Response{
+		response(http.StatusOK, "Location", "https://youtube.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&upload_id=xyz"),
+		response(http.StatusOK),
+		response(http.StatusPermanentRedirect, "Range", "bytes=0-499"),
+		response(http.StatusOK),
+		response(http.StatusOK),
+	}}
+	var media []bool
+	transport := NewTransport(fake, Options{Observe: func(r *http.Request) (*http.Request, func(Sent)) {
+		return r, func(s Sent) { media = append(media, s.Media) }
+	}})
+	transport.reset(1000, 0)
+
+	send := func(r *http.Request) {
+		t.Helper()
+		res, err := transport.RoundTrip(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	send(newRequest(t, "POST", "https://youtube.googleapis.com/upload/youtube/v3/videos?part=snippet&uploadType=resumable", 300))
+	send(newRequest(t, "POST", "https://oauth2.googleapis.com/token", 400))
+	if p := transport.Progress(); p.Sent != 0 || p.Started {
+		t.Errorf("after the metadata and a token refresh, got progress %+v", p)
+	}
+
+	send(newRequest(t, "PUT", "https://youtube.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&upload_id=xyz", 500))
+	if p := transport.Progress(); p.Sent != 500 || !p.Started {
+		t.Errorf("after the first chunk, got progress %+v", p)
+	}
+
+	send(newRequest(t, "POST", "https://oauth2.googleapis.com/token", 400))
+	send(newRequest(t, "PUT", "https://youtube.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&upload_id=xyz", 500))
+	if p := transport.Progress(); p.Sent != 1000 || !p.AllSent {
+		t.Errorf("after the last chunk, got progress %+v", p)
+	}
+
+	want := []bool{false, false, true, false, true}
+	if len(media) != len(want) {
+		t.Fatalf("observed %d requests, want %d", len(media), len(want))
+	}
+	for i := range want {
+		if media[i] != want[i] {
+			t.Errorf("request %d observed with Media %v, want %v", i, media[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,340 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package uploader uploads videos to Youtube. It is the core of the
+// youtubeuploader command, for use from other programs:
+//
+//	u, err := uploader.New(client, uploader.Options{RateLimit: 8000})
+//	video, err := u.Upload(ctx, file, size, uploader.Meta{Title: "My video"})
+//
+// client must add OAuth credentials with the youtube.upload scope to its
+// requests, e.g. one returned by golang.org/x/oauth2's Config.Client.
+//
+// Videos are sent in chunks in a resumable upload session. Chunks that fail
+// or stall are sent again, requests Youtube asks to be slowed down are
+// retried as it says, and a session that expires is started again if the
+// source can be read again from the start. UploadVideo takes the full video
+// resource and can continue the session of an earlier, interrupted upload.
+// An Uploader uploads one video at a time; Uploaders sharing a Limit share
+// the rate limit.
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+// defaultMaxStalls is Options.MaxStalls when it isn't given
+const defaultMaxStalls = 5
+
+// Options control how videos are uploaded
+type Options struct {
+	// RateLimit is the maximum upload rate in kbit/s. Zero means no limit.
+	// It is ignored if Limit is set.
+	RateLimit int
+
+	// Limit, if set, is a rate limit which can be changed during uploads.
+	// Uploaders given the same Limit share it.
+	Limit *Limit
+
+	// LimitWindow is the time of day the rate limit applies. Outside it,
+	// uploads aren't limited.
+	LimitWindow Window
+
+	// ChunkSize is the size in bytes of each upload request, a multiple of
+	// 256KiB. Zero means googleapi.DefaultUploadChunkSize, and a negative
+	// size sends the video in a single request.
+	ChunkSize int
+
+	// NotifySubscribers sets whether subscribers are notified of the video
+	NotifySubscribers bool
+
+	// Progress, if set, is called after each chunk is uploaded
+	Progress func(Progress)
+
+	// StallTimeout, if set, is how long a chunk may go without any data
+	// being sent before it is sent again. After MaxStalls stalls in a row,
+	// 5 if it isn't set, the upload fails.
+	StallTimeout time.Duration
+	MaxStalls    int
+
+	// MaxRetryTime is the longest time the upload of a video may spend
+	// waiting to retry requests Youtube asked to be slowed down, with 429 or
+	// 503. Zero means no limit.
+	MaxRetryTime time.Duration
+
+	// SessionKeepalive, if set, is how long an upload session may go
+	// without a chunk being sent, e.g. while paused or waiting to retry,
+	// before Youtube is asked for its progress. That keeps the session from
+	// expiring, and notices early if it has.
+	SessionKeepalive time.Duration
+
+	// Pause, if set, pauses the uploads while it is paused
+	Pause *Pause
+
+	// Warnf, if set, is told of problems that don't stop the upload, e.g.
+	// retries and stalls, and Printf of other events of the upload, e.g. a
+	// new session being started
+	Warnf  func(format string, args ...interface{})
+	Printf func(format string, args ...interface{})
+
+	// Observe, if set, is called with each request before it is sent. It
+	// may return a request to send instead, e.g. with an
+	// httptrace.ClientTrace, and a function to call with the outcome.
+	Observe func(*http.Request) (*http.Request, func(Sent))
+
+	// Transport, if set, is the Transport made by NewTransport that the
+	// client given to New sends its requests through, under its OAuth
+	// transport. Otherwise New puts a new one on top of the client's
+	// transport. The options about sending requests are those given to
+	// NewTransport, rather than these.
+	Transport *Transport
+}
+
+// Progress is the state of an upload
+type Progress struct {
+	// Sent is the number of bytes sent, and Size is the size of the video,
+	// or 0 if it is not known
+	Sent int64
+	Size int64
+	// Offset is the number of bytes Youtube already had when the upload
+	// started, when it continued an earlier session
+	Offset int64
+	// AllSent is set once all of the video has been sent, even if Youtube
+	// hasn't acknowledged it
+	AllSent bool
+
+	// Started is set once video data has been sent. Rate, AvgRate and
+	// PeakRate are the current, average and highest upload rates in bytes
+	// per second, and Duration is how long the data has been sent for.
+	Started  bool
+	Rate     int64
+	AvgRate  int64
+	PeakRate int64
+	Duration time.Duration
+}
+
+// Meta is the metadata of a video
+type Meta struct {
+	Title       string
+	Description string
+	CategoryID  string
+	Tags        []string
+	// PrivacyStatus is 'public', 'unlisted' or 'private'. It defaults to
+	// 'private'.
+	PrivacyStatus string
+	// Language is the BCP-47 language code of the title and description
+	Language string
+	// PublishAt is the RFC 3339 time a private video is made public
+	PublishAt string
+	// PlaylistIDs are the playlists the video is added to once it is
+	// uploaded
+	PlaylistIDs []string
+}
+
+// Video returns meta as a Youtube API video resource
+func (meta Meta) Video() *youtube.Video {
+	privacy := meta.PrivacyStatus
+	if privacy == "" {
+		privacy = "private"
+	}
+	return &youtube.Video{
+		Snippet: &youtube.VideoSnippet{
+			Title:           meta.Title,
+			Description:     meta.Description,
+			CategoryId:      meta.CategoryID,
+			Tags:            meta.Tags,
+			DefaultLanguage: meta.Language,
+		},
+		Status: &youtube.VideoStatus{
+			PrivacyStatus: privacy,
+			PublishAt:     meta.PublishAt,
+		},
+	}
+}
+
+// Uploader uploads videos with an authorised client
+type Uploader struct {
+	service   *youtube.Service
+	client    *http.Client
+	transport *Transport
+	opts      Options
+}
+
+// New returns an Uploader which makes requests with client
+func New(client *http.Client, opts Options) (*Uploader, error) {
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = googleapi.DefaultUploadChunkSize
+	}
+	if opts.ChunkSize < 0 {
+		opts.ChunkSize = 0
+	}
+	if opts.ChunkSize%googleapi.MinUploadChunkSize != 0 {
+		return nil, fmt.Errorf("chunk size %d is not a multiple of %d", opts.ChunkSize, googleapi.MinUploadChunkSize)
+	}
+	transport := opts.Transport
+	if transport == nil {
+		transport = NewTransport(client.Transport, opts)
+		wrapped := *client
+		wrapped.Transport = transport
+		client = &wrapped
+	}
+	service, err := youtube.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Youtube client: %w", err)
+	}
+	return &Uploader{service: service, client: client, transport: transport, opts: opts}, nil
+}
+
+// Service returns the Youtube API client of the Uploader, for other calls
+// about the video, e.g. to set its thumbnail. Its requests are retried like
+// those of the uploads.
+func (u *Uploader) Service() *youtube.Service {
+	return u.service
+}
+
+// Progress returns the progress of the current or last upload
+func (u *Uploader) Progress() Progress {
+	return u.transport.Progress()
+}
+
+// Upload uploads the video read from src, which is size bytes long or 0 if
+// the size is not known, and adds it to meta's playlists. If the video was
+// uploaded but adding it to a playlist failed, the video is returned along
+// with the error. Cancelling ctx stops the upload.
+func (u *Uploader) Upload(ctx context.Context, src io.Reader, size int64, meta Meta) (*youtube.Video, error) {
+	video, err := u.UploadVideo(ctx, src, size, VideoUpload{Video: meta.Video()})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range meta.PlaylistIDs {
+		if err := u.AddToPlaylist(ctx, id, video.Id); err != nil {
+			return video, fmt.Errorf("error adding video %s to playlist %s: %w", video.Id, id, err)
+		}
+	}
+	return video, nil
+}
+
+// VideoUpload is an upload of a video with all of the metadata the API
+// takes, for UploadVideo
+type VideoUpload struct {
+	// Video is the video resource, which must have a title
+	Video *youtube.Video
+
+	// Parts are the parts of Video that are set. They default to snippet
+	// and status.
+	Parts []string
+
+	// NotifySubscribers, if set, overrides Options.NotifySubscribers
+	NotifySubscribers *bool
+
+	// Resume, if set, continues the upload session of an earlier upload of
+	// the same video, which then has to be read from an io.ReaderAt
+	Resume *Session
+
+	// SessionChanged, if set, is called when the upload session starts and
+	// each time Youtube acknowledges more of the video, e.g. to save the
+	// session for resuming the upload after an interruption
+	SessionChanged func(Session)
+}
+
+// UploadVideo uploads the video read from src, which is size bytes long or
+// 0 if the size is not known. If the upload session expires, e.g. after a
+// long pause, the video is sent again in a new session if src is an
+// io.Seeker. Cancelling ctx stops the upload.
+func (u *Uploader) UploadVideo(ctx context.Context, src io.Reader, size int64, v VideoUpload) (*youtube.Video, error) {
+	if v.Video == nil || v.Video.Snippet == nil || v.Video.Snippet.Title == "" {
+		return nil, errors.New("video title is empty")
+	}
+	parts := v.Parts
+	if len(parts) == 0 {
+		parts = []string{"snippet", "status"}
+	}
+	notify := u.opts.NotifySubscribers
+	if v.NotifySubscribers != nil {
+		notify = *v.NotifySubscribers
+	}
+
+	var resumeFrom io.ReaderAt
+	var offset int64
+	if v.Resume != nil {
+		var ok bool
+		if resumeFrom, ok = src.(io.ReaderAt); !ok {
+			return nil, errors.New("an upload can only be resumed from a source that can be read at any offset")
+		}
+		offset = v.Resume.Offset
+	}
+
+	t := u.transport
+	t.reset(size, offset)
+
+	// insert starts an upload session and sends the video in it
+	insert := func(ctx context.Context) (*youtube.Video, error) {
+		call := u.service.Videos.Insert(parts, v.Video).NotifySubscribers(notify).
+			Media(src, googleapi.ChunkSize(u.opts.ChunkSize))
+		// the progress updater belongs to the media, so it is set after it
+		if u.opts.Progress != nil {
+			call = call.ProgressUpdater(func(current, total int64) {
+				u.opts.Progress(t.Progress())
+			})
+		}
+		return call.Context(ctx).Do()
+	}
+
+	// an attempt is cancelled if the keepalive finds that its session has
+	// expired
+	attempt, cancelAttempt := context.WithCancel(ctx)
+	t.useSession(v.Resume, cancelAttempt, v.SessionChanged)
+	stopKeepalive := t.keepSessionAlive(u.client)
+	var video *youtube.Video
+	var err error
+	if resumeFrom != nil {
+		video, err = u.resume(attempt, *v.Resume, resumeFrom, size)
+	} else {
+		video, err = insert(attempt)
+	}
+	for restarts := 0; err != nil && ctx.Err() == nil && t.sessionExpired(); restarts++ {
+		// a new session starts from nothing, so the whole video has to be
+		// sent again
+		seeker, ok := src.(io.Seeker)
+		if !ok {
+			err = fmt.Errorf("the upload session expired, and the video can't be sent again from the start in a new session as its source can't be read twice: %w", err)
+			break
+		}
+		if restarts == maxSessionRestarts {
+			err = fmt.Errorf("the upload session expired %d times: %w", restarts+1, err)
+			break
+		}
+		if _, serr := seeker.Seek(0, io.SeekStart); serr != nil {
+			err = fmt.Errorf("the upload session expired, and the video can't be sent again: %s", serr)
+			break
+		}
+		t.printf("\n%s: the upload session expired, starting a new session and sending the video again from the start\n", time.Now().Format(time.RFC3339))
+		cancelAttempt()
+		attempt, cancelAttempt = context.WithCancel(ctx)
+		t.restartSession(cancelAttempt)
+		video, err = insert(attempt)
+	}
+	stopKeepalive()
+	cancelAttempt()
+	t.useSession(nil, nil, nil)
+	return video, err
+}
@@ -0,0 +1,156 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// fakeAPI is a fake of the Youtube API endpoints Upload uses: a resumable
+// upload session, sent in chunks, and inserting playlist items
+type fakeAPI struct {
+	t *testing.T
+
+	mu        sync.Mutex
+	title     string
+	received  bytes.Buffer
+	ranges    []string
+	playlists []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	switch {
+	case r.URL.Path == "/upload/youtube/v3/videos" && query.Get("upload_id") == "":
+		var video youtube.Video
+		if err := json.NewDecoder(r.Body).Decode(&video); err != nil {
+			f.t.Errorf("bad metadata: %s", err)
+		}
+		f.title = video.Snippet.Title
+		w.Header().Set("Location", "https://youtube.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&upload_id=session")
+		w.WriteHeader(http.StatusOK)
+
+	case r.URL.Path == "/upload/youtube/v3/videos":
+		contentRange := r.Header.Get("Content-Range")
+		f.ranges = append(f.ranges, contentRange)
+		var start, end int64
+		var total string
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+			http.Error(w, "bad Content-Range", http.StatusBadRequest)
+			return
+		}
+		f.received.ReadFrom(r.Body)
+		if total == "*" {
+			w.Header().Set("X-Http-Status-Code-Override", "308")
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", end))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		fmt.Fprintf(w, `{"id": "video", "snippet": {"title": %q}}`, f.title)
+
+	case r.URL.Path == "/youtube/v3/playlistItems":
+		var item youtube.PlaylistItem
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			f.t.Errorf("bad playlist item: %s", err)
+		}
+		if item.Snippet.PlaylistId == "missing" {
+			http.Error(w, `{"error": {"code": 404, "message": "playlist not found"}}`, http.StatusNotFound)
+			return
+		}
+		f.playlists = append(f.playlists, item.Snippet.PlaylistId+":"+item.Snippet.ResourceId.VideoId)
+		fmt.Fprint(w, `{}`)
+
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+// redirect sends every request to the test server
+type redirect struct {
+	server *url.URL
+}
+
+func (rd redirect) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = rd.server.Scheme, rd.server.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestUpload(t *testing.T) {
+	const size = 600000
+	fake := &fakeAPI{t: t}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	var progressCalls int
+	u, err := New(&http.Client{Transport: redirect{serverURL}}, Options{
+		ChunkSize: 256 << 10,
+		Progress:  func(Progress) { progressCalls++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	video, err := u.Upload(context.Background(), bytes.NewReader(data), size, Meta{
+		Title:       "Upload",
+		PlaylistIDs: []string{"first", "second"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Id != "video" || fake.title != "Upload" {
+		t.Errorf("got video %q titled %q", video.Id, fake.title)
+	}
+	wantRanges := "bytes 0-262143/*, bytes 262144-524287/*, bytes 524288-599999/600000"
+	if got := strings.Join(fake.ranges, ", "); got != wantRanges {
+		t.Errorf("got chunks %q, want %q", got, wantRanges)
+	}
+	if !bytes.Equal(fake.received.Bytes(), data) {
+		t.Error("the server received bytes that differ from the video")
+	}
+	if got := strings.Join(fake.playlists, ", "); got != "first:video, second:video" {
+		t.Errorf("got playlist items %q", got)
+	}
+	if p := u.Progress(); p.Sent != size || p.Size != size || !p.AllSent {
+		t.Errorf("got progress %+v", p)
+	}
+	if progressCalls == 0 {
+		t.Error("Options.Progress wasn't called")
+	}
+
+	err = u.AddToPlaylist(context.Background(), "missing", "video")
+	if !errors.Is(err, ErrPlaylistNotFound) {
+		t.Errorf("got %v, want ErrPlaylistNotFound", err)
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// limits imposed by the Youtube API
+const (
+	maxTitleChars = 100
+	maxTagsChars  = 500
+)
+
+// validationError lists every problem found with the video metadata
+type validationError []string
+
+func (v validationError) Error() string {
+	return "invalid video metadata:\n  - " + strings.Join(v, "\n  - ")
+}
+
+// validateVideo checks the video metadata against the API's rules so that
+// problems are found before the upload rather than after it. Title and
+// description checks are skipped for fields which are templates, as they
+// must be checked after expansion.
+func validateVideo(video *youtube.Video, templates *videoTemplates) error {
+	var problems validationError
+	add := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if templates == nil || templates.title == nil {
+		// an empty title is derived from the file name, or leaves the title
+		// unchanged when updating a video
+		n := utf8.RuneCountInString(video.Snippet.Title)
+		if n > maxTitleChars {
+			add(fmt.Errorf("title is %d characters, limit is %d", n, maxTitleChars))
+		}
+		if strings.ContainsAny(video.Snippet.Title, "<>") {
+			add(fmt.Errorf("title contains '<' or '>', which are not allowed"))
+		}
+	}
+	if templates == nil || templates.description == nil {
+		if n := len(video.Snippet.Description); n > maxDescriptionBytes {
+			add(fmt.Errorf("description is %d bytes, limit is %d", n, maxDescriptionBytes))
+		}
+		if strings.ContainsAny(video.Snippet.Description, "<>") {
+			add(fmt.Errorf("description contains '<' or '>', which are not allowed"))
+		}
+	}
+
+	// Youtube limits the length of the tags together, not of each tag, so a
+	// long tag is caught by the total
+	for _, tag := range video.Snippet.Tags {
+		if strings.ContainsAny(tag, "<>") {
+			add(fmt.Errorf("tag '%s' contains '<' or '>', which are not allowed", tag))
+		}
+	}
+	if n := tagsLength(video.Snippet.Tags); n > maxTagsChars {
+		add(fmt.Errorf("tags are %d characters in total, limit is %d", n, maxTagsChars))
+	}
+
+	if len(video.Localizations) > 0 && video.Snippet.DefaultLanguage == "" && *videoID == "" {
+		add(fmt.Errorf("localizations require the video's language to be set"))
+	}
+	for _, lang := range sortedKeys(video.Localizations) {
+		l := video.Localizations[lang]
+		add(validateLanguage(lang))
+		if l.Title == "" {
+			add(fmt.Errorf("%s localization: title is empty", lang))
+		}
+		if n := utf8.RuneCountInString(l.Title); n > maxTitleChars {
+			add(fmt.Errorf("%s localization: title is %d characters, limit is %d", lang, n, maxTitleChars))
+		}
+		if n := len(l.Description); n > maxDescriptionBytes {
+			add(fmt.Errorf("%s localization: description is %d bytes, limit is %d", lang, n, maxDescriptionBytes))
+		}
+		if strings.ContainsAny(l.Title+l.Description, "<>") {
+			add(fmt.Errorf("%s localization: title or description contains '<' or '>', which are not allowed", lang))
+		}
+	}
+
+	add(validateCategoryId(video.Snippet.CategoryId))
+	add(validateLanguage(video.Snippet.DefaultLanguage))
+	add(validateLanguage(video.Snippet.DefaultAudioLanguage))
+	add(validatePrivacy(video.Status.PrivacyStatus))
+	add(validateLicense(video.Status.License))
+	add(validateMonetization(video.MonetizationDetails))
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// validateMonetization checks the regions excluded from monetization, which
+// only make sense when it is allowed
+func validateMonetization(m *youtube.VideoMonetizationDetails) error {
+	if m == nil || m.Access == nil || len(m.Access.Exception) == 0 {
+		return nil
+	}
+	if !m.Access.Allowed {
+		return fmt.Errorf("monetizationExcludedRegions requires monetizationAllowed to be true")
+	}
+	for _, region := range m.Access.Exception {
+		if len(region) != 2 || strings.Trim(region, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return fmt.Errorf("monetizationExcludedRegions: '%s' is not a two letter region code e.g. US", region)
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the languages of localizations in order, so that
+// problems are reported consistently
+func sortedKeys(localizations map[string]youtube.VideoLocalization) []string {
+	var keys []string
+	for k := range localizations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// tagsLength returns the length of tags as counted by Youtube. Tags are
+// separated by commas, and tags containing spaces are quoted.
+func tagsLength(tags []string) int {
+	var n int
+	for i, tag := range tags {
+		if i > 0 {
+			n++
+		}
+		n += utf8.RuneCountInString(tag)
+		if strings.Contains(tag, " ") {
+			n += 2
+		}
+	}
+	return n
+}
+
+// truncateVideo clips the title, description and tags, and those of any
+// localizations, to Youtube's limits for -truncate, warning in w about each
+// field that is changed
+func truncateVideo(video *youtube.Video, w *warningList) {
+	snippet := video.Snippet
+	snippet.Title = truncateTitle(snippet.Title, "Title", w)
+	snippet.Description = truncateDescription(snippet.Description, "Description", w)
+	if n := tagsLength(snippet.Tags); n > maxTagsChars {
+		tags := snippet.Tags
+		for tagsLength(tags) > maxTagsChars {
+			tags = tags[:len(tags)-1]
+		}
+		w.warnf("Dropped %d tag(s) to keep the tags within %d characters, from %d", len(snippet.Tags)-len(tags), maxTagsChars, n)
+		snippet.Tags = tags
+	}
+	for _, lang := range sortedKeys(video.Localizations) {
+		l := video.Localizations[lang]
+		l.Title = truncateTitle(l.Title, lang+" title", w)
+		l.Description = truncateDescription(l.Description, lang+" description", w)
+		video.Localizations[lang] = l
+	}
+}
+
+func truncateTitle(title, name string, w *warningList) string {
+	if n := utf8.RuneCountInString(title); n > maxTitleChars {
+		title = string([]rune(title)[:maxTitleChars])
+		w.warnf("%s truncated from %d to %d characters", name, n, maxTitleChars)
+	}
+	return title
+}
+
+func truncateDescription(description, name string, w *warningList) string {
+	if n := len(description); n > maxDescriptionBytes {
+		d := description[:maxDescriptionBytes]
+		// don't leave a partial UTF-8 sequence at the end
+		for len(d) > 0 && !utf8.ValidString(d) {
+			d = d[:len(d)-1]
+		}
+		w.warnf("%s truncated from %d to %d bytes", name, n, len(d))
+		return d
+	}
+	return description
+}
+
+// the values the API accepts for the privacy status and license
+var (
+	privacyStatuses = []string{"public", "unlisted", "private"}
+	licenses        = []string{"youtube", "creativeCommon"}
+)
+
+// normalizeEnum returns the one of allowed that value matches ignoring case,
+// so that e.g. 'Private' is sent as 'private', or value unchanged if there
+// is none, for the validation to report
+func normalizeEnum(value string, allowed []string) string {
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return a
+		}
+	}
+	return value
+}
+
+// validateEnum checks that value, if set, is one of allowed, naming them if
+// it isn't
+func validateEnum(name, value string, allowed []string) error {
+	if value == "" {
+		return nil
+	}
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	choices := make([]string, len(allowed))
+	for i, a := range allowed {
+		choices[i] = "'" + a + "'"
+	}
+	last := len(choices) - 1
+	list := choices[last]
+	if last > 0 {
+		list = strings.Join(choices[:last], ", ") + " or " + list
+	}
+	return fmt.Errorf("%s '%s' is not valid, must be %s", name, value, list)
+}
+
+// validatePrivacy checks the privacy status is one accepted by the API
+func validatePrivacy(privacy string) error {
+	return validateEnum("privacy status", privacy, privacyStatuses)
+}
+
+// validateLicense checks the license is one accepted by the API
+func validateLicense(license string) error {
+	return validateEnum("license", license, licenses)
+}
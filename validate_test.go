@@ -0,0 +1,63 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestEnums(t *testing.T) {
+	tests := []struct {
+		name, value string
+		allowed     []string
+		// want is the normalised value and err the validation error of it,
+		// "" if it is valid
+		want, err string
+	}{
+		{"privacy status", "private", privacyStatuses, "private", ""},
+		{"privacy status", "Private", privacyStatuses, "private", ""},
+		{"privacy status", "UNLISTED", privacyStatuses, "unlisted", ""},
+		{"privacy status", "hidden", privacyStatuses, "hidden", "privacy status 'hidden' is not valid, must be 'public', 'unlisted' or 'private'"},
+		{"privacy status", " public", privacyStatuses, " public", "privacy status ' public' is not valid, must be 'public', 'unlisted' or 'private'"},
+		// unset is left to the defaults
+		{"privacy status", "", privacyStatuses, "", ""},
+		{"license", "creativecommon", licenses, "creativeCommon", ""},
+		{"license", "Youtube", licenses, "youtube", ""},
+		{"license", "cc", licenses, "cc", "license 'cc' is not valid, must be 'youtube' or 'creativeCommon'"},
+		{"option", "b", []string{"a"}, "b", "option 'b' is not valid, must be 'a'"},
+	}
+	for _, test := range tests {
+		got := normalizeEnum(test.value, test.allowed)
+		if got != test.want {
+			t.Errorf("normalizeEnum(%q) = %q, want %q", test.value, got, test.want)
+		}
+		err := validateEnum(test.name, got, test.allowed)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("validateEnum(%q): %v", got, err)
+		case test.err != "" && (err == nil || err.Error() != test.err):
+			t.Errorf("validateEnum(%q) = %v, want %q", got, err, test.err)
+		}
+	}
+}
+
+func TestMetaEnumsNormalised(t *testing.T) {
+	video, _ := loadMeta(t, `{"privacyStatus": "Unlisted", "license": "CREATIVECOMMON"}`)
+	if video.Status.PrivacyStatus != "unlisted" || video.Status.License != "creativeCommon" {
+		t.Errorf("got privacy %q and license %q", video.Status.PrivacyStatus, video.Status.License)
+	}
+	video, _ = loadMeta(t, `{"title": "x", "privacyStatus": "hidden"}`)
+	if err := validateVideo(video, nil); err == nil {
+		t.Error("no error for privacyStatus 'hidden' from the meta JSON")
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"google.golang.org/api/youtube/v3"
+)
+
+// maxPollInterval caps the backoff between status checks of -status -watch
+const maxPollInterval = 5 * time.Minute
+
+// videoStatus is the status of an existing video shown by -status
+type videoStatus struct {
+	VideoID                 string `json:"videoId"`
+	UploadStatus            string `json:"uploadStatus,omitempty"`
+	FailureReason           string `json:"failureReason,omitempty"`
+	RejectionReason         string `json:"rejectionReason,omitempty"`
+	ProcessingStatus        string `json:"processingStatus,omitempty"`
+	ProcessingFailureReason string `json:"processingFailureReason,omitempty"`
+	PartsTotal              uint64 `json:"partsTotal,omitempty"`
+	PartsProcessed          uint64 `json:"partsProcessed,omitempty"`
+	TimeLeftMs              uint64 `json:"timeLeftMs,omitempty"`
+	ThumbnailsAvailability  string `json:"thumbnailsAvailability,omitempty"`
+	PrivacyStatus           string `json:"privacyStatus,omitempty"`
+}
+
+func newVideoStatus(video *youtube.Video) *videoStatus {
+	vs := &videoStatus{VideoID: video.Id}
+	if s := video.Status; s != nil {
+		vs.UploadStatus = s.UploadStatus
+		vs.FailureReason = s.FailureReason
+		vs.RejectionReason = s.RejectionReason
+		vs.PrivacyStatus = s.PrivacyStatus
+	}
+	if pd := video.ProcessingDetails; pd != nil {
+		vs.ProcessingStatus = pd.ProcessingStatus
+		vs.ProcessingFailureReason = pd.ProcessingFailureReason
+		vs.ThumbnailsAvailability = pd.ThumbnailsAvailability
+		if pp := pd.ProcessingProgress; pp != nil {
+			vs.PartsTotal = pp.PartsTotal
+			vs.PartsProcessed = pp.PartsProcessed
+			vs.TimeLeftMs = pp.TimeLeftMs
+		}
+	}
+	return vs
+}
+
+// done reports whether Youtube has finished with the video, one way or the
+// other
+func (vs *videoStatus) done() bool {
+	switch vs.UploadStatus {
+	case "processed", "failed", "rejected", "deleted":
+		return true
+	}
+	switch vs.ProcessingStatus {
+	case "succeeded", "failed", "terminated":
+		return true
+	}
+	return false
+}
+
+// failed reports whether the upload or processing of the video failed
+func (vs *videoStatus) failed() bool {
+	switch vs.UploadStatus {
+	case "failed", "rejected", "deleted":
+		return true
+	}
+	return vs.ProcessingStatus == "failed" || vs.ProcessingStatus == "terminated"
+}
+
+// print prints the status in text mode
+func (vs *videoStatus) print() {
+	fmt.Printf("Video %s (%s)\n", vs.VideoID, time.Now().Format("15:04:05"))
+	fmt.Printf("  upload status: %s\n", vs.UploadStatus)
+	if vs.FailureReason != "" {
+		fmt.Printf("  failure reason: %s\n", vs.FailureReason)
+	}
+	if vs.RejectionReason != "" {
+		fmt.Printf("  rejection reason: %s\n", vs.RejectionReason)
+	}
+	if vs.ProcessingStatus != "" {
+		fmt.Printf("  processing status: %s\n", vs.ProcessingStatus)
+	}
+	if vs.ProcessingFailureReason != "" {
+		fmt.Printf("  processing failure reason: %s\n", vs.ProcessingFailureReason)
+	}
+	if vs.PartsTotal > 0 {
+		fmt.Printf("  processing progress: %d / %d parts, %s left\n", vs.PartsProcessed, vs.PartsTotal,
+			time.Duration(vs.TimeLeftMs)*time.Millisecond)
+	}
+	if vs.ThumbnailsAvailability != "" {
+		fmt.Printf("  thumbnails: %s\n", vs.ThumbnailsAvailability)
+	}
+	if vs.PrivacyStatus != "" {
+		fmt.Printf("  privacy: %s\n", vs.PrivacyStatus)
+	}
+}
+
+// showVideoStatus prints the upload and processing status of an existing
+// video for -status. With -watchStatus it polls, with the same backoff as
+// -waitForProcessing, until Youtube has finished with the video. In JSON
+// output mode only the last status is printed. A video that failed or was
+// rejected is an error.
+func showVideoStatus(videoID string, watch bool) error {
+	service, err := newService(readOnlyScopes)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := interruptContext(context.Background())
+	defer cancel()
+
+	interval := *pollInterval
+	deadline := time.Now().Add(*procTimeout)
+	for {
+		response, err := service.Videos.List([]string{"status", "processingDetails"}).Id(videoID).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("error retrieving the status of video %s: %w", videoID, err)
+		}
+		if len(response.Items) == 0 {
+			return withCode(errCodeUsage, fmt.Errorf("video %s not found", videoID))
+		}
+		video := response.Items[0]
+		vs := newVideoStatus(video)
+
+		timedOut := watch && !vs.done() && time.Now().Add(interval).After(deadline)
+		stop := !watch || vs.done() || timedOut
+		if !jsonOutput() {
+			vs.print()
+		} else if stop {
+			json.NewEncoder(os.Stdout).Encode(vs)
+		}
+		if vs.failed() {
+			return withCode(errCodeProcessing, uploader.ProcessingError(video))
+		}
+		if timedOut {
+			return withCode(errCodeTimeout, fmt.Errorf("timed out waiting for video %s to be processed", videoID))
+		}
+		if stop {
+			return nil
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil
+		}
+		interval = interval * 3 / 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
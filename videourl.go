@@ -0,0 +1,82 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	// idFileMu guards writes to -writeIDFile by concurrent uploads
+	idFileMu      sync.Mutex
+	idFileWritten bool
+)
+
+func watchURL(videoID string) string {
+	return "https://www.youtube.com/watch?v=" + videoID
+}
+
+func shortURL(videoID string) string {
+	return "https://youtu.be/" + videoID
+}
+
+func studioURL(videoID string) string {
+	return "https://studio.youtube.com/video/" + videoID + "/edit"
+}
+
+// setVideoURLs fills in the links to the video in result
+func (result *uploadResult) setVideoURLs(videoID string) {
+	result.VideoID = videoID
+	result.URL = watchURL(videoID)
+	result.ShortURL = shortURL(videoID)
+	result.StudioURL = studioURL(videoID)
+}
+
+// printVideoURLs prints the links to the video
+func printVideoURLs(w io.Writer, videoID string) {
+	fmt.Fprintf(w, "  %s\n  %s\n  Edit: %s\n", shortURL(videoID), watchURL(videoID), studioURL(videoID))
+}
+
+// writeIDFile writes videoID to the -writeIDFile file, if set. The file is
+// replaced by the first video of a run, and following videos are added to it
+// one per line.
+func writeIDFile(ctx context.Context, videoID string) {
+	if *idFile == "" {
+		return
+	}
+	idFileMu.Lock()
+	defer idFileMu.Unlock()
+
+	mode := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !idFileWritten {
+		mode |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(*idFile, mode, 0644)
+	if err == nil {
+		_, err = fmt.Fprintln(f, videoID)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		warningsOf(ctx).warnf("Error writing video ID to '%s': %v", *idFile, err)
+		return
+	}
+	idFileWritten = true
+}
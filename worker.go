@@ -0,0 +1,149 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/porjo/youtubeuploader/uploader"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/youtube/v3"
+)
+
+// uploadWorker uploads files one at a time with its own Uploader, so that
+// the progress of each worker is tracked separately, while the rate limit is
+// shared between them
+type uploadWorker struct {
+	transport *uploader.Transport
+	uploader  *uploader.Uploader
+	service   *youtube.Service
+	client    *http.Client
+
+	mu sync.Mutex
+	// filename, title and size are of the file being uploaded, and started
+	// is when its upload started, for the progress and status displays
+	filename string
+	title    string
+	size     int64
+	started  time.Time
+	// stats are the transfer statistics of the current file
+	stats transferStats
+	// warnings are those of the job the worker is uploading, nil for the
+	// run's
+	warnings *warningList
+}
+
+// newUploadWorker returns a worker whose uploads are limited by limit
+// during the window lr. It sends its requests with http.DefaultTransport,
+// and can only upload once it has been connected.
+func newUploadWorker(lr uploader.Window, limit *uploader.Limit) *uploadWorker {
+	w := &uploadWorker{}
+	w.transport = uploader.NewTransport(http.DefaultTransport, uploader.Options{
+		Limit:            limit,
+		LimitWindow:      lr,
+		StallTimeout:     *stallTimeout,
+		MaxStalls:        *maxStalls,
+		MaxRetryTime:     *maxRetryTime,
+		SessionKeepalive: *keepalive,
+		Pause:            &uploadPause,
+		Warnf:            w.warnf,
+		Printf:           func(format string, a ...interface{}) { fmt.Fprintf(output, format, a...) },
+		Observe:          w.observe,
+	})
+	return w
+}
+
+// connect authorises the worker's requests with ts
+func (w *uploadWorker) connect(ts oauth2.TokenSource) error {
+	w.client = newOAuthClient(ts, w.transport)
+	// -chunksize 0 sends the whole file in one request
+	chunk := int(chunksize)
+	if chunk == 0 {
+		chunk = -1
+	}
+	u, err := uploader.New(w.client, uploader.Options{Transport: w.transport, ChunkSize: chunk})
+	if err != nil {
+		return withCode(errCodeAuth, fmt.Errorf("Error creating Youtube client: %s", err))
+	}
+	w.uploader = u
+	w.service = u.Service()
+	return nil
+}
+
+// warnf adds a warning to those of the job being uploaded
+func (w *uploadWorker) warnf(format string, a ...interface{}) {
+	w.mu.Lock()
+	warnings := w.warnings
+	w.mu.Unlock()
+	warnings.warnf(format, a...)
+}
+
+// setWarnings sets the warnings of the job being uploaded, nil for the run's
+func (w *uploadWorker) setWarnings(warnings *warningList) {
+	w.mu.Lock()
+	w.warnings = warnings
+	w.mu.Unlock()
+}
+
+// observe times the requests for -timing and records their statistics
+func (w *uploadWorker) observe(r *http.Request) (*http.Request, func(uploader.Sent)) {
+	newConn := func() *connTiming { return nil }
+	if *timing {
+		r, newConn = traceConnection(r)
+	}
+	return r, func(s uploader.Sent) {
+		if s.Media {
+			atomic.AddInt64(&bytesSentTotal, s.BodySent)
+		}
+		w.record(s)
+		if *timing {
+			w.recordTiming(s, newConn())
+		}
+	}
+}
+
+// start prepares the worker for the upload of a new file of size bytes
+func (w *uploadWorker) start(filename, title string, size int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.filename = filename
+	w.title = title
+	w.size = size
+	w.started = time.Now()
+	w.stats = transferStats{}
+}
+
+// finish marks the end of the upload of the current file
+func (w *uploadWorker) finish() {
+	w.mu.Lock()
+	w.filename = ""
+	w.mu.Unlock()
+}
+
+// current returns the file being uploaded, "" if there is none, and its size
+func (w *uploadWorker) current() (string, int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.filename, w.size
+}
+
+// progress returns the progress of the upload of the current file
+func (w *uploadWorker) progress() uploader.Progress {
+	return w.transport.Progress()
+}
@@ -18,12 +18,14 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/porjo/youtubeuploader/uploader"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/youtube/v3"
@@ -31,219 +33,754 @@ import (
 
 type chanChan chan chan struct{}
 
+var defaultScopes = []string{youtube.YoutubeUploadScope, youtube.YoutubepartnerScope, youtube.YoutubeScope}
+
+// readOnlyScopes are requested by the commands that only list things. A
+// token granted the default scopes covers them.
+var readOnlyScopes = []string{youtube.YoutubeReadonlyScope}
+
+// stringList is a flag that may be repeated and/or given a comma separated
+// list of values
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
+	}
+	return nil
+}
+
+// multiFlag is a flag that may be repeated
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// optionalFlag is a flag that may be given with or without a value
+type optionalFlag struct {
+	set   bool
+	value string
+}
+
+func (o *optionalFlag) String() string {
+	return o.value
+}
+
+func (o *optionalFlag) Set(value string) error {
+	switch value {
+	case "true":
+		o.set, o.value = true, ""
+	case "false":
+		o.set, o.value = false, ""
+	default:
+		o.set, o.value = true, value
+	}
+	return nil
+}
+
+// IsBoolFlag lets the flag be given without a value
+func (o *optionalFlag) IsBoolFlag() bool {
+	return true
+}
+
+// arg returns the value of the flag. As a bool flag can't take a separate
+// value, -flag value leaves the value as the only argument.
+func (o *optionalFlag) arg() string {
+	if o.value == "" && flag.NArg() == 1 {
+		return flag.Arg(0)
+	}
+	return o.value
+}
+
 var (
-	filename       = flag.String("filename", "", "Filename to upload. Can be a URL")
 	thumbnail      = flag.String("thumbnail", "", "Thumbnail to upload. Can be a URL")
-	caption        = flag.String("caption", "", "Caption to upload. Can be URL")
-	title          = flag.String("title", "Video Title", "Video title")
+	title          = flag.String("title", "", "Video title. Defaults to one derived from the file name")
+	titleFromFile  = flag.Bool("titleFromFilename", false, "Derive the title from the file name, even if a title is given")
+	shorts         = flag.Bool("shorts", false, "Check that each video is a Short: at most 60 seconds long and vertical or square. Local MP4, MOV, Matroska and WebM files are checked")
+	shortsTag      = flag.Bool("shortsTag", false, "Add #Shorts to the title, or the description if the title is too long, unless one of them has it")
+	titleCase      = flag.Bool("titleCase", false, "Capitalise each word of titles derived from the file name")
 	description    = flag.String("description", "uploaded by youtubeuploader", "Video description")
+	descFile       = flag.String("descriptionFile", "", "UTF-8 text file containing the video description. Takes precedence over -description and metaJSON")
 	language       = flag.String("language", "en", "Video language")
+	audioLanguage  = flag.String("audioLanguage", "", "Video audio language, if different from -language")
 	categoryId     = flag.String("categoryId", "", "Video category Id")
-	tags           = flag.String("tags", "", "Comma separated list of video tags")
+	category       = flag.String("category", "", "Video category name e.g. Gaming. Ignored if a category Id is given")
+	categoryRegion = flag.String("categoryRegion", "US", "Region code used to look up the -category name")
+	tags           = flag.String("tags", "", "Comma separated list of video tags. Tags containing commas can be quoted e.g. '\"Let's Play, Episode 1\",games'")
 	privacy        = flag.String("privacy", "private", "Video privacy status")
+	premiere       = flag.String("premiere", "", "Keep the video private and schedule it to be published at this RFC3339 time e.g. 2024-06-01T18:00:00+01:00, printing its watch URL")
+	embeddable     = flag.Bool("embeddable", true, "Allow the video to be embedded on other websites")
+	license        = flag.String("license", "", "Video license: 'youtube' or 'creativeCommon'")
+	publicStats    = flag.Bool("publicStatsViewable", true, "Allow the video's statistics to be viewed by anyone")
+	notify         = flag.Bool("notifySubscribers", true, "Notify channel subscribers of the new video. Overrides the metaJSON value when given")
 	quiet          = flag.Bool("quiet", false, "Suppress progress indicator")
-	rate           = flag.Int("ratelimit", 0, "Rate limit upload in kbps. No limit by default")
-	metaJSON       = flag.String("metaJSON", "", "JSON file containing title,description,tags etc (optional)")
+	progressTo     = flag.String("progressTo", "stdout", "Where to show progress: 'stdout', 'stderr' or 'none'. Progress is not shown on stdout in JSON output mode")
+	progressFormat = flag.String("progress", "text", "Progress format: 'text', or 'json' to write a JSON object per -progressInterval for wrappers, to stderr unless -progressTo or -progressFile is given")
+	progressFile   = flag.String("progressFile", "", "File or named pipe to write progress to instead of -progressTo")
+	progressRaw    = flag.Bool("progressRaw", false, "Show the bytes sent on the progress line as plain numbers of bytes rather than e.g. 1.50 GiB")
+	progressEvery  = flag.Duration("progressInterval", 0, "Interval between progress updates. Defaults to 1s on a terminal or with -progress json, and 30s otherwise")
+	stallTimeout   = flag.Duration("stallTimeout", 2*time.Minute, "Restart the upload of a chunk if no data is sent for this long. 0 disables stall detection")
+	keepalive      = flag.Duration("sessionKeepalive", 5*time.Minute, "When no chunk has been sent for this long, e.g. while paused or waiting to retry, ask Youtube for the upload's progress to keep the session alive and notice early if it expired. 0 disables it")
+	maxRetryTime   = flag.Duration("maxTotalRetryTime", 30*time.Minute, "Longest time to spend waiting to retry requests that Youtube rate limited (429 or 503) for each file, before giving up. 0 means no limit")
+	tokenMargin    = flag.Duration("tokenRefreshMargin", 5*time.Minute, "Refresh the access token before a request, e.g. each upload chunk, once less than this is left before it expires")
+	maxStalls      = flag.Int("maxStalls", 5, "Number of consecutive stalls after which the upload fails")
+	metaJSON       = flag.String("metaJSON", "", "JSON or YAML (.yaml/.yml) file containing title,description,tags etc (optional)")
+	metaFromFile   = flag.Bool("metaFromFile", false, "Use the title, description or comment, and creation date embedded in MP4, MOV and Matroska files when the flags and metaJSON don't give them")
+	rampUp         = flag.Duration("rampUp", 0, "Start at 10% of -ratelimit and increase it steadily to the full rate over this time, e.g. 2m")
+	rateFile       = flag.String("ratelimitFile", "", "File containing a new -ratelimit, read when the process receives SIGHUP. 0 means no limit")
 	limitBetween   = flag.String("limitBetween", "", "Only rate limit between these times e.g. 10:00-14:00 (local time zone)")
-	headlessAuth   = flag.Bool("headlessAuth", false, "set this if no browser available for the oauth authorisation step")
-	oAuthPort      = flag.Int("oAuthPort", 8080, "TCP port to listen on when requesting an oAuth token")
+	headlessAuth   = flag.Bool("headlessAuth", false, "set this if no browser available for the oauth authorisation step. The authorisation URL is printed; open it in a browser elsewhere, authorise, and paste back the http://localhost URL the browser is redirected to, even though the page fails to load. Requires client secrets for a 'Desktop app' application type")
+	oAuthPort      = flag.Int("oAuthPort", 8080, "TCP port to listen on when requesting an oAuth token. 0 picks a free port")
+	oAuthHost      = flag.String("oAuthHost", "localhost", "Host name of the oAuth redirect URI. The listener accepts connections on all interfaces")
+	configFile     = flag.String("config", "", "JSON file of default flag values. Defaults to config.json in the config directory e.g. ~/.config/youtubeuploader")
+	showConfig     = flag.Bool("printConfig", false, "Print the flag values after applying the config file and environment, and exit")
 	showAppVersion = flag.Bool("v", false, "show version")
-	chunksize      = flag.Int("chunksize", googleapi.DefaultUploadChunkSize, "size (in bytes) of each upload chunk. A zero value will cause all data to be uploaded in a single request")
+	resume         = flag.Bool("resume", false, "Resume an interrupted upload of a local file from its saved upload state")
+	sourceAuth     = flag.String("sourceBasicAuth", "", "user:password for HTTP basic authentication when -filename is a URL. ${VAR} is replaced by the environment variable")
+	sourceRetries  = flag.Int("sourceRetries", 3, "Number of times to reconnect to a URL source that fails part way through, if it supports Range requests")
+	sftpIdentity   = flag.String("sftpIdentity", "", "Private key file to log in to sftp:// sources with. By default ssh-agent and the keys in ~/.ssh are tried, then a password is asked for")
+	sftpInsecure   = flag.Bool("sftpInsecureIgnoreHostKey", false, "Don't check the host keys of sftp:// sources against ~/.ssh/known_hosts. Insecure")
+	filesizeHint   = flag.Int64("filesizeHint", 0, "Estimated size in bytes of the video, for progress display when the size is unknown e.g. reading from stdin")
+	videoID        = flag.String("videoID", "", "ID of an existing video whose metadata should be updated from the flags and -metaJSON. No video is uploaded")
+	setThumb       = flag.String("setThumbnail", "", "ID of an existing video to set the thumbnail of, given by -thumbnail or after the flags: -setThumbnail VIDEO_ID FILE. No video is uploaded")
+	setCaps        = flag.String("setCaptions", "", "ID of an existing video to add captions to, given by -caption or after the flags: -setCaptions VIDEO_ID lang=file. No video is uploaded")
+	strictMeta     = flag.Bool("strictMeta", false, "Treat unknown fields in the metaJSON file as an error")
+	noTemplate     = flag.Bool("noTemplate", false, "Don't expand {{ }} templates in the title and description")
+	templateDate   = flag.String("templateDateFormat", "2006-01-02", "Go time layout of {{.Date}} in title and description templates")
+	templateTime   = flag.String("templateTimeFormat", "15:04", "Go time layout of {{.Time}} in title and description templates")
+	orderBy        = flag.String("orderBy", "", "Order to upload the files in, which {{.Index}} follows: 'name' or 'mtime'. Defaults to the order given")
+	indexWidth     = flag.Int("indexWidth", 0, "Zero-pad {{.Index}} in templates to this many digits, e.g. 2 for 01")
+	truncate       = flag.Bool("truncate", false, "Clip the title, description and tags to Youtube's limits instead of failing")
+	dryRun         = flag.Bool("dryRun", false, "Validate the files and metadata and print what would be uploaded, without uploading anything")
+	waitProcessing = flag.Bool("waitForProcessing", false, "Wait for Youtube to finish processing the video and report the result")
+	pollInterval   = flag.Duration("processingPollInterval", 10*time.Second, "Initial interval between processing status checks. It increases as processing continues")
+	deleteFailed   = flag.Bool("deleteOnProcessingFailure", false, "With -waitForProcessing, delete the video if Youtube fails or rejects it, so that it doesn't stay on the channel")
+	procTimeout    = flag.Duration("processingTimeout", time.Hour, "Maximum time to wait for processing with -waitForProcessing")
+	outputFormat   = flag.String("out", "text", "Output format: 'text' or 'json'. json prints a single JSON object describing the upload on stdout")
+	sidecar        = flag.Bool("sidecar", false, "Use the metadata (.json/.yaml), thumbnail (.jpg/.png) and caption (.srt/.vtt) files with the same name as each video, if they exist")
+	concurrency    = flag.Int("concurrency", 1, "Number of files to upload at the same time. -ratelimit is shared between them")
+	failFast       = flag.Bool("failFast", false, "Stop uploading remaining files after the first failure")
+	quotaRetry     = flag.Bool("retryAfterQuota", false, "When a daily upload limit or API quota is reached, wait until it resets at midnight Pacific time and try again")
+	quotaBudget    = flag.Int("quotaBudget", 0, "Warn before uploading when the estimated API quota cost of the uploads, with what was used today, is over this many units")
+	lockFile       = flag.String("lockFile", "", "Lock file held while uploading, so that another run, e.g. from cron, exits rather than uploading the same files. Defaults to one for each profile in the config directory. 'none' turns locking off")
+	lockWait       = flag.Duration("lockWait", 0, "How long to wait for another run to release the lock file, e.g. 30m. By default the run exits at once")
+	quotaReport    = flag.Bool("quotaReport", false, "Print the estimated API quota used today, by method, and on the previous days and exit")
+	proxy          = flag.String("proxy", "", "Proxy URL for all requests e.g. http://proxy:3128 or socks5://localhost:1080. HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used by default")
+	playlistID     = flag.String("playlistID", "", "Comma separated list of playlist IDs to add the video to")
+	statusOf       = flag.String("status", "", "ID of an existing video whose upload and processing status is shown. No video is uploaded")
+	watchStatus    = flag.Bool("watchStatus", false, "With -status, poll the status until Youtube has finished processing the video")
+	listPlaylists  = flag.Bool("listPlaylists", false, "List the playlists of the channel and exit")
+	createLists    = flag.Bool("createPlaylist", false, "Create the playlists named by -playlist that don't exist")
+	listPrivacy    = flag.String("playlistPrivacy", "", "Privacy status of playlists that are created. Defaults to the video's privacy status")
+	notifyURL      = flag.String("notifyURL", "", "URL to POST a JSON summary of each upload to when it finishes, whether it succeeded or failed")
+	notifyDesk     = flag.Bool("notifyDesktop", false, "Show a desktop notification with the video's title and URL, or the error, when the uploads finish. The terminal bell is rung if none can be shown")
+	notifyTmpl     = flag.String("notifyTemplate", "", "Go template for the -notifyURL request body, instead of the default JSON summary")
+	emailTo        = flag.String("emailTo", "", "Comma separated addresses to email the outcome of each upload to through -smtpServer")
+	emailFrom      = flag.String("emailFrom", "", "From address of the -emailTo emails")
+	emailOn        = flag.String("emailOn", "always", "Which uploads to email about: 'success', 'failure' or 'always'")
+	emailTmpl      = flag.String("emailTemplate", "", "File containing a Go template for the body of the -emailTo emails, instead of the default summary")
+	smtpServer     = flag.String("smtpServer", "", "SMTP server to send -emailTo emails through, as host:port e.g. smtp.example.com:587. STARTTLS is used when the server offers it")
+	smtpUser       = flag.String("smtpUser", "", "User name to authenticate to -smtpServer with")
+	smtpPassFile   = flag.String("smtpPassFile", "", "File containing the password of -smtpUser")
+	notifyTimeout  = flag.Duration("notifyTimeout", 10*time.Second, "Timeout for each -notifyURL request")
+	onSuccess      = flag.String("onSuccess", "", "Shell command to run after each successful upload. VIDEO_ID, VIDEO_URL, FILE, TITLE, BYTES_SENT and DURATION_SECONDS are set in its environment")
+	onFailure      = flag.String("onFailure", "", "Shell command to run after each failed upload, with the same environment as -onSuccess plus ERROR")
+	uploadTimeout  = flag.Duration("timeout", 0, "Maximum time for the uploads, after which they are stopped and their resumable state saved. No limit by default")
+	skipDupes      = flag.Bool("skipDuplicates", false, "Skip files that have been uploaded before, printing the existing video ID. Uploads are recorded in uploaded.json in the config directory")
+	afterUpload    = flag.String("afterUpload", "keep", "What to do with a local file once it has been uploaded (and processed, with -waitForProcessing): 'keep', 'delete' or 'move:<dir>'")
+	serveAddr      = flag.String("serve", "", "Address to accept upload jobs on over HTTP, e.g. localhost:7000, instead of uploading -filename. Any other than a loopback address needs -serveToken. See the README for the API")
+	serveToken     = flag.String("serveToken", "", "Bearer token that -serve requests must have. ${VAR} is replaced by the environment variable")
+	serveQueue     = flag.String("serveQueue", "", "File to keep the -serve job queue in, so that jobs survive a restart. Defaults to jobs.json in the config directory")
+	serveDir       = flag.String("serveDir", "", "Directory that -serve jobs may upload local files from. Without it, jobs can only name http(s), s3:// and gs:// URLs")
+	serveAnySource = flag.Bool("serveAnySource", false, "Let -serve jobs name any source, including local files outside -serveDir and sftp:// URLs")
+	forceAnyType   = flag.Bool("forceUploadAnyType", false, "Upload files that don't look like videos. Normally a file whose start isn't a known video container, or a URL whose Content-Type isn't a video, is refused")
+	checksumAlg    = flag.String("checksum", "none", "Hash the video as it is uploaded and report the checksum: 'sha256', 'md5' or 'none'")
+	checksumPre    = flag.Bool("checksumPre", false, "Also hash local files before uploading them, failing the upload if the file changes while it is being sent. Requires -checksum")
+	dupCheck       = flag.String("duplicateCheck", "sha256", "How -skipDuplicates recognises a file: 'sha256' hashes its contents, 'quick' compares its name, size and modification time")
+	statusAddr     = flag.String("statusAddr", "", "Address to serve the upload status on while uploading, e.g. :8080. /status is JSON and /metrics is for Prometheus")
+	redactPaths    = flag.Bool("statusRedactPaths", false, "Show only the base name of files on -statusAddr, not their full paths")
+	sourceIP       = flag.String("sourceIP", "", "Local IP address to make connections to Youtube and -filename URLs from, for machines with more than one uplink")
+	bindIface      = flag.String("interface", "", "Network interface to make connections from (Linux only). Usually needs root")
+	force4         = flag.Bool("force4", false, "Only connect over IPv4")
+	force6         = flag.Bool("force6", false, "Only connect over IPv6")
+	debugHTTP      = flag.Bool("debugHTTP", false, "Log each HTTP request and response, with secrets redacted, to stderr or -debugLog")
+	debugLog       = flag.String("debugLog", "", "File to append the -debugHTTP log to instead of stderr")
+	apiBaseURL     = flag.String("apiBaseURL", "", "Send the Youtube API requests to this server instead of youtube.googleapis.com, e.g. a fake one for testing")
+	confirm        = flag.Bool("confirm", false, "Show the metadata of each file and ask before uploading")
+	assumeYes      = flag.Bool("yes", false, "Show the metadata like -confirm, but upload without asking")
+	colorMode      = flag.String("color", "auto", "Colour the output: 'auto' to colour it on a terminal unless NO_COLOR is set, 'always' or 'never'")
+	timing         = flag.Bool("timing", false, "Time each request to Youtube: the bytes, transfer time, response latency and status of each chunk, and the DNS, connect and TLS times of new connections. A table and percentiles are printed at the end")
+	speedtest      = flag.Bool("speedtest", false, "Measure the upload rate over the first -speedtestSize of the first upload, show how long the whole file would take and ask whether to carry on. -yes carries on without asking")
+	idFile         = flag.String("writeIDFile", "", "File to write the ID of each uploaded video to, one per line")
+	manifest       = flag.String("manifest", "", "File with a line for each video to upload, each a JSON object with the file and the fields of a metaJSON file. Uploaded lines are recorded in <manifest>.done and skipped next time")
+	historyFile    = flag.String("historyFile", "", "File to append a JSON line to for each upload, successful or not. Defaults to history.jsonl in the config directory. 'none' disables it")
+
+	chunksize     = chunkSizeFlag(googleapi.DefaultUploadChunkSize)
+	speedtestSize = byteSizeFlag(4 << 20)
+	filenames     multiFlag
+	captions      stringList
+	srcHeader     multiFlag
+	showHistory   historyFlag
+	playlists     multiFlag
+	listCats      optionalFlag
+	rate          rateFlag
 
 	// this is set by compile-time to match git tag
 	appVersion string = "unknown"
 )
 
+func init() {
+	flag.Var(&chunksize, "chunksize", "size of each upload chunk e.g. 256K, 8M. Rounded up to a multiple of 256K. A zero value will cause all data to be uploaded in a single request")
+	flag.StringVar(videoID, "updateMeta", "", "Same as -videoID: update the metadata of an existing video from the flags and -metaJSON")
+	flag.Var(&speedtestSize, "speedtestSize", "How much of the upload -speedtest measures the rate over e.g. 512K, 8M")
+	flag.Var(&rate, "ratelimit", "Rate limit upload in kbps, or with a unit e.g. 20Mbps, 2.5MB/s, 800k. No limit by default")
+	flag.Var(&filenames, "filename", "Filename to upload. Can be an http(s), file://, s3://, gs:// or sftp:// URL, a glob pattern or - to read from stdin. May be repeated")
+	flag.Var(&srcHeader, "sourceHeader", "Header to send when -filename is a URL, in the form 'Name: Value'. ${VAR} in the value is replaced by the environment variable. May be repeated")
+	flag.Var(&captions, "caption", "Caption to upload in the form lang=file e.g. en=subs.en.srt. Can be a URL. May be repeated or comma separated")
+	flag.Var(&playlists, "playlist", "Title of a playlist to add the video to, matched ignoring case. May be repeated")
+	flag.Var(&listCats, "listCategories", "List the video categories of -categoryRegion, or -listCategories=XX or -listCategories XX for region XX, and exit")
+	flag.Var(&showHistory, "showHistory", "Print the last 20 uploads from -historyFile and exit. -showHistory=n or -showHistory n prints the last n")
+}
+
 func main() {
+	registerProfileMetaFlags(os.Args[1:])
 	flag.Parse()
 
+	if err := run(); err != nil {
+		if _, ok := err.(reportedError); !ok {
+			reportError("", "", err)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// run performs the uploads, returning any error rather than exiting so that
+// deferred cleanup is done
+func run() error {
 	if *showAppVersion {
 		fmt.Printf("Youtubeuploader version: %s\n", appVersion)
-		os.Exit(0)
+		return nil
 	}
 
-	if *filename == "" {
-		fmt.Printf("You must provide a filename of a video file to upload\n")
-		flag.PrintDefaults()
-		os.Exit(1)
+	if err := applyConfig(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := setColor(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if *showConfig {
+		return printConfig()
 	}
 
-	var reader io.ReadCloser
-	var filesize int64
-	var err error
+	if *profile != "" {
+		if err := validateProfile(*profile); err != nil {
+			return withCode(errCodeUsage, err)
+		}
+	}
 
-	var limitRange limitRange
-	if *limitBetween != "" {
-		limitRange, err = parseLimitBetween(*limitBetween)
+	if *showProfiles {
+		names, err := listProfiles()
 		if err != nil {
-			fmt.Printf("Invalid value for -limitBetween: %v", err)
-			os.Exit(1)
+			return withCode(errCodeUsage, err)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	if showHistory > 0 {
+		// a bool flag can't take a separate value, so -showHistory 5 leaves
+		// the number as an argument
+		if flag.NArg() == 1 {
+			if err := showHistory.Set(flag.Arg(0)); err != nil {
+				return withCode(errCodeUsage, fmt.Errorf("invalid value for -showHistory: %s", err))
+			}
 		}
+		return printHistory(os.Stdout, int(showHistory))
+	}
+	if *quotaReport {
+		return printQuotaReport(os.Stdout)
 	}
+	defer saveQuotaUsage()
 
-	reader, filesize, err = Open(*filename)
+	err := setOutputFormat()
 	if err != nil {
-		log.Fatal(err)
+		return withCode(errCodeUsage, err)
+	}
+	if err := setProgressOutput(); err != nil {
+		return withCode(errCodeUsage, err)
 	}
-	defer reader.Close()
 
-	var thumbReader io.ReadCloser
-	if *thumbnail != "" {
-		thumbReader, _, err = Open(*thumbnail)
+	// the default transport already honours HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY. Replacing it sends the video source downloads through -proxy
+	// as well as the requests to Youtube.
+	if *proxy != "" {
+		http.DefaultTransport, err = proxyTransport(*proxy)
 		if err != nil {
-			log.Fatal(err)
+			return withCode(errCodeUsage, err)
 		}
-		defer thumbReader.Close()
+	}
+	http.DefaultTransport, err = dialOverride(http.DefaultTransport)
+	if err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	baseTransport, _ = http.DefaultTransport.(*http.Transport)
+	http.DefaultTransport, err = apiBaseOverride(http.DefaultTransport)
+	if err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	http.DefaultTransport, err = debugOverride(http.DefaultTransport)
+	if err != nil {
+		return withCode(errCodeUsage, err)
 	}
 
-	var captionReader io.ReadCloser
-	if *caption != "" {
-		captionReader, _, err = Open(*caption)
-		if err != nil {
-			log.Fatal(err)
+	if *statusOf != "" {
+		return showVideoStatus(*statusOf, *watchStatus)
+	}
+	if *listPlaylists {
+		return printPlaylists()
+	}
+	if listCats.set {
+		region := listCats.arg()
+		if region == "" {
+			region = *categoryRegion
 		}
-		defer captionReader.Close()
+		return printCategories(region)
 	}
 
-	ctx := context.Background()
-	transport := &limitTransport{rt: http.DefaultTransport, lr: limitRange, filesize: filesize}
-	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
-		Transport: transport,
-	})
-
-	var quitChan chanChan
-	if !*quiet {
-		quitChan = make(chanChan)
-		go func() {
-			Progress(quitChan, transport, filesize)
-		}()
+	if len(filenames) == 0 && *videoID == "" && *manifest == "" && *setThumb == "" && *setCaps == "" && *serveAddr == "" {
+		fmt.Printf("You must provide a filename of a video file to upload\n")
+		flag.PrintDefaults()
+		return reportedError{errCodeUsage}
 	}
-	client, err := buildOAuthHTTPClient(ctx, []string{youtube.YoutubeUploadScope, youtube.YoutubepartnerScope, youtube.YoutubeScope})
+
+	maint, err := maintenanceFiles()
 	if err != nil {
-		log.Fatalf("Error building OAuth client: %v", err)
+		return withCode(errCodeUsage, err)
 	}
 
-	upload := &youtube.Video{
-		Snippet:          &youtube.VideoSnippet{},
-		RecordingDetails: &youtube.VideoRecordingDetails{},
-		Status:           &youtube.VideoStatus{},
+	files, err := expandFilenames(filenames)
+	if err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := orderFiles(files, *orderBy); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := parseSourceHeaders(srcHeader); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if *sftpInsecure {
+		warnInsecureHostKey()
+	}
+	if err := parseNotifyTemplate(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := parseEmailFlags(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := validateDuplicateCheck(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := validateChecksum(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := validateProfiles(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := validateAfterUpload(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if err := validatePremiere(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+	if *impersonate != "" && *serviceAcct == "" && !*useADC {
+		return withCode(errCodeUsage, fmt.Errorf("-impersonate requires -serviceAccountFile or -useADC"))
+	}
+	if *listPrivacy != "" {
+		*listPrivacy = normalizeEnum(*listPrivacy, privacyStatuses)
+		if err := validatePrivacy(*listPrivacy); err != nil {
+			return withCode(errCodeUsage, fmt.Errorf("invalid -playlistPrivacy: %s", err))
+		}
+	}
+	if *deleteFailed && !*waitProcessing {
+		return withCode(errCodeUsage, fmt.Errorf("-deleteOnProcessingFailure requires -waitForProcessing"))
+	}
+	if *rampUp > 0 && rate == 0 && *rateFile == "" {
+		return withCode(errCodeUsage, fmt.Errorf("-rampUp requires -ratelimit"))
 	}
 
-	videoMeta := LoadVideoMeta(*metaJSON, upload)
+	var limitRange uploader.Window
+	if *limitBetween != "" {
+		limitRange, err = parseLimitBetween(*limitBetween)
+		if err != nil {
+			return withCode(errCodeUsage, fmt.Errorf("Invalid value for -limitBetween: %v", err))
+		}
+	}
 
-	service, err := youtube.New(client)
+	release, err := acquireLocks()
 	if err != nil {
-		log.Fatalf("Error creating Youtube client: %s", err)
+		return err
 	}
+	defer release()
 
-	if upload.Status.PrivacyStatus == "" {
-		upload.Status.PrivacyStatus = *privacy
-	}
-	if upload.Snippet.Tags == nil && strings.Trim(*tags, "") != "" {
-		upload.Snippet.Tags = strings.Split(*tags, ",")
+	if len(profileNames) > 0 {
+		return uploadToProfiles(files, maint, limitRange)
 	}
-	if upload.Snippet.Title == "" {
-		upload.Snippet.Title = *title
+	return uploadFiles(files, maint, limitRange)
+}
+
+// uploadFiles uploads files and the -manifest to the account of the current
+// profile, or updates -videoID, once the flags have been checked
+func uploadFiles(files []string, maint *maintenance, limitRange uploader.Window) error {
+	upload, videoMeta, templates, err := loadVideo(*metaJSON)
+	if err != nil {
+		return withCode(errCodeUsage, err)
 	}
-	if upload.Snippet.Description == "" {
-		upload.Snippet.Description = *description
+
+	var jobs []uploadJob
+	needCaptionScope := len(captions) > 0 || len(maint.captions) > 0
+	for _, file := range files {
+		job := uploadJob{
+			filename:  file,
+			upload:    upload,
+			videoMeta: videoMeta,
+			templates: templates,
+		}
+		if *sidecar {
+			if err := job.findSidecars(); err != nil {
+				return withCode(errCodeUsage, err)
+			}
+		}
+		if len(job.videoMeta.Captions) > 0 {
+			needCaptionScope = true
+		}
+		jobs = append(jobs, job)
 	}
-	if upload.Snippet.CategoryId == "" && *categoryId != "" {
-		upload.Snippet.CategoryId = *categoryId
+
+	var invalidLines int
+	if *manifest != "" && *videoID == "" {
+		manifestJobs, invalid, err := loadManifest(*manifest)
+		if err != nil {
+			return withCode(errCodeUsage, err)
+		}
+		for _, job := range manifestJobs {
+			if len(job.videoMeta.Captions) > 0 {
+				needCaptionScope = true
+			}
+		}
+		jobs = append(jobs, manifestJobs...)
+		invalidLines = invalid
+		if len(jobs) == 0 {
+			fmt.Fprintf(output, "Nothing to upload\n")
+			if invalidLines > 0 {
+				return reportedError{errCodeUsage}
+			}
+			return nil
+		}
 	}
-	if upload.Snippet.DefaultLanguage == "" && *language != "" {
-		upload.Snippet.DefaultLanguage = *language
+
+	for i := range jobs {
+		jobs[i].index, jobs[i].total = i+1, len(jobs)
 	}
-	if upload.Snippet.DefaultAudioLanguage == "" && *language != "" {
-		upload.Snippet.DefaultAudioLanguage = *language
+
+	if *dryRun {
+		if err := checkJobs(jobs); err != nil {
+			return withCode(errCodeUsage, err)
+		}
 	}
 
-	fmt.Printf("Uploading file '%s'...\n", *filename)
+	for _, file := range files {
+		if isURL(file) {
+			if err := checkSourceAccess(file); err != nil {
+				return withCode(errCodeSource, err)
+			}
+		}
+	}
 
-	var option googleapi.MediaOption
-	var video *youtube.Video
+	ctx, cancel := interruptContext(context.Background())
+	defer cancel()
+	limit := uploader.NewLimit(int(rate), *rampUp)
+	if rate > 0 {
+		fmt.Fprintf(output, "Rate limit: %s\n", describeLimit(int(rate)))
+	}
+	worker := newUploadWorker(limitRange, limit)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+		Transport: worker.transport,
+	})
 
-	option = googleapi.ChunkSize(*chunksize)
+	// the default scopes include youtube, which -deleteOnProcessingFailure
+	// needs to delete a video, so it is granted before anything is uploaded
+	scopes := append([]string{}, defaultScopes...)
+	if needCaptionScope || len(videoMeta.Captions) > 0 {
+		scopes = append(scopes, youtube.YoutubeForceSslScope)
+	}
+	ts, err := buildOAuthTokenSource(ctx, scopes)
+	if err != nil {
+		return withCode(errCodeAuth, fmt.Errorf("Error building OAuth client: %v", err))
+	}
 
-	call := service.Videos.Insert("snippet,status,recordingDetails", upload)
-	video, err = call.Media(reader, option).Do()
+	if err := worker.connect(ts); err != nil {
+		return err
+	}
+	service := worker.service
+	if err := checkChannel(service); err != nil {
+		return withCode(errCodeAuth, err)
+	}
 
-	if quitChan != nil {
-		quit := make(chan struct{})
-		quitChan <- quit
-		<-quit
+	if *category != "" {
+		var id string
+		for _, v := range append([]*youtube.Video{upload}, jobVideos(jobs)...) {
+			if v.Snippet.CategoryId != "" {
+				continue
+			}
+			if id == "" {
+				id, err = lookupCategory(service, *category, *categoryRegion)
+				if err != nil {
+					return withCode(errCodeUsage, err)
+				}
+			}
+			v.Snippet.CategoryId = id
+		}
 	}
 
-	if err != nil {
-		if video != nil {
-			log.Fatalf("Error making YouTube API call: %v, %v", err, video.HTTPStatusCode)
-		} else {
-			log.Fatalf("Error making YouTube API call: %v", err)
+	if *setThumb != "" || *setCaps != "" {
+		if err := maint.run(service); err != nil {
+			return err
+		}
+		if *videoID == "" {
+			return nil
 		}
 	}
-	fmt.Printf("Upload successful! Video ID: %v\n", video.Id)
 
-	if thumbReader != nil {
-		log.Printf("Uploading thumbnail '%s'...\n", *thumbnail)
-		_, err = service.Thumbnails.Set(video.Id).Media(thumbReader).Do()
+	if *videoID != "" {
+		video, err := updateVideo(service, *videoID, upload)
 		if err != nil {
-			log.Fatalf("Error making YouTube API call: %v", err)
+			return withCode(errCodeUpload, err)
 		}
-		fmt.Printf("Thumbnail uploaded!\n")
+		fmt.Fprintln(output, colorize(output, styleSuccess, fmt.Sprintf("Video %s updated!", video.Id)))
+		printVideoURLs(output, video.Id)
+		writeIDFile(ctx, video.Id)
+		result := &uploadResult{
+			Title:         video.Snippet.Title,
+			PrivacyStatus: video.Status.PrivacyStatus,
+		}
+		result.setVideoURLs(video.Id)
+		result.Warnings = takeWarnings()
+		writeResult(result)
+		return nil
+	}
+
+	if *serveAddr != "" {
+		return serveJobs(ctx, ts, limitRange, limit)
 	}
 
-	// Insert caption
-	if captionReader != nil {
-		captionObj := &youtube.Caption{
-			Snippet: &youtube.CaptionSnippet{},
+	checkQuotaBudget(jobs)
+	if *dryRun {
+		if err := printDryRun(service, jobs); err != nil {
+			return withCode(errCodeUsage, err)
 		}
-		captionObj.Snippet.VideoId = video.Id
-		captionObj.Snippet.Language = *language
-		captionObj.Snippet.Name = *language
-		captionInsert := service.Captions.Insert("snippet", captionObj).Sync(true)
-		captionRes, err := captionInsert.Media(captionReader).Do()
-		if err != nil {
-			if captionRes != nil {
-				log.Fatalf("Error inserting caption: %v, %v", err, captionRes.HTTPStatusCode)
-			} else {
-				log.Fatalf("Error inserting caption: %v", err)
-			}
+		return nil
+	}
+
+	if err := confirmJobs(jobs); err != nil {
+		return err
+	}
+
+	if *uploadTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, *uploadTimeout)
+		defer cancelTimeout()
+	}
+	if *rateFile != "" {
+		watchRateFile(ctx, *rateFile, limit)
+	}
+	err = uploadAll(ctx, ts, limitRange, limit, jobs)
+	if invalidLines > 0 {
+		fmt.Fprintf(output, "%d line(s) of the manifest were skipped because of errors\n", invalidLines)
+		if err == nil {
+			err = reportedError{errCodeUsage}
 		}
-		fmt.Printf("Caption uploaded!\n")
 	}
+	return err
+}
 
-	plx := &Playlistx{}
-	if upload.Status.PrivacyStatus != "" {
-		plx.PrivacyStatus = upload.Status.PrivacyStatus
+// newService authorises with scopes and returns a Youtube client, for the
+// commands that only call the API rather than upload anything
+func newService(scopes []string) (*youtube.Service, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: http.DefaultTransport,
+	})
+	ts, err := buildOAuthTokenSource(ctx, scopes)
+	if err != nil {
+		return nil, withCode(errCodeAuth, fmt.Errorf("Error building OAuth client: %v", err))
+	}
+	service, err := youtube.New(newOAuthClient(ts, http.DefaultTransport))
+	if err != nil {
+		return nil, withCode(errCodeAuth, fmt.Errorf("Error creating Youtube client: %s", err))
+	}
+	return service, nil
+}
+
+// loadVideo builds the video metadata from the meta file, if any, and the
+// flags, and validates it
+func loadVideo(metaFile string) (*youtube.Video, VideoMeta, *videoTemplates, error) {
+	upload := newVideo()
+	videoMeta, err := LoadVideoMeta(metaFile, upload)
+	if err != nil {
+		return nil, videoMeta, nil, err
+	}
+	upload, templates, err := finishVideo(upload, metaFile)
+	return upload, videoMeta, templates, err
+}
+
+// newVideo returns empty video metadata with the parts that are uploaded
+func newVideo() *youtube.Video {
+	return &youtube.Video{
+		Snippet:          &youtube.VideoSnippet{},
+		RecordingDetails: &youtube.VideoRecordingDetails{},
+		Status:           &youtube.VideoStatus{},
+	}
+}
+
+// finishVideo merges the flags into the video metadata loaded from source,
+// a file name or "" if there is none, and validates it
+func finishVideo(upload *youtube.Video, source string) (*youtube.Video, *videoTemplates, error) {
+	var err error
+	if err := mergeFlags(upload, *videoID != ""); err != nil {
+		return nil, nil, err
 	}
-	// PlaylistID is deprecated in favour of PlaylistIDs
-	if videoMeta.PlaylistID != "" {
-		plx.Id = videoMeta.PlaylistID
-		err = plx.AddVideoToPlaylist(service, video.Id)
+	if *descFile != "" {
+		upload.Snippet.Description, err = readDescriptionFile(*descFile)
 		if err != nil {
-			log.Fatalf("Error adding video to playlist: %s", err)
+			return nil, nil, err
+		}
+	}
+	templates, err := parseVideoTemplates(upload.Snippet)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *truncate {
+		truncateVideo(upload, nil)
+	}
+	if err := validateVideo(upload, templates); err != nil {
+		if source != "" {
+			err = fmt.Errorf("'%s': %s", source, err)
 		}
+		return nil, nil, err
 	}
+	return upload, templates, nil
+}
 
-	if len(videoMeta.PlaylistIDs) > 0 {
-		plx.Title = ""
-		for _, pid := range videoMeta.PlaylistIDs {
-			plx.Id = pid
-			err = plx.AddVideoToPlaylist(service, video.Id)
-			if err != nil {
-				log.Fatalf("Error adding video to playlist: %s", err)
+// jobVideos returns the video metadata of each job
+func jobVideos(jobs []uploadJob) []*youtube.Video {
+	var videos []*youtube.Video
+	for _, job := range jobs {
+		videos = append(videos, job.upload)
+	}
+	return videos
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+// Flags set by the config file or environment don't count, as they are only
+// defaults.
+func isFlagSet(name string) bool {
+	return cmdlineFlags[name]
+}
+
+// naturalLess compares names with runs of digits compared as numbers, so
+// that ep2 comes before ep10
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == "" || db == "" {
+			if a[0] != b[0] {
+				return a[0] < b[0]
 			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
 		}
+		a, b = a[len(da):], b[len(db):]
+	}
+	return len(a) < len(b)
+}
+
+// digitPrefix returns the run of digits at the start of s
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
 	}
+	return s[:i]
+}
 
-	if len(videoMeta.PlaylistTitles) > 0 {
-		plx.Id = ""
-		for _, title := range videoMeta.PlaylistTitles {
-			plx.Title = title
-			err = plx.AddVideoToPlaylist(service, video.Id)
+// orderFiles sorts files for -orderBy: by name, with numbers in order, or by modification time
+// oldest first. Files with the same name or time keep the order given.
+func orderFiles(files []string, by string) error {
+	switch by {
+	case "":
+	case "name":
+		sort.SliceStable(files, func(i, j int) bool {
+			return naturalLess(filepath.Base(files[i]), filepath.Base(files[j]))
+		})
+	case "mtime":
+		mtimes := make(map[string]time.Time)
+		for _, file := range files {
+			info, err := os.Stat(file)
 			if err != nil {
-				log.Fatalf("Error adding video to playlist: %s", err)
+				return fmt.Errorf("-orderBy mtime needs local files: %s", err)
 			}
+			mtimes[file] = info.ModTime()
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			return mtimes[files[i]].Before(mtimes[files[j]])
+		})
+	default:
+		return fmt.Errorf("invalid -orderBy '%s', must be 'name' or 'mtime'", by)
+	}
+	return nil
+}
+
+// expandFilenames expands any glob patterns in the list of filenames. URLs
+// are passed through unchanged and file:// URLs become local paths.
+func expandFilenames(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		pattern, err := localPath(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if isURL(pattern) || !strings.ContainsAny(pattern, "*?[") {
+			files = append(files, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filename pattern '%s': %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match '%s'", pattern)
 		}
+		files = append(files, matches...)
 	}
+	return files, nil
 }